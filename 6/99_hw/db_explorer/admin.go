@@ -0,0 +1,469 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// decimalLiteral десятичный числовой литерал MySQL для DEFAULT: 12, -0.5, .5, 1e3.
+// NaN, Inf и шестнадцатеричные числа, которые принимает strconv.ParseFloat, в DDL не подставляются
+var decimalLiteral = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// ColumnDefinition описание колонки в DDL-запросах.
+// Length - длина или точность типа (varchar(255), decimal(10,2)), Scale - число знаков после запятой у decimal.
+// Default передается строкой, для числовых типов она должна быть числом
+type ColumnDefinition struct {
+	Name          string  `json:"name"`
+	Type          string  `json:"type"`
+	Length        int     `json:"length,omitempty"`
+	Scale         int     `json:"scale,omitempty"`
+	Nullable      bool    `json:"nullable"`
+	Default       *string `json:"default,omitempty"`
+	AutoIncrement bool    `json:"auto_increment,omitempty"`
+}
+
+// TableDefinition тело запроса на создание таблицы
+type TableDefinition struct {
+	Columns    []ColumnDefinition `json:"columns"`
+	PrimaryKey string             `json:"primary_key"`
+}
+
+// IndexDefinition тело запроса на создание индекса
+type IndexDefinition struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+}
+
+// typeRule ограничения MySQL на параметры типа колонки
+type typeRule struct {
+	maxLength      int  // 0 - длина не задается
+	lengthRequired bool // без длины тип не создать: varchar, char...
+	maxScale       int  // 0 - scale не задается
+	numeric        bool // default должен быть числом
+	integer        bool // default должен быть целым, допускается auto_increment
+	noDefault      bool // TEXT, BLOB и JSON не могут иметь default
+	currentTime    bool // допускается default CURRENT_TIMESTAMP
+}
+
+// mysqlTypeRule возвращает ограничения для поддерживаемых типов MySQL
+func mysqlTypeRule(typ string) (typeRule, bool) {
+	switch typ {
+	case "tinyint", "smallint", "mediumint", "int", "bigint":
+		return typeRule{maxLength: 255, numeric: true, integer: true}, true
+	case "float", "double":
+		return typeRule{numeric: true}, true
+	case "decimal":
+		return typeRule{maxLength: 65, maxScale: 30, numeric: true}, true
+	case "char", "binary":
+		return typeRule{maxLength: 255, lengthRequired: true}, true
+	case "varchar", "varbinary":
+		return typeRule{maxLength: 65535, lengthRequired: true}, true
+	case "tinytext", "text", "mediumtext", "longtext", "tinyblob", "blob", "mediumblob", "longblob", "json":
+		return typeRule{noDefault: true}, true
+	case "date", "time", "year":
+		return typeRule{}, true
+	case "datetime", "timestamp":
+		return typeRule{currentTime: true}, true
+	}
+	return typeRule{}, false
+}
+
+// handleAdmin обрабатывает DDL-запросы /_admin/...:
+//   - PUT /_admin/$table - создать таблицу
+//   - PUT /_admin/$table/columns - добавить колонку
+//   - POST /_admin/$table/columns/$column - изменить колонку
+//   - DELETE /_admin/$table/columns/$column - удалить колонку
+//   - PUT /_admin/$table/indexes - добавить индекс
+//
+// С ?dry_run=true DDL только проверяется и возвращается, но не выполняется
+func (explorer *DbExplorer) handleAdmin(w http.ResponseWriter, r *http.Request, parts []string) {
+	if !explorer.requireAdmin(w, r) {
+		return
+	}
+
+	// DDL строится по кешу схемы, кеш обновляется только после выполнения
+	explorer.schemaMu.RLock()
+	ddl, err := explorer.buildDDL(r, parts)
+	explorer.schemaMu.RUnlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("dry_run") == "true" {
+		explorer.writeResponse(w, r, Response{
+			Response: map[string]interface{}{"ddl": ddl, "applied": false},
+		})
+		return
+	}
+
+	client := clientKey(r)
+	if _, err := explorer.exec(r.Context(), explorer.db, ddl); err != nil {
+		log.Printf("ddl failed, client %s: %s: %v", client, ddl, err)
+		writeError(w, err)
+		return
+	}
+	log.Printf("ddl applied, client %s: %s", client, ddl)
+
+	explorer.schemaMu.Lock()
+	err = explorer.loadSchema()
+	explorer.schemaMu.Unlock()
+	if err != nil {
+		log.Printf("schema refresh after ddl failed: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	explorer.writeResponse(w, r, Response{
+		Response: map[string]interface{}{"ddl": ddl, "applied": true},
+	})
+}
+
+// requireAdmin проверяет доступ к административному эндпоинту и отвечает ошибкой, если доступа нет.
+// Без токена в настройках эндпоинты не существуют
+func (explorer *DbExplorer) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if explorer.adminToken == "" {
+		writeError(w, errUnknownRoute())
+		return false
+	}
+	if !explorer.adminAuthorized(r) {
+		writeError(w, newAPIError(http.StatusUnauthorized, codeUnauthorized, "admin token required"))
+		return false
+	}
+	return true
+}
+
+// adminAuthorized проверяет заголовок Authorization: Bearer <token>
+func (explorer *DbExplorer) adminAuthorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(explorer.adminToken)) == 1
+}
+
+// buildDDL разбирает маршрут и тело запроса и формирует DDL. Вызывается под schemaMu.RLock
+func (explorer *DbExplorer) buildDDL(r *http.Request, parts []string) (string, error) {
+	if len(parts) > 1 && explorer.views[parts[0]] {
+		return "", errReadOnly()
+	}
+
+	switch {
+	case r.Method == http.MethodPut && len(parts) == 1:
+		var def TableDefinition
+		if err := decodeDefinition(r, &def); err != nil {
+			return "", err
+		}
+		return explorer.createTableDDL(parts[0], def)
+
+	case r.Method == http.MethodPut && len(parts) == 2 && parts[1] == "columns":
+		var def ColumnDefinition
+		if err := decodeDefinition(r, &def); err != nil {
+			return "", err
+		}
+		return explorer.addColumnDDL(parts[0], def)
+
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[1] == "columns":
+		var def ColumnDefinition
+		if err := decodeDefinition(r, &def); err != nil {
+			return "", err
+		}
+		return explorer.alterColumnDDL(parts[0], parts[2], def)
+
+	case r.Method == http.MethodDelete && len(parts) == 3 && parts[1] == "columns":
+		return explorer.dropColumnDDL(parts[0], parts[2])
+
+	case r.Method == http.MethodPut && len(parts) == 2 && parts[1] == "indexes":
+		var def IndexDefinition
+		if err := decodeDefinition(r, &def); err != nil {
+			return "", err
+		}
+		return explorer.addIndexDDL(parts[0], def)
+	}
+	return "", errUnknownRoute()
+}
+
+// decodeDefinition читает тело запроса. Неизвестные поля - ошибка, чтобы опечатка не меняла схему молча
+func decodeDefinition(r *http.Request, def interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(def); err != nil {
+		return errBadRequest("invalid json body")
+	}
+	return nil
+}
+
+// createTableDDL формирует CREATE TABLE. Первичный ключ обязателен: без него таблицу не прочитать по id
+func (explorer *DbExplorer) createTableDDL(table string, def TableDefinition) (string, error) {
+	if explorer.tableExists(table) {
+		return "", newAPIError(http.StatusConflict, codeConflict, "table already exists")
+	}
+	// Таблица с именем служебного маршрута не попала бы в схему, проверяем до выполнения DDL
+	if explorer.reservedTable(table) {
+		return "", newAPIError(http.StatusConflict, codeConflict, "table name is reserved for a service route")
+	}
+
+	var problems []FieldError
+	if !isIdentifier(table) || strings.HasPrefix(table, "_") {
+		problems = append(problems, FieldError{Field: "table", Code: fieldInvalidValue, Message: "field table: invalid name"})
+	}
+	if len(def.Columns) == 0 {
+		problems = append(problems, FieldError{Field: "columns", Code: fieldRequired, Message: "field columns: at least one column required"})
+	}
+
+	definitions := make([]string, 0, len(def.Columns)+1)
+	seen := make(map[string]bool, len(def.Columns))
+	for i, column := range def.Columns {
+		field := fmt.Sprintf("columns[%d].", i)
+		if seen[column.Name] {
+			problems = append(problems, FieldError{Field: field + "name", Code: fieldInvalidValue, Message: fmt.Sprintf("field %sname: duplicate column %s", field, column.Name)})
+		}
+		seen[column.Name] = true
+		if column.AutoIncrement && column.Name != def.PrimaryKey {
+			problems = append(problems, FieldError{Field: field + "auto_increment", Code: fieldInvalidValue, Message: fmt.Sprintf("field %sauto_increment: only the primary key can be auto_increment", field)})
+		}
+
+		definition, columnProblems := columnDDL(column, field)
+		problems = append(problems, columnProblems...)
+		definitions = append(definitions, definition)
+	}
+
+	if def.PrimaryKey == "" {
+		problems = append(problems, FieldError{Field: "primary_key", Code: fieldRequired, Message: "field primary_key: required"})
+	} else if !seen[def.PrimaryKey] {
+		problems = append(problems, FieldError{Field: "primary_key", Code: fieldInvalidValue, Message: fmt.Sprintf("field primary_key: unknown column %s", def.PrimaryKey)})
+	}
+
+	if len(problems) > 0 {
+		return "", errValidation(problems)
+	}
+	definitions = append(definitions, fmt.Sprintf("PRIMARY KEY (`%s`)", def.PrimaryKey))
+	return fmt.Sprintf("CREATE TABLE `%s` (%s)", table, strings.Join(definitions, ", ")), nil
+}
+
+// addColumnDDL формирует ALTER TABLE ... ADD COLUMN
+func (explorer *DbExplorer) addColumnDDL(table string, def ColumnDefinition) (string, error) {
+	if !explorer.tableExists(table) {
+		return "", errUnknownTable()
+	}
+	if _, ok := explorer.columns[table][def.Name]; ok {
+		return "", newAPIError(http.StatusConflict, codeConflict, "column already exists")
+	}
+
+	definition, problems := columnDDL(def, "")
+	if def.AutoIncrement {
+		problems = append(problems, FieldError{Field: "auto_increment", Code: fieldInvalidValue, Message: "field auto_increment: only the primary key can be auto_increment"})
+	}
+	if len(problems) > 0 {
+		return "", errValidation(problems)
+	}
+	return fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN %s", table, definition), nil
+}
+
+// alterColumnDDL формирует ALTER TABLE ... CHANGE COLUMN. Пустое имя в def - колонка не переименовывается.
+// Колонку мягкого удаления нельзя переименовать или сделать NOT NULL, первичный ключ - переименовать
+func (explorer *DbExplorer) alterColumnDDL(table, column string, def ColumnDefinition) (string, error) {
+	if !explorer.tableExists(table) {
+		return "", errUnknownTable()
+	}
+	if _, ok := explorer.columns[table][column]; !ok {
+		return "", newAPIError(http.StatusNotFound, codeNotFound, "unknown column")
+	}
+	if def.Name == "" {
+		def.Name = column
+	}
+	if def.Name != column {
+		if _, ok := explorer.columns[table][def.Name]; ok {
+			return "", newAPIError(http.StatusConflict, codeConflict, "column already exists")
+		}
+		if column == explorer.primaryKey[table] {
+			return "", newAPIError(http.StatusConflict, codeConflict, "primary key column cannot be renamed")
+		}
+	}
+	if softDelete, ok := explorer.softDelete[table]; ok && softDelete == column && (def.Name != column || !def.Nullable) {
+		return "", newAPIError(http.StatusConflict, codeConflict, "column is used for soft delete")
+	}
+
+	definition, problems := columnDDL(def, "")
+	if def.AutoIncrement && column != explorer.primaryKey[table] {
+		problems = append(problems, FieldError{Field: "auto_increment", Code: fieldInvalidValue, Message: "field auto_increment: only the primary key can be auto_increment"})
+	}
+	if len(problems) > 0 {
+		return "", errValidation(problems)
+	}
+	return fmt.Sprintf("ALTER TABLE `%s` CHANGE COLUMN `%s` %s", table, column, definition), nil
+}
+
+// dropColumnDDL формирует ALTER TABLE ... DROP COLUMN.
+// Первичный ключ и колонку мягкого удаления удалить нельзя: без них перестанут работать маршруты таблицы
+func (explorer *DbExplorer) dropColumnDDL(table, column string) (string, error) {
+	if !explorer.tableExists(table) {
+		return "", errUnknownTable()
+	}
+	if _, ok := explorer.columns[table][column]; !ok {
+		return "", newAPIError(http.StatusNotFound, codeNotFound, "unknown column")
+	}
+	if column == explorer.primaryKey[table] {
+		return "", newAPIError(http.StatusConflict, codeConflict, "primary key column cannot be dropped")
+	}
+	if explorer.softDelete[table] == column {
+		return "", newAPIError(http.StatusConflict, codeConflict, "column is used for soft delete")
+	}
+	if len(explorer.columns[table]) == 1 {
+		return "", newAPIError(http.StatusConflict, codeConflict, "last column cannot be dropped")
+	}
+	return fmt.Sprintf("ALTER TABLE `%s` DROP COLUMN `%s`", table, column), nil
+}
+
+// addIndexDDL формирует ALTER TABLE ... ADD [UNIQUE] INDEX
+func (explorer *DbExplorer) addIndexDDL(table string, def IndexDefinition) (string, error) {
+	if !explorer.tableExists(table) {
+		return "", errUnknownTable()
+	}
+	for _, index := range explorer.indexes[table] {
+		if strings.EqualFold(index.Name, def.Name) {
+			return "", newAPIError(http.StatusConflict, codeConflict, "index already exists")
+		}
+	}
+
+	var problems []FieldError
+	if !isIdentifier(def.Name) || strings.EqualFold(def.Name, "PRIMARY") {
+		problems = append(problems, FieldError{Field: "name", Code: fieldInvalidValue, Message: "field name: invalid name"})
+	}
+	if len(def.Columns) == 0 {
+		problems = append(problems, FieldError{Field: "columns", Code: fieldRequired, Message: "field columns: at least one column required"})
+	}
+	columns := make([]string, 0, len(def.Columns))
+	seen := make(map[string]bool, len(def.Columns))
+	for i, column := range def.Columns {
+		field := fmt.Sprintf("columns[%d]", i)
+		if _, ok := explorer.columns[table][column]; !ok || seen[column] {
+			problems = append(problems, FieldError{Field: field, Code: fieldInvalidValue, Message: fmt.Sprintf("field %s: unknown or duplicate column %s", field, column)})
+			continue
+		}
+		seen[column] = true
+		columns = append(columns, "`"+column+"`")
+	}
+	if len(problems) > 0 {
+		return "", errValidation(problems)
+	}
+
+	kind := "INDEX"
+	if def.Unique {
+		kind = "UNIQUE INDEX"
+	}
+	return fmt.Sprintf("ALTER TABLE `%s` ADD %s `%s` (%s)", table, kind, def.Name, strings.Join(columns, ", ")), nil
+}
+
+// columnDDL проверяет описание колонки по правилам MySQL и формирует его SQL.
+// field - префикс имен полей в ошибках, например "columns[0]."
+func columnDDL(def ColumnDefinition, field string) (string, []FieldError) {
+	var problems []FieldError
+	invalid := func(name, code, format string, args ...interface{}) {
+		problems = append(problems, FieldError{
+			Field:   field + name,
+			Code:    code,
+			Message: fmt.Sprintf("field %s%s: ", field, name) + fmt.Sprintf(format, args...),
+		})
+	}
+
+	if !isIdentifier(def.Name) {
+		invalid("name", fieldInvalidValue, "invalid name")
+	}
+	typ := strings.ToLower(def.Type)
+	rule, ok := mysqlTypeRule(typ)
+	if !ok {
+		invalid("type", fieldInvalidValue, "unsupported type %q", def.Type)
+		return "", problems
+	}
+
+	sqlType := typ
+	switch {
+	case def.Length == 0 && rule.lengthRequired:
+		invalid("length", fieldRequired, "required for %s", typ)
+	case def.Length != 0 && rule.maxLength == 0:
+		invalid("length", fieldInvalidValue, "%s has no length", typ)
+	case def.Length < 0 || def.Length > rule.maxLength:
+		invalid("length", fieldInvalidValue, "must be between 1 and %d for %s", rule.maxLength, typ)
+	case def.Scale != 0 && rule.maxScale == 0:
+		invalid("scale", fieldInvalidValue, "%s has no scale", typ)
+	case def.Scale < 0 || def.Scale > rule.maxScale || (def.Scale > 0 && def.Scale > def.Length):
+		invalid("scale", fieldInvalidValue, "must be between 0 and min(length, %d)", rule.maxScale)
+	case def.Scale > 0:
+		sqlType = fmt.Sprintf("%s(%d,%d)", typ, def.Length, def.Scale)
+	case def.Length > 0:
+		sqlType = fmt.Sprintf("%s(%d)", typ, def.Length)
+	}
+
+	parts := []string{"`" + def.Name + "`", sqlType}
+	if def.Nullable {
+		parts = append(parts, "NULL")
+	} else {
+		parts = append(parts, "NOT NULL")
+	}
+
+	if def.AutoIncrement {
+		switch {
+		case !rule.integer:
+			invalid("auto_increment", fieldInvalidValue, "only integer columns can be auto_increment")
+		case def.Nullable:
+			invalid("auto_increment", fieldInvalidValue, "auto_increment column cannot be nullable")
+		case def.Default != nil:
+			invalid("auto_increment", fieldInvalidValue, "auto_increment column cannot have a default")
+		}
+		parts = append(parts, "AUTO_INCREMENT")
+	}
+
+	if def.Default != nil {
+		value := *def.Default
+		switch {
+		case rule.noDefault:
+			invalid("default", fieldInvalidValue, "%s column cannot have a default", typ)
+		case rule.currentTime && strings.EqualFold(value, "CURRENT_TIMESTAMP"):
+			parts = append(parts, "DEFAULT CURRENT_TIMESTAMP")
+		case rule.integer:
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				invalid("default", fieldInvalidType, "must be an integer")
+			}
+			parts = append(parts, "DEFAULT "+value)
+		case rule.numeric:
+			if !decimalLiteral.MatchString(value) {
+				invalid("default", fieldInvalidType, "must be a number")
+			}
+			parts = append(parts, "DEFAULT "+value)
+		default:
+			parts = append(parts, "DEFAULT "+quoteLiteral(value))
+		}
+	}
+
+	return strings.Join(parts, " "), problems
+}
+
+// quoteLiteral экранирует строку как строковый литерал MySQL.
+// Значения по умолчанию нельзя передать в DDL плейсхолдером
+func quoteLiteral(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `''`, "\x00", `\0`).Replace(value) + "'"
+}
+
+// isIdentifier проверяет имя таблицы, колонки или индекса: до 64 символов из латиницы, цифр, _ и $,
+// не только из цифр. Такие имена безопасно подставлять в DDL в обратных кавычках
+func isIdentifier(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	digits := true
+	for _, c := range name {
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '_', c == '$', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			digits = false
+		default:
+			return false
+		}
+	}
+	return !digits
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// handleAggregate обрабатывает запрос GET /$table/_aggregate?group_by=updated&agg=count(*),max(id)
+// Возвращает сгруппированные строки, ключи - имена колонок группировки и выражения агрегатов
+func (explorer *DbExplorer) handleAggregate(w http.ResponseWriter, r *http.Request, table string) {
+	if !explorer.tableExists(table) {
+		writeError(w, errUnknownTable())
+		return
+	}
+
+	query := r.URL.Query()
+
+	// Колонки группировки: group_by=a,b
+	groupBy := make([]string, 0)
+	if groupStr := query.Get("group_by"); groupStr != "" {
+		for _, field := range strings.Split(groupStr, ",") {
+			field = strings.TrimSpace(field)
+			if _, ok := explorer.columns[table][field]; !ok {
+				writeError(w, errBadRequest(fmt.Sprintf("unknown column %s", field)))
+				return
+			}
+			groupBy = append(groupBy, fmt.Sprintf("`%s`", field))
+		}
+	}
+
+	// Агрегаты: agg=count(*),max(id)
+	aggStr := query.Get("agg")
+	if aggStr == "" {
+		writeError(w, errBadRequest("agg is required"))
+		return
+	}
+	selects := append(make([]string, 0), groupBy...)
+	for _, expr := range strings.Split(aggStr, ",") {
+		sqlExpr, err := explorer.parseAggregate(table, strings.TrimSpace(expr))
+		if err != nil {
+			writeError(w, errBadRequest(err.Error()))
+			return
+		}
+		selects = append(selects, sqlExpr)
+	}
+
+	// Фильтры такие же, как у списка записей
+	where, args := explorer.buildFilters(table, query)
+
+	sqlQuery := fmt.Sprintf("SELECT %s FROM `%s`%s", strings.Join(selects, ", "), table, where)
+	if len(groupBy) > 0 {
+		sqlQuery += fmt.Sprintf(" GROUP BY %s ORDER BY %s", strings.Join(groupBy, ", "), strings.Join(groupBy, ", "))
+	}
+
+	// Групп может быть сколько угодно, поэтому они листаются limit и offset, как записи.
+	// Без limit отдается не больше максимального числа строк
+	limit, bounded := explorer.maxLimit, explorer.maxLimit > 0
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil {
+			limit, bounded = explorer.capLimit(l), true
+		}
+	}
+	offset := 0
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o > 0 {
+			offset = o
+		}
+	}
+	// Лишняя строка показывает, что группы на странице не закончились
+	if bounded {
+		sqlQuery += " LIMIT ? OFFSET ?"
+		args = append(args, limit+1, offset)
+	} else if offset > 0 {
+		sqlQuery += " LIMIT 18446744073709551615 OFFSET ?"
+		args = append(args, offset)
+	}
+
+	records, err := explorer.queryRecords(r.Context(), explorer.router.reader(r.Context()), sqlQuery, args...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result := map[string]interface{}{"records": records}
+	if bounded && len(records) > limit {
+		result["records"] = records[:limit]
+		result["truncated"] = true
+	}
+	explorer.writeResponse(w, r, Response{
+		Response: result,
+	})
+}
+
+// parseAggregate проверяет выражение вида fn(column) по списку разрешенных функций и кешу колонок
+// и возвращает SQL для SELECT. Псевдоним совпадает с исходным выражением, например `max(id)`
+func (explorer *DbExplorer) parseAggregate(table, expr string) (string, error) {
+	open := strings.Index(expr, "(")
+	if open <= 0 || !strings.HasSuffix(expr, ")") {
+		return "", fmt.Errorf("invalid aggregate %s", expr)
+	}
+
+	fn := strings.ToLower(expr[:open])
+	field := strings.TrimSpace(expr[open+1 : len(expr)-1])
+
+	allowStar, ok := aggregateFunc(fn)
+	if !ok {
+		return "", fmt.Errorf("invalid aggregate %s", expr)
+	}
+
+	alias := fmt.Sprintf("%s(%s)", fn, field)
+	if field == "*" {
+		if !allowStar {
+			return "", fmt.Errorf("invalid aggregate %s", expr)
+		}
+		return fmt.Sprintf("%s(*) AS `%s`", strings.ToUpper(fn), alias), nil
+	}
+
+	if _, ok := explorer.columns[table][field]; !ok {
+		return "", fmt.Errorf("unknown column %s", field)
+	}
+	return fmt.Sprintf("%s(`%s`) AS `%s`", strings.ToUpper(fn), field, alias), nil
+}
+
+// aggregateFunc проверяет функцию по списку разрешенных.
+// allowStar - можно ли передавать "*" вместо имени колонки
+func aggregateFunc(fn string) (allowStar bool, ok bool) {
+	switch fn {
+	case "count":
+		return true, true
+	case "sum", "avg", "min", "max":
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMaxBlobSize ограничивает размер загружаемого значения двоичной колонки, как у MEDIUMBLOB
+const defaultMaxBlobSize = 16 << 20
+
+// isBlobColumn проверяет, что колонка - BLOB любого размера. Такие колонки не попадают в списки записей,
+// короткие BINARY и VARBINARY отдаются всегда
+func isBlobColumn(info ColumnInfo) bool {
+	base, _ := parseSQLType(info.Type)
+	return strings.HasSuffix(base, "blob")
+}
+
+// listColumns возвращает колонки для SELECT списка записей: без BLOB-колонок, если они не запрошены
+// через include_blobs=1. Колонки перечисляются в порядке таблицы, без BLOB в таблице - *
+func (explorer *DbExplorer) listColumns(table string, includeBlobs bool) string {
+	columns := explorer.columns[table]
+	names := make([]string, 0, len(columns))
+	for name, info := range columns {
+		if includeBlobs || !isBlobColumn(info) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == len(columns) {
+		return "*"
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return columns[names[i]].Position < columns[names[j]].Position
+	})
+	for i, name := range names {
+		names[i] = fmt.Sprintf("`%s`", name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// decodeBinary декодирует base64 из JSON в байты для двоичной колонки и проверяет размер,
+// байты из MessagePack только проверяет. Значения остальных колонок возвращаются как есть
+func (explorer *DbExplorer) decodeBinary(value interface{}, info ColumnInfo, field string) (interface{}, *FieldError) {
+	if normalizeType(info.Type) != "binary" {
+		return value, nil
+	}
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(v); err != nil {
+			return nil, &FieldError{Field: field, Code: fieldInvalidType,
+				Message: fmt.Sprintf("field %s is not valid base64", field)}
+		}
+	default:
+		return value, nil
+	}
+	if len(data) > explorer.maxBlobSize {
+		return nil, &FieldError{Field: field, Code: fieldInvalidValue,
+			Message: fmt.Sprintf("field %s is larger than %d bytes", field, explorer.maxBlobSize)}
+	}
+	return data, nil
+}
+
+// blobColumn проверяет, что колонка таблицы существует и двоичная, и возвращает колонку
+// с типом содержимого из WithBlobs, если она задана и есть в таблице
+func (explorer *DbExplorer) blobColumn(table, column string) (string, error) {
+	if !explorer.tableExists(table) {
+		return "", errUnknownTable()
+	}
+	info, ok := explorer.columns[table][column]
+	if !ok {
+		return "", newAPIError(http.StatusNotFound, codeNotFound, "unknown column")
+	}
+	if normalizeType(info.Type) != "binary" {
+		return "", errBadRequest(fmt.Sprintf("column %s is not binary", column))
+	}
+	contentType := explorer.blobContentTypes[table+"."+column]
+	if _, ok := explorer.columns[table][contentType]; !ok {
+		contentType = ""
+	}
+	return contentType, nil
+}
+
+// handleBlob обрабатывает запрос GET /$table/$id/$column - отдает значение двоичной колонки как есть.
+// Content-Type берется из колонки, заданной в WithBlobs, иначе application/octet-stream.
+// Тип содержимого задает загрузивший, поэтому значение всегда отдается вложением в песочнице:
+// HTML или SVG не выполнится в браузере на одном origin с /_ui.
+// NULL - 404 not_found, мягко удаленная запись отдается только с include_deleted=1
+func (explorer *DbExplorer) handleBlob(w http.ResponseWriter, r *http.Request, table, id, column string) {
+	contentTypeColumn, err := explorer.blobColumn(table, column)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	selected := fmt.Sprintf("`%s`, NULL", column)
+	if contentTypeColumn != "" {
+		selected = fmt.Sprintf("`%s`, `%s`", column, contentTypeColumn)
+	}
+	query := fmt.Sprintf("SELECT %s FROM `%s` WHERE `%s` = ?", selected, table, explorer.primaryKey[table])
+	if condition := explorer.softDeleteCondition(table, r.URL.Query().Get("include_deleted") == "1"); condition != "" {
+		query += " AND " + condition
+	}
+
+	ctx, cancel := explorer.queryContext(r.Context())
+	defer cancel()
+	defer explorer.metrics.observeQuery(query, time.Now())
+
+	rows, err := explorer.router.reader(r.Context()).QueryContext(ctx, query, id)
+	if err != nil {
+		writeError(w, statementError(ctx, err))
+		return
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			writeError(w, statementError(ctx, err))
+			return
+		}
+		writeError(w, errRecordNotFound())
+		return
+	}
+
+	// RawBytes ссылается на буфер драйвера, значение не копируется до записи в ответ
+	var data sql.RawBytes
+	var contentType sql.NullString
+	if err := rows.Scan(&data, &contentType); err != nil {
+		writeError(w, statementError(ctx, err))
+		return
+	}
+	if data == nil {
+		writeError(w, newAPIError(http.StatusNotFound, codeNotFound, "value is null"))
+		return
+	}
+	if contentType.String == "" {
+		contentType.String = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType.String)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", "attachment")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Write(data)
+}
+
+// handleBlobUpload обрабатывает запрос PUT /$table/$id/$column - записывает тело запроса в двоичную колонку.
+// Тело больше WithBlobs maxSize - 413 too_large. Content-Type запроса сохраняется в колонку типа содержимого,
+// если она задана. Мягко удаленная запись не меняется, как и при обновлении через POST
+func (explorer *DbExplorer) handleBlobUpload(w http.ResponseWriter, r *http.Request, table, id, column string) {
+	contentTypeColumn, err := explorer.blobColumn(table, column)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(explorer.maxBlobSize)))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, errTooLarge(fmt.Sprintf("body is larger than %d bytes", explorer.maxBlobSize)))
+			return
+		}
+		writeError(w, errBadRequest("invalid body"))
+		return
+	}
+
+	sets := fmt.Sprintf("`%s` = ?", column)
+	values := []interface{}{data}
+	if contentTypeColumn != "" {
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		sets += fmt.Sprintf(", `%s` = ?", contentTypeColumn)
+		values = append(values, contentType)
+	}
+	query := fmt.Sprintf("UPDATE `%s` SET %s WHERE `%s` = ?", table, sets, explorer.primaryKey[table])
+	if condition := explorer.softDeleteCondition(table, false); condition != "" {
+		query += " AND " + condition
+	}
+
+	result, err := explorer.exec(r.Context(), explorer.router.writer(r.Context()), query, append(values, id)...)
+	explorer.invalidateCache(table)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	affected, _ := result.RowsAffected()
+	explorer.writeResponse(w, r, Response{
+		Response: map[string]interface{}{
+			"updated": affected,
+		},
+	})
+}
+
+// checkBlobContentTypes проверяет колонки типа содержимого из WithBlobs по загруженной схеме:
+// ключ - двоичная колонка таблицы, значение - ее же строковая колонка
+func (explorer *DbExplorer) checkBlobContentTypes() error {
+	for _, key := range sortedKeys(explorer.blobContentTypes) {
+		table, column, _ := strings.Cut(key, ".")
+		info, ok := explorer.columns[table][column]
+		if !ok || normalizeType(info.Type) != "binary" {
+			return fmt.Errorf("blob content type: %s is not a binary column", key)
+		}
+		contentType := explorer.blobContentTypes[key]
+		if info, ok := explorer.columns[table][contentType]; !ok || normalizeType(info.Type) != "string" {
+			return fmt.Errorf("blob content type: %s.%s is not a string column", table, contentType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheEntry закешированный ответ на GET-запрос
+type cacheEntry struct {
+	key     string
+	table   string
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// size примерный объем памяти, который занимает запись
+func (entry *cacheEntry) size() int {
+	size := len(entry.key) + len(entry.table) + len(entry.body)
+	for name, values := range entry.header {
+		size += len(name)
+		for _, value := range values {
+			size += len(value)
+		}
+	}
+	return size
+}
+
+// responseCache LRU-кеш ответов на чтение записей и списков.
+// Ограничен числом записей и суммарным размером, каждая запись живет не дольше ttl.
+// Записи таблицы удаляются при любом изменении таблицы через explorer, см. invalidateCache.
+// nil - кеш выключен, invalidate, clear и writeMetrics допускают nil-получатель
+type responseCache struct {
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int
+
+	mu          sync.Mutex
+	lru         *list.List                          // от недавно использованных к давно, значения *cacheEntry
+	entries     map[string]*list.Element            // ключ -> элемент lru
+	byTable     map[string]map[string]*list.Element // таблица -> ключ -> элемент lru
+	generations map[string]uint64                   // таблица -> номер изменения
+	epoch       uint64                              // номер сброса всего кеша
+	bytes       int
+	invalidated map[string]time.Time // таблица -> время последнего изменения
+	cleared     time.Time            // время последнего сброса всего кеша
+
+	hits      map[string]uint64 // таблица -> попадания
+	misses    map[string]uint64 // таблица -> промахи
+	evictions uint64
+}
+
+// newResponseCache создает пустой кеш
+func newResponseCache(ttl time.Duration, maxEntries, maxBytes int) *responseCache {
+	return &responseCache{
+		ttl:         ttl,
+		maxEntries:  maxEntries,
+		maxBytes:    maxBytes,
+		lru:         list.New(),
+		entries:     make(map[string]*list.Element),
+		byTable:     make(map[string]map[string]*list.Element),
+		generations: make(map[string]uint64),
+		invalidated: make(map[string]time.Time),
+		hits:        make(map[string]uint64),
+		misses:      make(map[string]uint64),
+	}
+}
+
+// get возвращает живую запись и поднимает ее в начало LRU. Учитывает попадание или промах.
+// Вместе с промахом возвращается номер изменения таблицы для put
+func (cache *responseCache) get(key, table string, now time.Time) (*cacheEntry, uint64) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		if now.Before(entry.expires) {
+			cache.lru.MoveToFront(element)
+			cache.hits[table]++
+			return entry, 0
+		}
+		cache.remove(element)
+	}
+	cache.misses[table]++
+	return nil, cache.generation(table)
+}
+
+// generation номер изменения таблицы, меняется и при сбросе всего кеша. Вызывается под mu
+func (cache *responseCache) generation(table string) uint64 {
+	return cache.epoch + cache.generations[table]
+}
+
+// put сохраняет ответ, если таблица не менялась с момента промаха (generation из get).
+// Иначе ответ мог быть прочитан до записи и сохранил бы устаревшие данные.
+// Вытесняет давно использованные записи, пока кеш не уложится в ограничения
+func (cache *responseCache) put(entry *cacheEntry, generation uint64, now time.Time) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	size := entry.size()
+	if cache.generation(entry.table) != generation || size > cache.maxBytes {
+		return
+	}
+	if element, ok := cache.entries[entry.key]; ok {
+		cache.remove(element)
+	}
+
+	entry.expires = now.Add(cache.ttl)
+	element := cache.lru.PushFront(entry)
+	cache.entries[entry.key] = element
+	if cache.byTable[entry.table] == nil {
+		cache.byTable[entry.table] = make(map[string]*list.Element)
+	}
+	cache.byTable[entry.table][entry.key] = element
+	cache.bytes += size
+
+	for cache.lru.Len() > cache.maxEntries || cache.bytes > cache.maxBytes {
+		cache.remove(cache.lru.Back())
+		cache.evictions++
+	}
+}
+
+// invalidate удаляет записи таблиц и меняет их номер изменения
+func (cache *responseCache) invalidate(tables ...string) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now()
+	for _, table := range tables {
+		cache.generations[table]++
+		cache.invalidated[table] = now
+		for _, element := range cache.byTable[table] {
+			cache.remove(element)
+		}
+	}
+}
+
+// clear удаляет все записи, например после изменения схемы
+func (cache *responseCache) clear() {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.epoch++
+	cache.cleared = time.Now()
+	for cache.lru.Len() > 0 {
+		cache.remove(cache.lru.Back())
+	}
+}
+
+// recentlyInvalidated проверяет, менялась ли таблица или сбрасывался весь кеш в течение window до now.
+// Реплики в это время могут еще не видеть изменение
+func (cache *responseCache) recentlyInvalidated(table string, window time.Duration, now time.Time) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	changed := cache.invalidated[table]
+	if cache.cleared.After(changed) {
+		changed = cache.cleared
+	}
+	return !changed.IsZero() && now.Sub(changed) < window
+}
+
+// remove удаляет элемент из всех индексов кеша, вызывается под mu
+func (cache *responseCache) remove(element *list.Element) {
+	entry := cache.lru.Remove(element).(*cacheEntry)
+	delete(cache.entries, entry.key)
+	delete(cache.byTable[entry.table], entry.key)
+	if len(cache.byTable[entry.table]) == 0 {
+		delete(cache.byTable, entry.table)
+	}
+	cache.bytes -= entry.size()
+}
+
+// writeMetrics пишет счетчики кеша в текстовом формате Prometheus
+func (cache *responseCache) writeMetrics(w io.Writer) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP db_explorer_cache_hits_total Responses served from the cache.")
+	fmt.Fprintln(w, "# TYPE db_explorer_cache_hits_total counter")
+	for _, table := range sortedKeys(cache.hits) {
+		fmt.Fprintf(w, "db_explorer_cache_hits_total{%s} %d\n", formatLabel("table", table), cache.hits[table])
+	}
+	fmt.Fprintln(w, "# HELP db_explorer_cache_misses_total Cacheable requests that went to the database.")
+	fmt.Fprintln(w, "# TYPE db_explorer_cache_misses_total counter")
+	for _, table := range sortedKeys(cache.misses) {
+		fmt.Fprintf(w, "db_explorer_cache_misses_total{%s} %d\n", formatLabel("table", table), cache.misses[table])
+	}
+	fmt.Fprintln(w, "# HELP db_explorer_cache_evictions_total Entries evicted to fit the cache limits.")
+	fmt.Fprintln(w, "# TYPE db_explorer_cache_evictions_total counter")
+	fmt.Fprintf(w, "db_explorer_cache_evictions_total %d\n", cache.evictions)
+	fmt.Fprintln(w, "# HELP db_explorer_cache_entries Number of cached responses.")
+	fmt.Fprintln(w, "# TYPE db_explorer_cache_entries gauge")
+	fmt.Fprintf(w, "db_explorer_cache_entries %d\n", cache.lru.Len())
+	fmt.Fprintln(w, "# HELP db_explorer_cache_bytes Approximate size of cached responses.")
+	fmt.Fprintln(w, "# TYPE db_explorer_cache_bytes gauge")
+	fmt.Fprintf(w, "db_explorer_cache_bytes %d\n", cache.bytes)
+}
+
+// cacheRecorder собирает ответ обработчика, чтобы сохранить его в кеш
+type cacheRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *cacheRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+	return rec.body.Write(b)
+}
+
+func (rec *cacheRecorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+// serveCached отдает ответ на чтение записи или списка из кеша, а при промахе вызывает handler
+// и сохраняет успешный ответ. Ключ - путь и query-параметры в отсортированном порядке.
+// В течение stickyWindow после изменения таблицы промах читается из primary:
+// реплика могла еще не получить изменение, и устаревший ответ жил бы в кеше до конца ttl
+func (explorer *DbExplorer) serveCached(w http.ResponseWriter, r *http.Request, table string, handler func(w http.ResponseWriter, r *http.Request)) {
+	if explorer.cache == nil || !explorer.tableExists(table) {
+		handler(w, r)
+		return
+	}
+
+	// Один и тот же ресурс кодируется по-разному в зависимости от Accept
+	key := "/" + strings.Trim(r.URL.Path, "/") + "?" + r.URL.Query().Encode() + " " + r.Header.Get("Accept")
+	entry, generation := explorer.cache.get(key, table, time.Now())
+	if entry != nil {
+		for name, values := range entry.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("X-Cache", "HIT")
+		w.Write(entry.body)
+		return
+	}
+
+	if explorer.cache.recentlyInvalidated(table, explorer.stickyWindow, time.Now()) {
+		r = r.WithContext(context.WithValue(r.Context(), primaryReadCtx{}, true))
+	}
+	rec := &cacheRecorder{header: make(http.Header), status: http.StatusOK}
+	handler(rec, r)
+	if rec.status == http.StatusOK {
+		explorer.cache.put(&cacheEntry{
+			key:    key,
+			table:  table,
+			header: rec.header.Clone(),
+			body:   rec.body.Bytes(),
+		}, generation, time.Now())
+	}
+
+	for name, values := range rec.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("X-Cache", "MISS")
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+}
+
+// invalidateCache удаляет из кеша ответы таблицы после записи в нее.
+// Вместе с таблицей сбрасываются таблицы, ссылающиеся на нее внешними ключами:
+// ON DELETE CASCADE и SET NULL меняют их строки без запросов к ним. Представления сбрасываются всегда
+func (explorer *DbExplorer) invalidateCache(table string) {
+	if explorer.cache == nil {
+		return
+	}
+
+	affected := []string{table}
+	seen := map[string]bool{table: true}
+	// Зависимости представлений от таблиц не известны, поэтому сбрасываются все представления
+	for view := range explorer.views {
+		if !seen[view] {
+			seen[view] = true
+			affected = append(affected, view)
+		}
+	}
+	for i := 0; i < len(affected); i++ {
+		for child, fks := range explorer.foreignKeys {
+			for _, fk := range fks {
+				if fk.RefTable == affected[i] && !seen[child] {
+					seen[child] = true
+					affected = append(affected, child)
+				}
+			}
+		}
+	}
+	explorer.cache.invalidate(affected...)
+}
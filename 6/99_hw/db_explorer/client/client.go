@@ -0,0 +1,270 @@
+// Package client типизированный клиент REST API db_explorer.
+//
+// Записи читаются как Record или в свои структуры через List и Get:
+//
+//	c := client.New("http://localhost:8082")
+//	page, err := client.List[Item](ctx, c, "items", client.ListOptions{Limit: 10, Count: true})
+//
+// Ошибки API возвращаются как *Error с кодом из ответа explorer
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Record запись таблицы как ее отдает explorer. Числа приходят json.Number, NULL - nil
+type Record map[string]interface{}
+
+// Client клиент одного explorer. Для базы из /db/$name базовый адрес указывается вместе с префиксом
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	header     http.Header
+}
+
+// Option настраивает Client при создании
+type Option func(c *Client)
+
+// WithHTTPClient задает http.Client для запросов, по умолчанию http.DefaultClient
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithHeader добавляет заголовок ко всем запросам, например авторизацию прокси перед explorer
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		c.header.Add(key, value)
+	}
+}
+
+// New создает клиент explorer по базовому адресу: http://host:port или http://host:port/db/$name
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		header:     http.Header{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// ListOptions параметры GET /$table
+type ListOptions struct {
+	// Limit 0 - лимит explorer по умолчанию
+	Limit  int
+	Offset int
+	// Filters отбирает записи по равенству колонки значению
+	Filters map[string]string
+	// Count запрашивает точное общее количество записей с учетом фильтров (count=exact)
+	Count bool
+	// IncludeDeleted показывает мягко удаленные записи
+	IncludeDeleted bool
+}
+
+// query параметры запроса для ListOptions
+func (opts ListOptions) query() url.Values {
+	query := url.Values{}
+	for column, value := range opts.Filters {
+		query.Set(column, value)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		query.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	if opts.Count {
+		query.Set("count", "exact")
+	}
+	if opts.IncludeDeleted {
+		query.Set("include_deleted", "1")
+	}
+	return query
+}
+
+// Page страница записей. Total заполняется только с ListOptions.Count
+type Page[T any] struct {
+	Records []T   `json:"records"`
+	Total   int64 `json:"total"`
+}
+
+// List читает страницу записей таблицы в структуры T. Поля T сопоставляются колонкам по тегам json
+func List[T any](ctx context.Context, c *Client, table string, opts ListOptions) (*Page[T], error) {
+	page := &Page[T]{}
+	if err := c.do(ctx, http.MethodGet, "/"+url.PathEscape(table), opts.query(), nil, page); err != nil {
+		return nil, err
+	}
+	if page.Records == nil {
+		page.Records = []T{}
+	}
+	return page, nil
+}
+
+// Get читает запись по первичному ключу в структуру T
+func Get[T any](ctx context.Context, c *Client, table, id string) (*T, error) {
+	var result struct {
+		Record *T `json:"record"`
+	}
+	if err := c.do(ctx, http.MethodGet, recordPath(table, id), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	if result.Record == nil {
+		return nil, &Error{Status: http.StatusNotFound, Code: CodeNotFound, Message: "record not found"}
+	}
+	return result.Record, nil
+}
+
+// Tables возвращает таблицы и представления, доступные через explorer
+func (c *Client) Tables(ctx context.Context) ([]string, error) {
+	var result struct {
+		Tables []string `json:"tables"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Tables, nil
+}
+
+// List читает страницу записей таблицы
+func (c *Client) List(ctx context.Context, table string, opts ListOptions) (*Page[Record], error) {
+	return List[Record](ctx, c, table, opts)
+}
+
+// Get читает запись по первичному ключу
+func (c *Client) Get(ctx context.Context, table, id string) (Record, error) {
+	record, err := Get[Record](ctx, c, table, id)
+	if err != nil {
+		return nil, err
+	}
+	return *record, nil
+}
+
+// Create создает запись и возвращает ее первичный ключ в виде для пути /$table/$id.
+// record - Record, map или структура с тегами json. Автоинкрементный ключ explorer игнорирует,
+// у структуры его удобно пометить omitempty
+func (c *Client) Create(ctx context.Context, table string, record interface{}) (string, error) {
+	var result map[string]interface{}
+	if err := c.do(ctx, http.MethodPut, "/"+url.PathEscape(table)+"/", nil, record, &result); err != nil {
+		return "", err
+	}
+	// Ответ - {"$primary_key": id}
+	for _, id := range result {
+		return fmt.Sprint(id), nil
+	}
+	return "", fmt.Errorf("client: create %s: no id in response", table)
+}
+
+// Update обновляет переданные поля записи и возвращает число измененных строк.
+// Первичный ключ менять нельзя: у структуры его нужно пометить omitempty или передать Record
+func (c *Client) Update(ctx context.Context, table, id string, fields interface{}) (int64, error) {
+	var result struct {
+		Updated int64 `json:"updated"`
+	}
+	if err := c.do(ctx, http.MethodPost, recordPath(table, id), nil, fields, &result); err != nil {
+		return 0, err
+	}
+	return result.Updated, nil
+}
+
+// Delete удаляет запись и возвращает число удаленных строк: 0, если записи не было
+func (c *Client) Delete(ctx context.Context, table, id string) (int64, error) {
+	var result struct {
+		Deleted int64 `json:"deleted"`
+	}
+	if err := c.do(ctx, http.MethodDelete, recordPath(table, id), nil, nil, &result); err != nil {
+		return 0, err
+	}
+	return result.Deleted, nil
+}
+
+func recordPath(table, id string) string {
+	return "/" + url.PathEscape(table) + "/" + url.PathEscape(id)
+}
+
+// envelope конверт ответа explorer: {"response": ...} или {"error": ..., "code": ..., "details": [...]}
+type envelope struct {
+	Response json.RawMessage `json:"response"`
+	Error    string          `json:"error"`
+	Code     string          `json:"code"`
+	Details  []FieldError    `json:"details"`
+}
+
+// do выполняет запрос к explorer и раскладывает поле response в result.
+// body кодируется в JSON, ошибка API возвращается как *Error
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("client: encode body: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var answer envelope
+	if err := json.Unmarshal(data, &answer); err != nil {
+		if resp.StatusCode >= http.StatusBadRequest {
+			return &Error{Status: resp.StatusCode, Code: statusCode(resp.StatusCode), Message: http.StatusText(resp.StatusCode)}
+		}
+		return fmt.Errorf("client: %s %s: invalid response: %w", method, path, err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest || answer.Error != "" {
+		apiErr := &Error{Status: resp.StatusCode, Code: answer.Code, Message: answer.Error, Details: answer.Details}
+		if apiErr.Code == "" {
+			apiErr.Code = statusCode(resp.StatusCode)
+		}
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return apiErr
+	}
+	if result == nil || len(answer.Response) == 0 {
+		return nil
+	}
+
+	// UseNumber сохраняет точность BIGINT в Record и полях interface{}
+	decoder := json.NewDecoder(bytes.NewReader(answer.Response))
+	decoder.UseNumber()
+	if err := decoder.Decode(result); err != nil {
+		return fmt.Errorf("client: %s %s: decode response: %w", method, path, err)
+	}
+	return nil
+}
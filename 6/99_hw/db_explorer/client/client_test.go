@@ -0,0 +1,262 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type item struct {
+	ID          int64   `json:"id,omitempty"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Updated     *string `json:"updated"`
+}
+
+// request запрос, который увидел тестовый сервер
+type request struct {
+	method, uri, contentType, auth string
+	body                           map[string]interface{}
+}
+
+// newServer отвечает status и body на любой запрос и сохраняет последний запрос в last
+func newServer(t *testing.T, status int, body string, last *request) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*last = request{
+			method:      r.Method,
+			uri:         r.URL.RequestURI(),
+			contentType: r.Header.Get("Content-Type"),
+			auth:        r.Header.Get("Authorization"),
+		}
+		if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+			if err := json.Unmarshal(data, &last.body); err != nil {
+				t.Errorf("invalid request body %s: %v", data, err)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestList(t *testing.T) {
+	var last request
+	ts := newServer(t, http.StatusOK, `{"response":{"records":[
+		{"id":1,"title":"database/sql","description":"Рассказать про базы данных","updated":"rvasily"},
+		{"id":2,"title":"memcache","description":"Рассказать про мемкеш","updated":null}
+	],"total":7}}`, &last)
+	c := New(ts.URL)
+	ctx := context.Background()
+
+	page, err := List[item](ctx, c, "items", ListOptions{
+		Limit:          2,
+		Offset:         4,
+		Filters:        map[string]string{"title": "a b"},
+		Count:          true,
+		IncludeDeleted: true,
+	})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if expected := "/items?count=exact&include_deleted=1&limit=2&offset=4&title=a+b"; last.method != http.MethodGet || last.uri != expected {
+		t.Errorf("expected GET %s, got %s %s", expected, last.method, last.uri)
+	}
+	rvasily := "rvasily"
+	expected := &Page[item]{
+		Records: []item{
+			{ID: 1, Title: "database/sql", Description: "Рассказать про базы данных", Updated: &rvasily},
+			{ID: 2, Title: "memcache", Description: "Рассказать про мемкеш"},
+		},
+		Total: 7,
+	}
+	if !reflect.DeepEqual(page, expected) {
+		t.Errorf("expected %+v, got %+v", expected, page)
+	}
+
+	records, err := c.List(ctx, "items", ListOptions{})
+	if err != nil {
+		t.Fatalf("Client.List: %v", err)
+	}
+	if last.uri != "/items" {
+		t.Errorf("expected /items without parameters, got %s", last.uri)
+	}
+	if id := records.Records[0]["id"]; id != json.Number("1") {
+		t.Errorf("expected id json.Number 1, got %#v", id)
+	}
+	if updated := records.Records[1]["updated"]; updated != nil {
+		t.Errorf("expected NULL as nil, got %#v", updated)
+	}
+}
+
+func TestListEmpty(t *testing.T) {
+	var last request
+	ts := newServer(t, http.StatusOK, `{"response":{"records":[]}}`, &last)
+
+	page, err := List[item](context.Background(), New(ts.URL), "items", ListOptions{})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if page.Records == nil || len(page.Records) != 0 || page.Total != 0 {
+		t.Errorf("expected empty page, got %+v", page)
+	}
+}
+
+func TestGet(t *testing.T) {
+	var last request
+	ts := newServer(t, http.StatusOK, `{"response":{"record":{"id":9007199254740993,"title":"big"}}}`, &last)
+	c := New(ts.URL+"/db/archive/", WithHeader("Authorization", "Bearer secret"))
+	ctx := context.Background()
+
+	got, err := Get[item](ctx, c, "my items", "a/b")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if expected := "/db/archive/my%20items/a%2Fb"; last.uri != expected {
+		t.Errorf("expected path %s, got %s", expected, last.uri)
+	}
+	if last.auth != "Bearer secret" {
+		t.Errorf("expected Authorization header from WithHeader, got %q", last.auth)
+	}
+	if got.ID != 9007199254740993 || got.Title != "big" {
+		t.Errorf("unexpected record %+v", got)
+	}
+
+	record, err := c.Get(ctx, "items", "1")
+	if err != nil {
+		t.Fatalf("Client.Get: %v", err)
+	}
+	// BIGINT больше 2^53 не теряет точность
+	if record["id"] != json.Number("9007199254740993") {
+		t.Errorf("expected exact id, got %#v", record["id"])
+	}
+}
+
+func TestCreateUpdateDelete(t *testing.T) {
+	var last request
+	ctx := context.Background()
+
+	ts := newServer(t, http.StatusOK, `{"response":{"id":3}}`, &last)
+	id, err := New(ts.URL).Create(ctx, "items", item{Title: "new", Description: "created"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if id != "3" {
+		t.Errorf("expected id 3, got %q", id)
+	}
+	expectedBody := map[string]interface{}{"title": "new", "description": "created", "updated": nil}
+	if last.method != http.MethodPut || last.uri != "/items/" || last.contentType != "application/json" || !reflect.DeepEqual(last.body, expectedBody) {
+		t.Errorf("unexpected create request %+v", last)
+	}
+
+	ts = newServer(t, http.StatusOK, `{"response":{"updated":1}}`, &last)
+	updated, err := New(ts.URL).Update(ctx, "items", "3", Record{"title": "changed"})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if updated != 1 || last.method != http.MethodPost || last.uri != "/items/3" || last.body["title"] != "changed" {
+		t.Errorf("unexpected update %d %+v", updated, last)
+	}
+
+	ts = newServer(t, http.StatusOK, `{"response":{"deleted":0}}`, &last)
+	deleted, err := New(ts.URL).Delete(ctx, "items", "3")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if deleted != 0 || last.method != http.MethodDelete || last.uri != "/items/3" || last.body != nil {
+		t.Errorf("unexpected delete %d %+v", deleted, last)
+	}
+}
+
+func TestTables(t *testing.T) {
+	var last request
+	ts := newServer(t, http.StatusOK, `{"response":{"tables":["items","users"],"views":["active_users"]}}`, &last)
+
+	tables, err := New(ts.URL).Tables(context.Background())
+	if err != nil {
+		t.Fatalf("Tables: %v", err)
+	}
+	if !reflect.DeepEqual(tables, []string{"items", "users"}) || last.uri != "/" {
+		t.Errorf("unexpected tables %v from %s", tables, last.uri)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, c := range []struct {
+		name     string
+		status   int
+		body     string
+		expected *Error
+		notFound bool
+	}{
+		{
+			name:   "validation",
+			status: http.StatusBadRequest,
+			body: `{"error":"field title have invalid type","code":"validation_failed",
+				"details":[{"field":"title","code":"invalid_type","message":"field title have invalid type"}]}`,
+			expected: &Error{
+				Status:  http.StatusBadRequest,
+				Code:    CodeValidationFailed,
+				Message: "field title have invalid type",
+				Details: []FieldError{{Field: "title", Code: FieldInvalidType, Message: "field title have invalid type"}},
+			},
+		},
+		{
+			name:     "not found",
+			status:   http.StatusNotFound,
+			body:     `{"error":"record not found","code":"not_found"}`,
+			expected: &Error{Status: http.StatusNotFound, Code: CodeNotFound, Message: "record not found"},
+			notFound: true,
+		},
+		{
+			name:     "unknown table",
+			status:   http.StatusNotFound,
+			body:     `{"error":"unknown table","code":"unknown_table"}`,
+			expected: &Error{Status: http.StatusNotFound, Code: CodeUnknownTable, Message: "unknown table"},
+			notFound: true,
+		},
+		{
+			name:     "proxy",
+			status:   http.StatusBadGateway,
+			body:     `<html>bad gateway</html>`,
+			expected: &Error{Status: http.StatusBadGateway, Code: CodeUnavailable, Message: "Bad Gateway"},
+		},
+	} {
+		var last request
+		ts := newServer(t, c.status, c.body, &last)
+
+		_, err := New(ts.URL).Update(ctx, "items", "1", Record{"title": 42})
+		var apiErr *Error
+		if !errors.As(err, &apiErr) {
+			t.Errorf("[%s] expected *Error, got %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(apiErr, c.expected) {
+			t.Errorf("[%s] expected %+v, got %+v", c.name, c.expected, apiErr)
+		}
+		if IsNotFound(err) != c.notFound {
+			t.Errorf("[%s] expected IsNotFound %v", c.name, c.notFound)
+		}
+	}
+
+	var last request
+	ts := newServer(t, http.StatusBadRequest, `{"error":"field title have invalid type","code":"validation_failed",
+		"details":[{"field":"title","code":"invalid_type","message":"field title have invalid type"}]}`, &last)
+	_, err := New(ts.URL).Create(ctx, "items", Record{"title": 42})
+	if ErrorCode(err) != CodeValidationFailed {
+		t.Errorf("expected validation_failed, got %v", err)
+	}
+	if field := err.(*Error).Field("title"); field == nil || field.Code != FieldInvalidType {
+		t.Errorf("expected invalid_type for title, got %+v", field)
+	}
+	if ErrorCode(errors.New("other")) != "" {
+		t.Errorf("expected no code for non-API error")
+	}
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Коды ошибок API db_explorer. Совпадают с полем code ответа и не меняются между версиями
+const (
+	CodeUnknownTable     = "unknown_table"
+	CodeUnknownDatabase  = "unknown_database"
+	CodeUnknownRoute     = "unknown_route"
+	CodeNotFound         = "not_found"
+	CodeBadRequest       = "bad_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeReadOnly         = "read_only"
+	CodeValidationFailed = "validation_failed"
+	CodeTooLarge         = "too_large"
+	CodeConflict         = "conflict"
+	CodeRateLimited      = "rate_limited"
+	CodeQueryTimeout     = "query_timeout"
+	CodeUnavailable      = "unavailable"
+	CodeInternal         = "internal"
+)
+
+// Коды ошибок отдельных полей в Details
+const (
+	FieldInvalidType  = "invalid_type"
+	FieldReadOnly     = "read_only"
+	FieldRequired     = "required"
+	FieldInvalidValue = "invalid_value"
+)
+
+// Error ошибка, которую вернул explorer: HTTP-статус и поля error, code, details ответа.
+// Ответ не в формате API (например, от прокси) дает Error с кодом по статусу и текстом статуса
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Details []FieldError
+}
+
+// FieldError ошибка одного поля в ответе validation_failed
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Field возвращает ошибку поля из Details или nil
+func (e *Error) Field(name string) *FieldError {
+	for i := range e.Details {
+		if e.Details[i].Field == name {
+			return &e.Details[i]
+		}
+	}
+	return nil
+}
+
+// ErrorCode возвращает код ошибки API или пустую строку, если err - не ошибка explorer
+func ErrorCode(err error) string {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return ""
+}
+
+// IsNotFound проверяет, что запись, таблица или маршрут не найдены
+func IsNotFound(err error) bool {
+	switch ErrorCode(err) {
+	case CodeNotFound, CodeUnknownTable, CodeUnknownDatabase, CodeUnknownRoute:
+		return true
+	}
+	return false
+}
+
+// statusCode код ошибки для ответа без тела API
+func statusCode(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusRequestEntityTooLarge:
+		return CodeTooLarge
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable, http.StatusBadGateway:
+		return CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeQueryTimeout
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
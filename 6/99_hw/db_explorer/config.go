@@ -0,0 +1,731 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix префикс переменных окружения, переопределяющих конфиг: DB_EXPLORER_DSN и т.д.
+const envPrefix = "DB_EXPLORER_"
+
+// Config настройки сервера. Источники применяются по порядку, каждый следующий переопределяет предыдущий:
+// значения по умолчанию, YAML-файл, переменные окружения, флаги командной строки
+type Config struct {
+	DSN    string `yaml:"dsn"`
+	Listen string `yaml:"listen"`
+	// ShutdownTimeout сколько ждать завершения начатых запросов после SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	TLS             struct {
+		Cert string `yaml:"cert"`
+		Key  string `yaml:"key"`
+	} `yaml:"tls"`
+	Tables     FilterConfig `yaml:"tables"`
+	Procedures FilterConfig `yaml:"procedures"`
+	// SoftDelete таблицы с мягким удалением: имя таблицы -> колонка с временем удаления
+	SoftDelete map[string]string `yaml:"soft_delete"`
+	Limits     struct {
+		Default int `yaml:"default"`
+		Max     int `yaml:"max"`
+	} `yaml:"limits"`
+	// QueryTimeout таймаут одного запроса к базе, 0 - без таймаута
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+	// RateLimit лимиты частоты запросов, нулевые - без лимитов
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	// AdminToken включает DDL-эндпоинты /_admin/..., пустой - эндпоинты отключены
+	AdminToken string `yaml:"admin_token"`
+	// Pool настройки пула соединений sql.DB, 0 - значение database/sql по умолчанию
+	Pool struct {
+		MaxOpen         int           `yaml:"max_open"`
+		MaxIdle         int           `yaml:"max_idle"`
+		ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	} `yaml:"pool"`
+	// Cache кеш ответов на чтение, TTL 0 - кеш выключен
+	Cache struct {
+		TTL        time.Duration `yaml:"ttl"`
+		MaxEntries int           `yaml:"max_entries"`
+		MaxBytes   int           `yaml:"max_bytes"`
+	} `yaml:"cache"`
+	// Blobs двоичные колонки: предельный размер загружаемого значения и колонки с типом содержимого
+	Blobs BlobsConfig `yaml:"blobs"`
+	// Replicas реплики для чтения базы по умолчанию
+	Replicas ReplicasConfig `yaml:"replicas"`
+	// Databases именованные базы, доступные по /db/$name/..., в дополнение к базе DSN.
+	// Лимиты, кеш и пул соединений у них общие с базой по умолчанию
+	Databases map[string]DatabaseConfig `yaml:"databases"`
+	// Queries именованные SQL-запросы, доступные по GET /_queries/$name
+	Queries map[string]NamedQuery `yaml:"queries"`
+}
+
+// FilterConfig ограничивает набор таблиц или процедур, доступных через API.
+// Пустой Allow - доступны все, кроме Deny
+type FilterConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// BlobsConfig настройки двоичных колонок. ContentTypes - "table.column" -> колонка с типом содержимого.
+// У именованных баз MaxSize общий с базой по умолчанию
+type BlobsConfig struct {
+	MaxSize      int               `yaml:"max_size"`
+	ContentTypes map[string]string `yaml:"content_types"`
+}
+
+// ReplicasConfig реплики для чтения: DSN каждой реплики, сколько после записи читать из primary
+// и как часто проверять реплики. У именованных баз StickyWindow и HealthCheck общие с базой по умолчанию
+type ReplicasConfig struct {
+	DSNs         []string      `yaml:"dsns"`
+	StickyWindow time.Duration `yaml:"sticky_window"`
+	HealthCheck  time.Duration `yaml:"health_check"`
+}
+
+// RateLimitConfig лимиты по умолчанию на чтение и запись и лимиты отдельных таблиц.
+// У именованных баз Read и Write общие с базой по умолчанию
+type RateLimitConfig struct {
+	Read   RateLimit                       `yaml:"read"`
+	Write  RateLimit                       `yaml:"write"`
+	Tables map[string]TableRateLimitConfig `yaml:"tables"`
+}
+
+// TableRateLimitConfig лимиты таблицы вместо лимитов по умолчанию
+type TableRateLimitConfig struct {
+	Read  RateLimit `yaml:"read"`
+	Write RateLimit `yaml:"write"`
+}
+
+// DatabaseConfig настройки именованной базы: подключение и доступ
+type DatabaseConfig struct {
+	DSN        string                `yaml:"dsn"`
+	Tables     FilterConfig          `yaml:"tables"`
+	Procedures FilterConfig          `yaml:"procedures"`
+	AdminToken string                `yaml:"admin_token"`
+	Queries    map[string]NamedQuery `yaml:"queries"`
+	Blobs      BlobsConfig           `yaml:"blobs"`
+	SoftDelete map[string]string     `yaml:"soft_delete"`
+	Replicas   ReplicasConfig        `yaml:"replicas"`
+	RateLimit  RateLimitConfig       `yaml:"rate_limit"`
+}
+
+// defaultConfig конфиг, с которым сервер запускается без файла, окружения и флагов
+func defaultConfig() Config {
+	var config Config
+	config.DSN = DSN
+	config.Listen = ":8082"
+	config.ShutdownTimeout = 15 * time.Second
+	config.Limits.Default = 5
+	config.Limits.Max = 1000
+	config.QueryTimeout = 30 * time.Second
+	config.Cache.MaxEntries = 10000
+	config.Cache.MaxBytes = 64 << 20
+	config.Blobs.MaxSize = defaultMaxBlobSize
+	config.Replicas.StickyWindow = 2 * time.Second
+	config.Replicas.HealthCheck = 5 * time.Second
+	return config
+}
+
+// loadConfig собирает конфиг из файла, окружения и флагов и проверяет его.
+// Путь к файлу задается флагом -config или переменной DB_EXPLORER_CONFIG, без него файл не читается.
+// Все найденные ошибки возвращаются разом, чтобы их можно было исправить за один запуск
+func loadConfig(args []string, getenv func(string) string, output io.Writer) (Config, error) {
+	config := defaultConfig()
+
+	fs := flag.NewFlagSet("db_explorer", flag.ContinueOnError)
+	fs.SetOutput(output)
+	var flags Config
+	var allow, deny, allowProcedures, denyProcedures, replicas, readLimit, writeLimit string
+	path := fs.String("config", getenv(envPrefix+"CONFIG"), "path to YAML config file")
+	fs.StringVar(&flags.DSN, "dsn", "", "MySQL DSN")
+	fs.StringVar(&flags.Listen, "listen", "", "listen address, e.g. :8082")
+	fs.DurationVar(&flags.ShutdownTimeout, "shutdown-timeout", 0, "time to drain in-flight requests on shutdown")
+	fs.StringVar(&flags.TLS.Cert, "tls-cert", "", "TLS certificate file")
+	fs.StringVar(&flags.TLS.Key, "tls-key", "", "TLS key file")
+	fs.StringVar(&allow, "tables-allow", "", "comma-separated tables exposed by the API")
+	fs.StringVar(&deny, "tables-deny", "", "comma-separated tables hidden from the API")
+	fs.StringVar(&allowProcedures, "procedures-allow", "", "comma-separated stored procedures exposed at /_rpc")
+	fs.StringVar(&denyProcedures, "procedures-deny", "", "comma-separated stored procedures hidden from /_rpc")
+	fs.IntVar(&flags.Limits.Default, "default-limit", 0, "default page size")
+	fs.IntVar(&flags.Limits.Max, "max-limit", 0, "maximum page size, 0 - unlimited")
+	fs.DurationVar(&flags.QueryTimeout, "query-timeout", 0, "timeout of a single database query, 0 - no timeout")
+	fs.StringVar(&readLimit, "rate-limit-read", "", "default read rate limit per client and table, rate/burst")
+	fs.StringVar(&writeLimit, "rate-limit-write", "", "default write rate limit per client and table, rate/burst")
+	fs.StringVar(&flags.AdminToken, "admin-token", "", "bearer token for /_admin DDL endpoints")
+	fs.IntVar(&flags.Pool.MaxOpen, "max-open-conns", 0, "maximum open connections")
+	fs.IntVar(&flags.Pool.MaxIdle, "max-idle-conns", 0, "maximum idle connections")
+	fs.DurationVar(&flags.Pool.ConnMaxLifetime, "conn-max-lifetime", 0, "maximum connection lifetime")
+	fs.DurationVar(&flags.Cache.TTL, "cache-ttl", 0, "response cache TTL, 0 - cache disabled")
+	fs.IntVar(&flags.Cache.MaxEntries, "cache-max-entries", 0, "maximum cached responses")
+	fs.IntVar(&flags.Cache.MaxBytes, "cache-max-bytes", 0, "maximum total size of cached responses")
+	fs.IntVar(&flags.Blobs.MaxSize, "blob-max-size", 0, "maximum size of an uploaded binary column value")
+	fs.StringVar(&replicas, "replicas", "", "comma-separated read replica DSNs")
+	fs.DurationVar(&flags.Replicas.StickyWindow, "sticky-window", 0, "time after a write during which the client reads from the primary")
+	fs.DurationVar(&flags.Replicas.HealthCheck, "replica-health-check", 0, "read replica health check interval")
+	if err := fs.Parse(args); err != nil {
+		return config, err
+	}
+	if fs.NArg() > 0 {
+		return config, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if *path != "" {
+		if err := readConfigFile(*path, &config); err != nil {
+			return config, err
+		}
+	}
+
+	var problems []error
+	if err := applyEnv(&config, getenv); err != nil {
+		problems = append(problems, err)
+	}
+
+	// Флаги применяются, только если заданы явно, иначе их нулевые значения затерли бы файл и окружение
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "dsn":
+			config.DSN = flags.DSN
+		case "listen":
+			config.Listen = flags.Listen
+		case "shutdown-timeout":
+			config.ShutdownTimeout = flags.ShutdownTimeout
+		case "tls-cert":
+			config.TLS.Cert = flags.TLS.Cert
+		case "tls-key":
+			config.TLS.Key = flags.TLS.Key
+		case "tables-allow":
+			config.Tables.Allow = splitList(allow)
+		case "tables-deny":
+			config.Tables.Deny = splitList(deny)
+		case "procedures-allow":
+			config.Procedures.Allow = splitList(allowProcedures)
+		case "procedures-deny":
+			config.Procedures.Deny = splitList(denyProcedures)
+		case "default-limit":
+			config.Limits.Default = flags.Limits.Default
+		case "max-limit":
+			config.Limits.Max = flags.Limits.Max
+		case "query-timeout":
+			config.QueryTimeout = flags.QueryTimeout
+		case "rate-limit-read", "rate-limit-write":
+			dst, value := &config.RateLimit.Read, readLimit
+			if f.Name == "rate-limit-write" {
+				dst, value = &config.RateLimit.Write, writeLimit
+			}
+			limit, err := parseRateLimit(value)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("-%s: %v", f.Name, err))
+				return
+			}
+			*dst = limit
+		case "admin-token":
+			config.AdminToken = flags.AdminToken
+		case "max-open-conns":
+			config.Pool.MaxOpen = flags.Pool.MaxOpen
+		case "max-idle-conns":
+			config.Pool.MaxIdle = flags.Pool.MaxIdle
+		case "conn-max-lifetime":
+			config.Pool.ConnMaxLifetime = flags.Pool.ConnMaxLifetime
+		case "cache-ttl":
+			config.Cache.TTL = flags.Cache.TTL
+		case "cache-max-entries":
+			config.Cache.MaxEntries = flags.Cache.MaxEntries
+		case "cache-max-bytes":
+			config.Cache.MaxBytes = flags.Cache.MaxBytes
+		case "blob-max-size":
+			config.Blobs.MaxSize = flags.Blobs.MaxSize
+		case "replicas":
+			config.Replicas.DSNs = splitList(replicas)
+		case "sticky-window":
+			config.Replicas.StickyWindow = flags.Replicas.StickyWindow
+		case "replica-health-check":
+			config.Replicas.HealthCheck = flags.Replicas.HealthCheck
+		}
+	})
+
+	problems = append(problems, config.validate())
+	return config, errors.Join(problems...)
+}
+
+// commandConfig собирает конфиг для подкоманд migrate и gen: файл, переменные окружения и флаг -dsn.
+// Остальные настройки сервера подкомандам не нужны и не проверяются
+func commandConfig(path, dsn string, getenv func(string) string) (Config, error) {
+	config := defaultConfig()
+	if path != "" {
+		if err := readConfigFile(path, &config); err != nil {
+			return config, err
+		}
+	}
+	if err := applyEnv(&config, getenv); err != nil {
+		return config, err
+	}
+	if dsn != "" {
+		config.DSN = dsn
+	}
+	if _, err := mysql.ParseDSN(config.DSN); err != nil {
+		return config, fmt.Errorf("dsn: %w", err)
+	}
+	return config, nil
+}
+
+// readConfigFile читает YAML поверх значений по умолчанию. Неизвестные ключи считаются ошибкой,
+// чтобы опечатка в имени настройки не проходила молча
+func readConfigFile(path string, config *Config) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && err != io.EOF {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv переопределяет конфиг переменными окружения DB_EXPLORER_*.
+// Списки таблиц задаются через запятую, соответствия - как "key=value,key=value",
+// длительности - в формате time.ParseDuration, лимиты частоты - как "rate/burst"
+func applyEnv(config *Config, getenv func(string) string) error {
+	var problems []error
+	str := func(name string, dst *string) {
+		if value := getenv(envPrefix + name); value != "" {
+			*dst = value
+		}
+	}
+	list := func(name string, dst *[]string) {
+		if value := getenv(envPrefix + name); value != "" {
+			*dst = splitList(value)
+		}
+	}
+	mapping := func(name string, dst *map[string]string) {
+		if value := getenv(envPrefix + name); value != "" {
+			m, err := splitMapping(value)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s%s: %v", envPrefix, name, err))
+				return
+			}
+			*dst = m
+		}
+	}
+	integer := func(name string, dst *int) {
+		if value := getenv(envPrefix + name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s%s: %q is not an integer", envPrefix, name, value))
+				return
+			}
+			*dst = n
+		}
+	}
+	rateLimit := func(name string, dst *RateLimit) {
+		if value := getenv(envPrefix + name); value != "" {
+			limit, err := parseRateLimit(value)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s%s: %v", envPrefix, name, err))
+				return
+			}
+			*dst = limit
+		}
+	}
+	// tableRateLimits задает лимит на чтение или запись таблиц из "table=rate/burst,..."
+	tableRateLimits := func(name string, write bool) {
+		var limits map[string]string
+		mapping(name, &limits)
+		for _, table := range sortedKeys(limits) {
+			limit, err := parseRateLimit(limits[table])
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s%s: %s: %v", envPrefix, name, table, err))
+				continue
+			}
+			if config.RateLimit.Tables == nil {
+				config.RateLimit.Tables = make(map[string]TableRateLimitConfig)
+			}
+			tableLimit := config.RateLimit.Tables[table]
+			if write {
+				tableLimit.Write = limit
+			} else {
+				tableLimit.Read = limit
+			}
+			config.RateLimit.Tables[table] = tableLimit
+		}
+	}
+	duration := func(name string, dst *time.Duration) {
+		if value := getenv(envPrefix + name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s%s: %q is not a duration", envPrefix, name, value))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	str("DSN", &config.DSN)
+	str("LISTEN", &config.Listen)
+	duration("SHUTDOWN_TIMEOUT", &config.ShutdownTimeout)
+	str("TLS_CERT", &config.TLS.Cert)
+	str("TLS_KEY", &config.TLS.Key)
+	list("TABLES_ALLOW", &config.Tables.Allow)
+	list("TABLES_DENY", &config.Tables.Deny)
+	list("PROCEDURES_ALLOW", &config.Procedures.Allow)
+	list("PROCEDURES_DENY", &config.Procedures.Deny)
+	mapping("SOFT_DELETE", &config.SoftDelete)
+	integer("DEFAULT_LIMIT", &config.Limits.Default)
+	integer("MAX_LIMIT", &config.Limits.Max)
+	duration("QUERY_TIMEOUT", &config.QueryTimeout)
+	rateLimit("RATE_LIMIT_READ", &config.RateLimit.Read)
+	rateLimit("RATE_LIMIT_WRITE", &config.RateLimit.Write)
+	tableRateLimits("RATE_LIMIT_TABLES_READ", false)
+	tableRateLimits("RATE_LIMIT_TABLES_WRITE", true)
+	str("ADMIN_TOKEN", &config.AdminToken)
+	integer("MAX_OPEN_CONNS", &config.Pool.MaxOpen)
+	integer("MAX_IDLE_CONNS", &config.Pool.MaxIdle)
+	duration("CONN_MAX_LIFETIME", &config.Pool.ConnMaxLifetime)
+	duration("CACHE_TTL", &config.Cache.TTL)
+	integer("CACHE_MAX_ENTRIES", &config.Cache.MaxEntries)
+	integer("CACHE_MAX_BYTES", &config.Cache.MaxBytes)
+	integer("BLOB_MAX_SIZE", &config.Blobs.MaxSize)
+	list("REPLICAS", &config.Replicas.DSNs)
+	duration("STICKY_WINDOW", &config.Replicas.StickyWindow)
+	duration("REPLICA_HEALTH_CHECK", &config.Replicas.HealthCheck)
+	return errors.Join(problems...)
+}
+
+// validate проверяет конфиг целиком. Ошибки называют настройку так же, как она называется в YAML
+func (config Config) validate() error {
+	var problems []error
+	invalid := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if err := validateDSN(config.DSN); err != nil {
+		invalid("dsn: %v", err)
+	}
+
+	if _, _, err := net.SplitHostPort(config.Listen); err != nil {
+		invalid("listen: %v", err)
+	}
+
+	if config.ShutdownTimeout <= 0 {
+		invalid("shutdown_timeout: must be positive, got %s", config.ShutdownTimeout)
+	}
+
+	if (config.TLS.Cert == "") != (config.TLS.Key == "") {
+		invalid("tls: cert and key must be set together")
+	}
+	for _, file := range []struct{ name, path string }{{"tls.cert", config.TLS.Cert}, {"tls.key", config.TLS.Key}} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			invalid("%s: %v", file.name, err)
+		}
+	}
+
+	for _, table := range config.Tables.conflicts() {
+		invalid("tables: %s is both allowed and denied", table)
+	}
+	for _, procedure := range config.Procedures.conflicts() {
+		invalid("procedures: %s is both allowed and denied", procedure)
+	}
+
+	for _, name := range sortedKeys(config.Databases) {
+		database := config.Databases[name]
+		if !isIdentifier(name) {
+			invalid("databases: invalid name %q", name)
+		}
+		if err := validateDSN(database.DSN); err != nil {
+			invalid("databases.%s.dsn: %v", name, err)
+		}
+		for _, table := range database.Tables.conflicts() {
+			invalid("databases.%s.tables: %s is both allowed and denied", name, table)
+		}
+		for _, procedure := range database.Procedures.conflicts() {
+			invalid("databases.%s.procedures: %s is both allowed and denied", name, procedure)
+		}
+		if _, err := compileQueries(database.Queries); err != nil {
+			invalid("databases.%s.%v", name, err)
+		}
+		if database.Blobs.MaxSize != 0 {
+			invalid("databases.%s.blobs.max_size: set blobs.max_size instead, it is shared", name)
+		}
+		for _, err := range database.Blobs.problems() {
+			invalid("databases.%s.blobs.%v", name, err)
+		}
+		for _, err := range softDeleteProblems(database.SoftDelete) {
+			invalid("databases.%s.%v", name, err)
+		}
+		for i, dsn := range database.Replicas.DSNs {
+			if err := validateDSN(dsn); err != nil {
+				invalid("databases.%s.replicas.dsns[%d]: %v", name, i, err)
+			}
+		}
+		if database.Replicas.StickyWindow != 0 || database.Replicas.HealthCheck != 0 {
+			invalid("databases.%s.replicas: set replicas.sticky_window and replicas.health_check instead, they are shared", name)
+		}
+		if database.RateLimit.Read != (RateLimit{}) || database.RateLimit.Write != (RateLimit{}) {
+			invalid("databases.%s.rate_limit: set rate_limit.read and rate_limit.write instead, they are shared", name)
+		}
+		for _, err := range database.RateLimit.problems() {
+			invalid("databases.%s.rate_limit.%v", name, err)
+		}
+	}
+
+	if _, err := compileQueries(config.Queries); err != nil {
+		problems = append(problems, err)
+	}
+	problems = append(problems, softDeleteProblems(config.SoftDelete)...)
+
+	if config.Limits.Default <= 0 {
+		invalid("limits.default: must be positive, got %d", config.Limits.Default)
+	}
+	if config.Limits.Max < 0 {
+		invalid("limits.max: must not be negative, got %d", config.Limits.Max)
+	}
+	if config.Limits.Max > 0 && config.Limits.Default > config.Limits.Max {
+		invalid("limits.default: %d is greater than limits.max %d", config.Limits.Default, config.Limits.Max)
+	}
+	if config.QueryTimeout < 0 {
+		invalid("query_timeout: must not be negative, got %s", config.QueryTimeout)
+	}
+	for _, err := range config.RateLimit.problems() {
+		invalid("rate_limit.%v", err)
+	}
+
+	if config.Pool.MaxOpen < 0 {
+		invalid("pool.max_open: must not be negative, got %d", config.Pool.MaxOpen)
+	}
+	if config.Pool.MaxIdle < 0 {
+		invalid("pool.max_idle: must not be negative, got %d", config.Pool.MaxIdle)
+	}
+	if config.Pool.MaxOpen > 0 && config.Pool.MaxIdle > config.Pool.MaxOpen {
+		invalid("pool.max_idle: %d is greater than pool.max_open %d", config.Pool.MaxIdle, config.Pool.MaxOpen)
+	}
+	if config.Pool.ConnMaxLifetime < 0 {
+		invalid("pool.conn_max_lifetime: must not be negative, got %s", config.Pool.ConnMaxLifetime)
+	}
+
+	if config.Cache.TTL < 0 {
+		invalid("cache.ttl: must not be negative, got %s", config.Cache.TTL)
+	}
+	if config.Cache.TTL > 0 && config.Cache.MaxEntries <= 0 {
+		invalid("cache.max_entries: must be positive, got %d", config.Cache.MaxEntries)
+	}
+	if config.Cache.TTL > 0 && config.Cache.MaxBytes <= 0 {
+		invalid("cache.max_bytes: must be positive, got %d", config.Cache.MaxBytes)
+	}
+
+	if config.Blobs.MaxSize <= 0 {
+		invalid("blobs.max_size: must be positive, got %d", config.Blobs.MaxSize)
+	}
+	for _, err := range config.Blobs.problems() {
+		invalid("blobs.%v", err)
+	}
+
+	for i, dsn := range config.Replicas.DSNs {
+		if err := validateDSN(dsn); err != nil {
+			invalid("replicas.dsns[%d]: %v", i, err)
+		}
+	}
+	if config.Replicas.StickyWindow < 0 {
+		invalid("replicas.sticky_window: must not be negative, got %s", config.Replicas.StickyWindow)
+	}
+	if config.Replicas.HealthCheck <= 0 {
+		invalid("replicas.health_check: must be positive, got %s", config.Replicas.HealthCheck)
+	}
+
+	return errors.Join(problems...)
+}
+
+// validateDSN проверяет, что DSN задан и разбирается драйвером
+func validateDSN(dsn string) error {
+	if dsn == "" {
+		return errors.New("must not be empty")
+	}
+	_, err := mysql.ParseDSN(dsn)
+	return err
+}
+
+// conflicts возвращает имена, указанные одновременно в allow и deny
+func (filter FilterConfig) conflicts() []string {
+	denied := make(map[string]bool, len(filter.Deny))
+	for _, name := range filter.Deny {
+		denied[name] = true
+	}
+	var conflicts []string
+	for _, name := range filter.Allow {
+		if denied[name] {
+			conflicts = append(conflicts, name)
+		}
+	}
+	return conflicts
+}
+
+// problems проверяет, что ключи и значения content_types - имена "table.column" и колонок
+func (blobs BlobsConfig) problems() []error {
+	var problems []error
+	for _, key := range sortedKeys(blobs.ContentTypes) {
+		table, column, ok := strings.Cut(key, ".")
+		if !ok || !isIdentifier(table) || !isIdentifier(column) {
+			problems = append(problems, fmt.Errorf("content_types: %q is not table.column", key))
+		}
+		if !isIdentifier(blobs.ContentTypes[key]) {
+			problems = append(problems, fmt.Errorf("content_types.%s: invalid column %q", key, blobs.ContentTypes[key]))
+		}
+	}
+	return problems
+}
+
+// problems проверяет, что лимиты частоты конечные и не отрицательные
+func (rateLimit RateLimitConfig) problems() []error {
+	var problems []error
+	check := func(name string, limit RateLimit) {
+		if !(limit.Rate >= 0) || math.IsInf(limit.Rate, 1) {
+			problems = append(problems, fmt.Errorf("%s.rate: must be a finite non-negative number, got %g", name, limit.Rate))
+		}
+		if limit.Burst < 0 {
+			problems = append(problems, fmt.Errorf("%s.burst: must not be negative, got %d", name, limit.Burst))
+		}
+	}
+	check("read", rateLimit.Read)
+	check("write", rateLimit.Write)
+	for _, table := range sortedKeys(rateLimit.Tables) {
+		check("tables."+table+".read", rateLimit.Tables[table].Read)
+		check("tables."+table+".write", rateLimit.Tables[table].Write)
+	}
+	return problems
+}
+
+// rateLimitOptions опции лимитов частоты. Лимиты по умолчанию задаются, только если они не нулевые:
+// иначе ответы получали бы заголовки X-RateLimit-* без самих лимитов
+func rateLimitOptions(read, write RateLimit, tables map[string]TableRateLimitConfig) []Option {
+	var options []Option
+	if read != (RateLimit{}) || write != (RateLimit{}) {
+		options = append(options, WithRateLimit(read, write))
+	}
+	for _, table := range sortedKeys(tables) {
+		options = append(options, WithTableRateLimit(table, tables[table].Read, tables[table].Write))
+	}
+	return options
+}
+
+// softDeleteProblems проверяет, что ключи и значения soft_delete - имена таблиц и колонок.
+// Существование колонки проверяется при загрузке схемы
+func softDeleteProblems(softDelete map[string]string) []error {
+	var problems []error
+	for _, table := range sortedKeys(softDelete) {
+		if !isIdentifier(table) {
+			problems = append(problems, fmt.Errorf("soft_delete: invalid table %q", table))
+		}
+		if !isIdentifier(softDelete[table]) {
+			problems = append(problems, fmt.Errorf("soft_delete.%s: invalid column %q", table, softDelete[table]))
+		}
+	}
+	return problems
+}
+
+// softDeleteOptions опции WithSoftDelete для каждой таблицы из soft_delete
+func softDeleteOptions(softDelete map[string]string) []Option {
+	options := make([]Option, 0, len(softDelete))
+	for _, table := range sortedKeys(softDelete) {
+		options = append(options, WithSoftDelete(table, softDelete[table]))
+	}
+	return options
+}
+
+// options переводит конфиг в опции NewDbExplorer базы по умолчанию.
+// replicas - пулы, открытые по replicas.dsns
+func (config Config) options(replicas []*sql.DB) []Option {
+	options := []Option{
+		WithTables(config.Tables.Allow, config.Tables.Deny),
+		WithProcedures(config.Procedures.Allow, config.Procedures.Deny),
+		WithDefaultLimit(config.Limits.Default),
+		WithMaxLimit(config.Limits.Max),
+		WithQueryTimeout(config.QueryTimeout),
+		WithAdminToken(config.AdminToken),
+		WithCache(config.Cache.TTL, config.Cache.MaxEntries, config.Cache.MaxBytes),
+		WithDatabases(sortedKeys(config.Databases)...),
+		WithQueries(config.Queries),
+		WithBlobs(config.Blobs.MaxSize, config.Blobs.ContentTypes),
+		WithReplicas(replicas...),
+		WithStickyWindow(config.Replicas.StickyWindow),
+		WithReplicaHealthCheck(config.Replicas.HealthCheck),
+	}
+	options = append(options, rateLimitOptions(config.RateLimit.Read, config.RateLimit.Write, config.RateLimit.Tables)...)
+	return append(options, softDeleteOptions(config.SoftDelete)...)
+}
+
+// databaseOptions опции NewDbExplorer именованной базы: свои таблицы, токен, запросы и реплики,
+// общие лимиты и кеш
+func (config Config) databaseOptions(name string, replicas []*sql.DB) []Option {
+	database := config.Databases[name]
+	options := []Option{
+		WithTables(database.Tables.Allow, database.Tables.Deny),
+		WithProcedures(database.Procedures.Allow, database.Procedures.Deny),
+		WithDefaultLimit(config.Limits.Default),
+		WithMaxLimit(config.Limits.Max),
+		WithQueryTimeout(config.QueryTimeout),
+		WithAdminToken(database.AdminToken),
+		WithCache(config.Cache.TTL, config.Cache.MaxEntries, config.Cache.MaxBytes),
+		WithQueries(database.Queries),
+		WithBlobs(config.Blobs.MaxSize, database.Blobs.ContentTypes),
+		WithReplicas(replicas...),
+		WithStickyWindow(config.Replicas.StickyWindow),
+		WithReplicaHealthCheck(config.Replicas.HealthCheck),
+	}
+	options = append(options, rateLimitOptions(config.RateLimit.Read, config.RateLimit.Write, database.RateLimit.Tables)...)
+	return append(options, softDeleteOptions(database.SoftDelete)...)
+}
+
+// splitMapping разбирает соответствия "key=value" через запятую, пропуская пустые элементы
+func splitMapping(value string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, item := range splitList(value) {
+		key, val, ok := strings.Cut(item, "=")
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if !ok || key == "" || val == "" {
+			return nil, fmt.Errorf("%q is not key=value", item)
+		}
+		mapping[key] = val
+	}
+	return mapping, nil
+}
+
+// parseRateLimit разбирает лимит частоты "rate/burst": "10/20" - 10 запросов в секунду, не больше 20 подряд
+func parseRateLimit(value string) (RateLimit, error) {
+	rate, burst, ok := strings.Cut(value, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("%q is not rate/burst", value)
+	}
+	var limit RateLimit
+	var err error
+	if limit.Rate, err = strconv.ParseFloat(strings.TrimSpace(rate), 64); err != nil {
+		return RateLimit{}, fmt.Errorf("%q: rate is not a number", value)
+	}
+	if limit.Burst, err = strconv.Atoi(strings.TrimSpace(burst)); err != nil {
+		return RateLimit{}, fmt.Errorf("%q: burst is not an integer", value)
+	}
+	return limit, nil
+}
+
+// splitList разбирает список через запятую, пропуская пустые элементы
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
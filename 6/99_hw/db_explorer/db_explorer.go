@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
+	"encoding/base64"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
 )
 
 // экземпляр структуры хранится только внутри функции NewDbExplorer,
@@ -15,15 +23,77 @@ type DbExplorer struct {
 	db *sql.DB
 	// 1. запрос всех таблиц можно кешировать. Они не меняются по тз.
 	// 2. primaryKey необходимо для выполнения запроса на получение записи по id (where)
-	tables     []string
-	primaryKey map[string]string // tableName -> primaryKeyName
+	// 3. columns - кеш колонок, по нему проверяются имена полей в фильтрах и агрегатах
+	// 4. foreignKeys - связи между таблицами, из них строятся вложенные поля GraphQL
+	// 5. indexes - индексы таблиц для /$table/_schema
+	// 6. views - представления из SHOW FULL TABLES, они доступны только на чтение
+	// 7. procedures - хранимые процедуры для /_rpc
+	// Кеш обновляется после DDL (см. admin.go), поэтому запросы читают его под schemaMu.RLock
+	schemaMu    sync.RWMutex
+	tables      []string
+	primaryKey  map[string]string                // tableName -> primaryKeyName
+	columns     map[string]map[string]ColumnInfo // tableName -> columnName -> ColumnInfo
+	foreignKeys map[string][]ForeignKey          // tableName -> внешние ключи таблицы
+	indexes     map[string][]Index               // tableName -> индексы таблицы
+	views       map[string]bool                  // tableName -> таблица является представлением
+	procedures  map[string]Procedure             // имя -> хранимая процедура
+	softDelete  map[string]string                // tableName -> колонка с отметкой об удалении
+
+	// Реплики для чтения, db - primary. router выбирает подключение для каждого запроса
+	replicas       []*sql.DB
+	stickyWindow   time.Duration
+	healthInterval time.Duration
+	router         *dbRouter
+
+	// allowTables и denyTables ограничивают набор таблиц, попадающих в кеш,
+	// allowProcedures и denyProcedures - набор процедур
+	allowTables     []string
+	denyTables      []string
+	allowProcedures []string
+	denyProcedures  []string
+
+	// queryTimeout ограничивает каждый запрос к базе, maxLimit - размер одной выборки,
+	// defaultLimit - размер выборки, если limit не передан
+	queryTimeout time.Duration
+	maxLimit     int
+	defaultLimit int
+
+	// maxBlobSize ограничивает загружаемое значение двоичной колонки,
+	// blobContentTypes - "table.column" -> колонка с типом содержимого
+	maxBlobSize      int
+	blobContentTypes map[string]string
+
+	rateLimiter *rateLimiter
+	metrics     *metrics
+
+	// cache кеш ответов на чтение, nil - выключен
+	cache *responseCache
+
+	// codecs форматы ответов и тел запросов, см. encoding.go
+	codecs *codecRegistry
+
+	// databases именованные базы, доступные через /db/$name, для GET /
+	databases []string
+
+	// namedQueries именованные запросы из WithQueries, queries - они же после проверки в NewDbExplorer
+	namedQueries map[string]NamedQuery
+	queries      map[string]*compiledQuery
+
+	// adminToken открывает доступ к DDL-эндпоинтам, пустой - эндпоинты отключены
+	adminToken string
+
+	// graphqlSchema генерируется один раз по закешированным таблицам
+	graphqlSchema graphql.Schema
 }
 
 // Response универсальный ответ, который будет маршалиться для ответа в тела ответов.
 // omitempty - не будет сериализован в JSON, если значение пустое
+// Code и Details заполняются только для ошибок, см. APIError
 type Response struct {
-	Response interface{} `json:"response,omitempty"`
-	Error    string      `json:"error,omitempty"`
+	Response interface{}  `json:"response,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Code     string       `json:"code,omitempty"`
+	Details  []FieldError `json:"details,omitempty"`
 }
 
 // ColumnInfo хранит метаданные о колонке таблицы:
@@ -31,69 +101,295 @@ type Response struct {
 // 2. Nullable - может ли поле быть null, используется при:
 //   - валидации входящих данных
 //   - автозаполнении NOT NULL полей пустыми значениями при создании записи
+//
+// 3. Default, Key, AutoIncrement и Position отдаются клиентам в /$table/_schema
 type ColumnInfo struct {
-	Type     string
-	Nullable bool
+	Type          string
+	Nullable      bool
+	Default       *string // nil - значения по умолчанию нет
+	Key           string  // PRI, UNI, MUL или пусто, как в SHOW COLUMNS
+	AutoIncrement bool
+	Position      int // порядковый номер колонки в таблице, с 0
+}
+
+// ForeignKey описывает внешний ключ: Column текущей таблицы ссылается на RefTable.RefColumn
+type ForeignKey struct {
+	Column    string
+	RefTable  string
+	RefColumn string
 }
 
 // Конструктор DbExplorer
-func NewDbExplorer(db *sql.DB) (http.Handler, error) {
+func NewDbExplorer(db *sql.DB, options ...Option) (http.Handler, error) {
 	explorer := &DbExplorer{
 		db:         db,
-		primaryKey: make(map[string]string),
+		softDelete: make(map[string]string),
+
+		stickyWindow:   2 * time.Second,
+		healthInterval: 5 * time.Second,
+
+		queryTimeout: 30 * time.Second,
+		maxLimit:     1000,
+		defaultLimit: 5,
+		maxBlobSize:  defaultMaxBlobSize,
+
+		rateLimiter: newRateLimiter(),
+		metrics:     newMetrics(),
+		codecs:      newCodecRegistry(),
+	}
+	for _, option := range options {
+		option(explorer)
 	}
 
-	// Первоначальный запрос для кеширования данных о таблицах и их первичных ключах
-	rows, err := db.Query("SHOW TABLES")
+	queries, err := compileQueries(explorer.namedQueries)
 	if err != nil {
 		return nil, err
 	}
-	// Обязательно закрываем строку, чтобы не было утечки ресурсов
-	defer rows.Close()
+	explorer.queries = queries
 
-	tables := make([]string, 0)
-	for rows.Next() {
-		var tableName string
-		if err := rows.Scan(&tableName); err != nil {
-			return nil, err
+	explorer.router = newDbRouter(db, explorer.replicas, explorer.stickyWindow)
+
+	if err := explorer.loadSchema(); err != nil {
+		return nil, err
+	}
+	if err := explorer.checkBlobContentTypes(); err != nil {
+		return nil, err
+	}
+
+	// Проверка реплик запускается, только когда explorer создан: ее останавливает Close
+	if len(explorer.replicas) > 0 && explorer.healthInterval > 0 {
+		go explorer.router.watch(explorer.healthInterval)
+	}
+
+	// Метрики собираются снаружи ServeHTTP, чтобы учитывать все ответы, включая 429 и 404
+	return &metricsMiddleware{explorer: explorer}, nil
+}
+
+// loadSchema загружает в кеш таблицы, колонки, ключи и индексы и строит по ним схему GraphQL.
+// Вызывается при создании DbExplorer и после DDL под schemaMu.Lock.
+// Если загрузка не удалась, кеш остается прежним
+func (explorer *DbExplorer) loadSchema() (err error) {
+	prevTables, prevPrimaryKey, prevColumns := explorer.tables, explorer.primaryKey, explorer.columns
+	prevForeignKeys, prevIndexes, prevGraphqlSchema := explorer.foreignKeys, explorer.indexes, explorer.graphqlSchema
+	prevViews, prevProcedures := explorer.views, explorer.procedures
+	defer func() {
+		if err != nil {
+			explorer.tables, explorer.primaryKey, explorer.columns = prevTables, prevPrimaryKey, prevColumns
+			explorer.foreignKeys, explorer.indexes, explorer.graphqlSchema = prevForeignKeys, prevIndexes, prevGraphqlSchema
+			explorer.views, explorer.procedures = prevViews, prevProcedures
 		}
-		tables = append(tables, tableName)
+	}()
+
+	explorer.tables = nil
+	explorer.primaryKey = make(map[string]string)
+	explorer.columns = make(map[string]map[string]ColumnInfo)
+	explorer.foreignKeys = make(map[string][]ForeignKey)
+	explorer.indexes = make(map[string][]Index)
+	explorer.views = make(map[string]bool)
+
+	// Первоначальный запрос для кеширования данных о таблицах и их первичных ключах
+	tables, tableTypes, err := showTables(explorer.db)
+	if err != nil {
+		return err
 	}
-	rows.Close()
+
+	tables, err = filterNames("table", tables, explorer.allowTables, explorer.denyTables)
+	if err != nil {
+		return err
+	}
+	visible := make([]string, 0, len(tables))
+	for _, tableName := range tables {
+		// Служебная таблица миграций: запись в нее через API испортила бы учет примененных версий
+		if tableName == migrationsTable {
+			continue
+		}
+		// Маршрут проверяется раньше таблицы, через REST такая таблица была бы недоступна.
+		// Ошибка здесь не давала бы стартовать и обновлять схему из-за одной таблицы
+		if explorer.reservedTable(tableName) {
+			log.Printf("table %s skipped: /%s is a service route, rename the table or add it to tables.deny", tableName, tableName)
+			continue
+		}
+		if tableTypes[tableName] == "VIEW" {
+			explorer.views[tableName] = true
+		}
+		visible = append(visible, tableName)
+	}
+	tables = visible
 
 	// Для каждой таблицы получаем информацию о её колонках
 	for _, tableName := range tables {
-		// Запрашиваем структуру таблицы
-		colRows, err := db.Query(fmt.Sprintf("SHOW COLUMNS FROM `%s`", tableName))
+		tableColumns, primaryKey, err := showColumns(explorer.db, tableName)
 		if err != nil {
-			return nil, err
-		}
-
-		// Обрабатываем каждую колонку таблицы
-		for colRows.Next() {
-			// field - имя колонки
-			// typ - тип данных
-			// null - может ли быть NULL
-			// key - тип ключа (PRI для первичного)
-			// def - значение по умолчанию
-			// extra - дополнительные свойства
-			var field, typ, null, key, extra string
-			var def sql.NullString
-			if err := colRows.Scan(&field, &typ, &null, &key, &def, &extra); err != nil {
-				colRows.Close()
-				return nil, err
-			}
-			// Если это первичный ключ - сохраняем его имя для данной таблицы
-			if key == "PRI" {
-				explorer.primaryKey[tableName] = field
-			}
+			return err
+		}
+		// Если есть первичный ключ - сохраняем его имя для данной таблицы
+		if primaryKey != "" {
+			explorer.primaryKey[tableName] = primaryKey
 		}
-		colRows.Close()
+		explorer.columns[tableName] = tableColumns
 		// Добавляем таблицу в список известных таблиц
 		explorer.tables = append(explorer.tables, tableName)
 	}
 
-	return explorer, nil
+	if err := explorer.loadForeignKeys(); err != nil {
+		return err
+	}
+
+	if err := explorer.loadIndexes(); err != nil {
+		return err
+	}
+
+	if err := explorer.validateSoftDelete(); err != nil {
+		return err
+	}
+
+	if err := explorer.loadProcedures(); err != nil {
+		return err
+	}
+
+	explorer.graphqlSchema, err = explorer.buildGraphQLSchema()
+	if err != nil {
+		return err
+	}
+
+	// Схема изменилась, закешированные ответы могут не совпадать с ней
+	explorer.cache.clear()
+	return nil
+}
+
+// showTables возвращает таблицы текущей базы и их типы. FULL добавляет тип: BASE TABLE или VIEW
+func showTables(db *sql.DB) ([]string, map[string]string, error) {
+	rows, err := db.Query("SHOW FULL TABLES")
+	if err != nil {
+		return nil, nil, err
+	}
+	// Обязательно закрываем строку, чтобы не было утечки ресурсов
+	defer rows.Close()
+
+	tables := make([]string, 0)
+	tableTypes := make(map[string]string)
+	for rows.Next() {
+		var tableName, tableType string
+		if err := rows.Scan(&tableName, &tableType); err != nil {
+			return nil, nil, err
+		}
+		tables = append(tables, tableName)
+		tableTypes[tableName] = tableType
+	}
+	return tables, tableTypes, rows.Err()
+}
+
+// showColumns читает структуру таблицы: колонки и имя первичного ключа, пустое - если ключа нет
+func showColumns(db *sql.DB, table string) (map[string]ColumnInfo, string, error) {
+	rows, err := db.Query(fmt.Sprintf("SHOW COLUMNS FROM `%s`", table))
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]ColumnInfo)
+	primaryKey := ""
+	// Обрабатываем каждую колонку таблицы
+	for position := 0; rows.Next(); position++ {
+		// field - имя колонки
+		// typ - тип данных
+		// null - может ли быть NULL
+		// key - тип ключа (PRI для первичного)
+		// def - значение по умолчанию
+		// extra - дополнительные свойства
+		var field, typ, null, key, extra string
+		var def sql.NullString
+		if err := rows.Scan(&field, &typ, &null, &key, &def, &extra); err != nil {
+			return nil, "", err
+		}
+		if key == "PRI" {
+			primaryKey = field
+		}
+		columns[field] = ColumnInfo{
+			Type:          typ,
+			Nullable:      null == "YES",
+			Default:       columnDefault(def),
+			Key:           key,
+			AutoIncrement: strings.Contains(strings.ToLower(extra), "auto_increment"),
+			Position:      position,
+		}
+	}
+	return columns, primaryKey, rows.Err()
+}
+
+// filterNames оставляет таблицы или процедуры (kind), разрешенные allow и deny, в исходном порядке.
+// Пустой allow разрешает все, имя из allow, которого нет в базе, - ошибка
+func filterNames(kind string, names, allow, deny []string) ([]string, error) {
+	existing := make(map[string]bool, len(names))
+	for _, name := range names {
+		existing[name] = true
+	}
+	allowed := make(map[string]bool, len(allow))
+	for _, name := range allow {
+		if !existing[name] {
+			return nil, fmt.Errorf("%ss: unknown %s %s in allow list", kind, kind, name)
+		}
+		allowed[name] = true
+	}
+	denied := make(map[string]bool, len(deny))
+	for _, name := range deny {
+		denied[name] = true
+	}
+
+	filtered := make([]string, 0, len(names))
+	for _, name := range names {
+		if (len(allowed) == 0 || allowed[name]) && !denied[name] {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered, nil
+}
+
+// loadForeignKeys читает внешние ключи текущей базы из information_schema
+func (explorer *DbExplorer) loadForeignKeys() error {
+	rows, err := explorer.db.Query(`SELECT TABLE_NAME, COLUMN_NAME, REFERENCED_TABLE_NAME, REFERENCED_COLUMN_NAME
+		FROM information_schema.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = DATABASE() AND REFERENCED_TABLE_NAME IS NOT NULL
+		ORDER BY TABLE_NAME, ORDINAL_POSITION`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table string
+		var fk ForeignKey
+		if err := rows.Scan(&table, &fk.Column, &fk.RefTable, &fk.RefColumn); err != nil {
+			return err
+		}
+		// Связи с таблицами, которых нет в кеше, пропускаем
+		if !explorer.tableExists(table) || !explorer.tableExists(fk.RefTable) {
+			continue
+		}
+		explorer.foreignKeys[table] = append(explorer.foreignKeys[table], fk)
+	}
+	return rows.Err()
+}
+
+// reservedTables имена служебных маршрутов первого уровня. Они проверяются в ServeHTTP раньше таблиц,
+// поэтому таблица с таким именем не попадает в кеш схемы, а /_admin ее не создает
+var reservedTables = map[string]bool{
+	"graphql":  true,
+	"_admin":   true,
+	"_restore": true,
+	"_dump":    true,
+	"_rpc":     true,
+	"_queries": true,
+	"_ui":      true,
+	"metrics":  true,
+	"healthz":  true,
+	"readyz":   true,
+}
+
+// reservedTable проверяет, что имя таблицы занято служебным маршрутом.
+// Если процесс обслуживает несколько баз, /db занят списком баз (см. NewMultiDbExplorer)
+func (explorer *DbExplorer) reservedTable(name string) bool {
+	return reservedTables[name] || (name == "db" && len(explorer.databases) > 0)
 }
 
 // ServeHTTP обрабатывает запросы к сервису
@@ -101,6 +397,9 @@ func NewDbExplorer(db *sql.DB) (http.Handler, error) {
 func (explorer *DbExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	// Клиент нужен роутеру, чтобы после записи читать из primary
+	r = r.WithContext(context.WithValue(r.Context(), clientKeyCtx{}, clientKey(r)))
+
 	// path - путь запроса, например /table1/123
 	path := strings.Trim(r.URL.Path, "/")
 	// parts - массив путей, например ["table1", "123"]
@@ -116,6 +415,52 @@ func (explorer *DbExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		n = len(parts)
 	}
 
+	// DDL и восстановление дампа обновляют кеш схемы под schemaMu.Lock, поэтому выполняются без RLock
+	if n > 0 && parts[0] == "_admin" {
+		if explorer.checkRateLimit(w, r, "") {
+			explorer.handleAdmin(w, r, parts[1:])
+		}
+		return
+	}
+	if n == 1 && parts[0] == "_restore" && r.Method == http.MethodPost {
+		if explorer.checkRateLimit(w, r, "") {
+			explorer.handleSQLRestore(w, r)
+		}
+		return
+	}
+	// Дамп идет долго, RLock на все время выгрузки заблокировал бы DDL и за ним все запросы
+	if n == 1 && parts[0] == "_dump" && r.Method == http.MethodGet {
+		if explorer.checkRateLimit(w, r, "") {
+			explorer.handleDump(w, r)
+		}
+		return
+	}
+
+	// Запрос целиком работает с одной версией кеша схемы
+	explorer.schemaMu.RLock()
+	defer explorer.schemaMu.RUnlock()
+
+	// Лимит считается по таблице, для корня и служебных маршрутов таблица пустая
+	limitTable := ""
+	if n > 0 && explorer.tableExists(parts[0]) {
+		limitTable = parts[0]
+	}
+	if !explorer.checkRateLimit(w, r, limitTable) {
+		return
+	}
+
+	// Представление читается только списком: записи в нем нет, адресовать строку без первичного ключа нельзя
+	if n > 0 && explorer.views[parts[0]] {
+		if r.Method != http.MethodGet {
+			writeError(w, errReadOnly())
+			return
+		}
+		if n == 3 || (n == 2 && parts[1] != "_aggregate" && parts[1] != "_schema") {
+			writeError(w, errUnknownRoute())
+			return
+		}
+	}
+
 	switch r.Method {
 	///////////////////////////////////////////////////////////////
 	// GET
@@ -125,10 +470,38 @@ func (explorer *DbExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			explorer.handleTablesList(w, r)
 			return
 		case 1: // n = 1
-			explorer.handleTableRecords(w, r, parts[0])
+			switch parts[0] {
+			case "_rpc":
+				explorer.handleProcedures(w, r)
+				return
+			case "_queries":
+				explorer.handleQueries(w, r)
+				return
+			}
+			explorer.serveCached(w, r, parts[0], func(w http.ResponseWriter, r *http.Request) {
+				explorer.handleTableRecords(w, r, parts[0])
+			})
 			return
 		case 2: // n = 2
-			explorer.handleRecord(w, r, parts[0], parts[1])
+			if parts[0] == "_queries" {
+				explorer.handleNamedQuery(w, r, parts[1])
+				return
+			}
+			// Служебные ресурсы таблицы начинаются с "_", id так начинаться не может
+			switch parts[1] {
+			case "_aggregate":
+				explorer.handleAggregate(w, r, parts[0])
+				return
+			case "_schema":
+				explorer.handleSchema(w, r, parts[0])
+				return
+			}
+			explorer.serveCached(w, r, parts[0], func(w http.ResponseWriter, r *http.Request) {
+				explorer.handleRecord(w, r, parts[0], parts[1])
+			})
+			return
+		case 3: // n = 3
+			explorer.handleBlob(w, r, parts[0], parts[1], parts[2])
 			return
 		}
 	////////////////////////////////////////////////////////////////
@@ -138,14 +511,35 @@ func (explorer *DbExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		case 1: // n = 1
 			explorer.handleCreate(w, r, parts[0])
 			return
+		case 3: // n = 3
+			explorer.handleBlobUpload(w, r, parts[0], parts[1], parts[2])
+			return
 		}
 	////////////////////////////////////////////////////////////////
 	// POST
 	case http.MethodPost:
 		switch n {
+		case 1: // n = 1
+			if parts[0] == "graphql" {
+				explorer.handleGraphQL(w, r)
+				return
+			}
 		case 2: // n = 2
+			if parts[0] == "_rpc" {
+				explorer.handleRPC(w, r, parts[1])
+				return
+			}
+			if parts[1] == "_seed" {
+				explorer.handleSeed(w, r, parts[0])
+				return
+			}
 			explorer.handleUpdate(w, r, parts[0], parts[1])
 			return
+		case 3: // n = 3
+			if parts[2] == "_restore" {
+				explorer.handleRestore(w, r, parts[0], parts[1])
+				return
+			}
 		}
 	////////////////////////////////////////////////////////////////
 	// DELETE
@@ -157,28 +551,41 @@ func (explorer *DbExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	http.Error(w, `{"error": "unknown method"}`, http.StatusNotFound)
+	writeError(w, errUnknownRoute())
 }
 
 // handleTablesList обрабатывает запрос на получение списка всех таблиц
+// Если процесс обслуживает несколько баз, в ответе есть и их список
 func (explorer *DbExplorer) handleTablesList(w http.ResponseWriter, r *http.Request) {
-	response := Response{
-		Response: map[string]interface{}{
-			"tables": explorer.tables,
-		},
+	result := map[string]interface{}{
+		"tables": explorer.tables,
+	}
+	if len(explorer.databases) > 0 {
+		result["databases"] = explorer.databases
 	}
-	json.NewEncoder(w).Encode(response)
+	if len(explorer.views) > 0 {
+		views := make([]string, 0, len(explorer.views))
+		for _, table := range explorer.tables {
+			if explorer.views[table] {
+				views = append(views, table)
+			}
+		}
+		result["views"] = views
+	}
+	explorer.writeResponse(w, r, Response{
+		Response: result,
+	})
 }
 
 // handleTableRecords обрабатывает запрос на получение всех записей таблицы
 func (explorer *DbExplorer) handleTableRecords(w http.ResponseWriter, r *http.Request, table string) {
 	if !explorer.tableExists(table) {
-		http.Error(w, `{"error": "unknown table"}`, http.StatusNotFound)
+		writeError(w, errUnknownTable())
 		return
 	}
 
 	// Значения по умолчанию для limit и offset
-	limit := 5
+	limit := explorer.defaultLimit
 	offset := 0
 
 	// Получаем параметры limit и offset из запроса
@@ -192,64 +599,134 @@ func (explorer *DbExplorer) handleTableRecords(w http.ResponseWriter, r *http.Re
 			offset = o
 		}
 	}
+	limit = explorer.capLimit(limit)
 
-	// Формируем запрос на получение записей таблицы
-	query := "SELECT * FROM ? LIMIT ? OFFSET ?"
-	rows, err := explorer.db.Query(query, table, limit, offset)
-	if err != nil {
-		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+	// Фильтры по колонкам из query параметров
+	where, args := explorer.buildFilters(table, r.URL.Query())
+	db := explorer.router.reader(r.Context())
+
+	// Общее количество записей считается только по запросу: ?count=exact|estimated
+	countMode := r.URL.Query().Get("count")
+	if countMode != "" && countMode != "exact" && countMode != "estimated" {
+		writeError(w, errBadRequest("invalid count"))
 		return
 	}
-	defer rows.Close()
-
-	// Считываем все записи из базы
-	records := make([]map[string]interface{}, 0)
-	// Считываем каждую запись
-	for rows.Next() {
-		// Считываем запись в структуру
-		record, err := explorer.rowToMap(rows)
+	var total int64
+	if countMode != "" {
+		var err error
+		total, err = explorer.countRecords(r.Context(), db, table, countMode, where, args)
 		if err != nil {
-			http.Error(w, `{"error": "scan error"}`, http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
-		// Добавляем запись в список
-		records = append(records, record)
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	}
+
+	// Формируем запрос на получение записей таблицы.
+	// Имя таблицы нельзя передать через плейсхолдер, поэтому оно берется из кеша и оборачивается в backticks
+	// BLOB-колонки в списке не отдаются, если не запрошены: их значения читаются через /$table/$id/$column
+	columns := explorer.listColumns(table, r.URL.Query().Get("include_blobs") == "1")
+	query := fmt.Sprintf("SELECT %s FROM `%s`%s LIMIT ? OFFSET ?", columns, table, where)
+	args = append(args, limit, offset)
+	records, err := explorer.queryRecords(r.Context(), db, query, args...)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
 
 	// Отправляем ответ
-	json.NewEncoder(w).Encode(Response{
-		Response: map[string]interface{}{"records": records},
+	result := map[string]interface{}{"records": records}
+	if countMode != "" {
+		result["total"] = total
+	}
+	explorer.writeResponse(w, r, Response{
+		Response: result,
 	})
 }
 
+// countRecords возвращает общее количество записей таблицы.
+// exact - точный COUNT(*) с учетом фильтров.
+// estimated - оценка из статистики information_schema, фильтры не учитываются,
+// зато запрос не сканирует таблицу и остается дешевым на больших таблицах
+func (explorer *DbExplorer) countRecords(ctx context.Context, db *sql.DB, table, mode, where string, args []interface{}) (int64, error) {
+	ctx, cancel := explorer.queryContext(ctx)
+	defer cancel()
+	defer explorer.metrics.observeQuery("select", time.Now())
+
+	var total sql.NullInt64
+	var err error
+	if mode == "estimated" {
+		err = db.QueryRowContext(ctx,
+			"SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?",
+			table,
+		).Scan(&total)
+	} else {
+		err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM `%s`%s", table, where), args...).Scan(&total)
+	}
+	if err != nil {
+		return 0, statementError(ctx, err)
+	}
+	return total.Int64, nil
+}
+
+// reservedParams query параметры, которые управляют выборкой. Они никогда не становятся фильтрами:
+// колонку с таким именем нельзя отфильтровать через query параметры
+func reservedParams() map[string]bool {
+	return map[string]bool{
+		"limit": true, "offset": true, "count": true, "include_deleted": true, "include_blobs": true,
+		"group_by": true, "agg": true,
+	}
+}
+
+// buildFilters формирует WHERE по query параметрам, совпадающим с именами колонок таблицы.
+// Остальные параметры и зарезервированные имена (limit, offset и т.д.) игнорируются.
+// Мягко удаленные строки скрываются, если не передан include_deleted=1.
+// Значения передаются через плейсхолдеры, имена колонок берутся только из кеша
+func (explorer *DbExplorer) buildFilters(table string, query url.Values) (string, []interface{}) {
+	reserved := reservedParams()
+	// Сортируем имена, чтобы текст запроса не зависел от порядка обхода map
+	fields := make([]string, 0, len(query))
+	for field := range query {
+		if _, ok := explorer.columns[table][field]; ok && !reserved[field] {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	conditions := make([]string, 0, len(fields)+1)
+	args := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		conditions = append(conditions, fmt.Sprintf("`%s` = ?", field))
+		args = append(args, query.Get(field))
+	}
+	if condition := explorer.softDeleteCondition(table, query.Get("include_deleted") == "1"); condition != "" {
+		conditions = append(conditions, condition)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
 // handleRecord обрабатывает запрос на получение записи по id
 func (explorer *DbExplorer) handleRecord(w http.ResponseWriter, r *http.Request, table, id string) {
 	if !explorer.tableExists(table) {
-		http.Error(w, `{"error": "unknown table"}`, http.StatusNotFound)
+		writeError(w, errUnknownTable())
 		return
 	}
 
-	// Формируем запрос на получение записи по id
-	query := "SELECT * FROM ? WHERE ? = ?"
-	rows, err := explorer.db.Query(query, table, explorer.primaryKey[table], id)
+	record, err := explorer.findRecord(r.Context(), explorer.router.reader(r.Context()), table, id, r.URL.Query().Get("include_deleted") == "1")
 	if err != nil {
-		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
-	defer rows.Close()
-
-	if !rows.Next() {
-		http.Error(w, `{"error": "record not found"}`, http.StatusNotFound)
+	if record == nil {
+		writeError(w, errRecordNotFound())
 		return
 	}
 
-	record, err := explorer.rowToMap(rows)
-	if err != nil {
-		http.Error(w, `{"error": "scan error"}`, http.StatusInternalServerError)
-		return
-	}
-
-	json.NewEncoder(w).Encode(Response{
+	explorer.writeResponse(w, r, Response{
 		Response: map[string]interface{}{"record": record},
 	})
 }
@@ -257,57 +734,29 @@ func (explorer *DbExplorer) handleRecord(w http.ResponseWriter, r *http.Request,
 // handleCreate обрабатывает запрос на создание новой записи в таблице
 func (explorer *DbExplorer) handleCreate(w http.ResponseWriter, r *http.Request, table string) {
 	if !explorer.tableExists(table) {
-		http.Error(w, `{"error": "unknown table"}`, http.StatusNotFound)
+		writeError(w, errUnknownTable())
 		return
 	}
 
-	columnTypes, err := explorer.getColumnTypes(table)
+	// Типы колонок берутся из кеша схемы
+	columnTypes := explorer.columns[table]
+
+	requestData, err := explorer.decodeBody(r, columnTypes, false)
 	if err != nil {
-		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
-	var requestData map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
-		http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
+	query, values, err := explorer.buildInsert(table, columnTypes, requestData)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	columns := make([]string, 0)
-	values := make([]interface{}, 0)
-	placeholders := make([]string, 0)
-
-	// Проверяем все колонки
-	for field, info := range columnTypes {
-		if field == explorer.primaryKey[table] {
-			continue
-		}
-
-		value, exists := requestData[field]
-		if !exists {
-			// Если поле не передано и оно NOT NULL без default value
-			if !info.Nullable {
-				value = "" // для строк пустая строка, для int можно 0
-			}
-		}
-
-		// Проверяем значение на соответствие типу колонки
-		if err := explorer.validateValue(value, info, field); err != nil {
-			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err), http.StatusBadRequest)
-			return
-		}
-
-		columns = append(columns, field)
-		values = append(values, value)
-		placeholders = append(placeholders, "?")
-	}
-
-	// Формируем запрос на создание новой записи в таблице
-	query := "INSERT INTO ? (?) VALUES (?)"
-
-	result, err := explorer.db.Exec(query, table, columns, placeholders)
+	result, err := explorer.exec(r.Context(), explorer.router.writer(r.Context()), query, values...)
+	explorer.invalidateCache(table)
 	if err != nil {
-		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -317,71 +766,45 @@ func (explorer *DbExplorer) handleCreate(w http.ResponseWriter, r *http.Request,
 			explorer.primaryKey[table]: id,
 		},
 	}
-	json.NewEncoder(w).Encode(response)
+	explorer.writeResponse(w, r, response)
 }
 
 // handleUpdate обрабатывает запрос на обновление записи в таблице
 func (explorer *DbExplorer) handleUpdate(w http.ResponseWriter, r *http.Request, table, id string) {
 	if !explorer.tableExists(table) {
-		http.Error(w, `{"error": "unknown table"}`, http.StatusNotFound)
+		writeError(w, errUnknownTable())
 		return
 	}
 
-	columnTypes, err := explorer.getColumnTypes(table)
-	if err != nil {
-		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
-		return
-	}
+	// Типы колонок берутся из кеша схемы
+	columnTypes := explorer.columns[table]
 
-	var requestData map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
-		http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
+	requestData, err := explorer.decodeBody(r, columnTypes, false)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	// Проверяем попытку обновить primary key
-	primaryKey := explorer.primaryKey[table]
-	if _, ok := requestData[primaryKey]; ok {
-		http.Error(w, fmt.Sprintf(`{"error": "field %s have invalid type"}`, primaryKey), http.StatusBadRequest)
+	query, values, err := explorer.buildUpdate(table, columnTypes, requestData, id)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	sets := make([]string, 0)
-	values := make([]interface{}, 0)
-
-	for key, value := range requestData {
-		colInfo, ok := columnTypes[key]
-		if !ok {
-			continue
-		}
-
-		if err := explorer.validateValue(value, colInfo, key); err != nil {
-			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err), http.StatusBadRequest)
-			return
-		}
-
-		// Формируем запрос на обновление записи в таблице
-		sets = append(sets, fmt.Sprintf("`%s` = ?", key))
-		values = append(values, value)
-	}
-	values = append(values, id)
-
-	if len(sets) == 0 {
+	if query == "" {
 		response := Response{
 			Response: map[string]interface{}{
 				"updated": 0,
 			},
 		}
-		json.NewEncoder(w).Encode(response)
+		explorer.writeResponse(w, r, response)
 		return
 	}
 
-	// Формируем запрос на обновление записи в таблице
-	query := "UPDATE ? SET ? WHERE ? = ?"
-
-	result, err := explorer.db.Exec(query, table, sets, explorer.primaryKey[table], id)
+	result, err := explorer.exec(r.Context(), explorer.router.writer(r.Context()), query, values...)
+	explorer.invalidateCache(table)
 	if err != nil {
-		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -391,31 +814,231 @@ func (explorer *DbExplorer) handleUpdate(w http.ResponseWriter, r *http.Request,
 			"updated": affected,
 		},
 	}
-	json.NewEncoder(w).Encode(response)
+	explorer.writeResponse(w, r, response)
 }
 
 // handleDelete обрабатывает запрос на удаление записи из таблицы
 func (explorer *DbExplorer) handleDelete(w http.ResponseWriter, r *http.Request, table, id string) {
 	if !explorer.tableExists(table) {
-		http.Error(w, `{"error": "unknown table"}`, http.StatusNotFound)
+		writeError(w, errUnknownTable())
 		return
 	}
 
-	// Формируем запрос на удаление записи из таблицы
-	query := "DELETE FROM ? WHERE ? = ?"
-	result, err := explorer.db.Exec(query, table, explorer.primaryKey[table], id)
+	affected, err := explorer.deleteRecord(r.Context(), explorer.router.writer(r.Context()), table, id)
 	if err != nil {
-		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
-	affected, _ := result.RowsAffected()
 	response := Response{
 		Response: map[string]interface{}{
 			"deleted": affected,
 		},
 	}
-	json.NewEncoder(w).Encode(response)
+	explorer.writeResponse(w, r, response)
+}
+
+// deleteRecord удаляет запись по первичному ключу и возвращает количество удаленных строк.
+// Для таблиц с мягким удалением строка только помечается, повторное удаление вернет 0
+func (explorer *DbExplorer) deleteRecord(ctx context.Context, db *sql.DB, table, id string) (int64, error) {
+	// Формируем запрос на удаление записи из таблицы
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE `%s` = ?", table, explorer.primaryKey[table])
+	if column, ok := explorer.softDelete[table]; ok {
+		query = fmt.Sprintf("UPDATE `%s` SET `%s` = NOW() WHERE `%s` = ? AND `%s` IS NULL",
+			table, column, explorer.primaryKey[table], column)
+	}
+	result, err := explorer.exec(ctx, db, query, id)
+	explorer.invalidateCache(table)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// findRecord возвращает запись по первичному ключу или nil, если записи нет.
+// Мягко удаленная запись считается отсутствующей, если не запрошена явно
+func (explorer *DbExplorer) findRecord(ctx context.Context, db *sql.DB, table, id string, includeDeleted bool) (map[string]interface{}, error) {
+	query := fmt.Sprintf("SELECT * FROM `%s` WHERE `%s` = ?", table, explorer.primaryKey[table])
+	if condition := explorer.softDeleteCondition(table, includeDeleted); condition != "" {
+		query += " AND " + condition
+	}
+	records, err := explorer.queryRecords(ctx, db, query, id)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+// queryRecords выполняет SELECT и возвращает все строки результата в виде map
+func (explorer *DbExplorer) queryRecords(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	ctx, cancel := explorer.queryContext(ctx)
+	defer cancel()
+	defer explorer.metrics.observeQuery(query, time.Now())
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, statementError(ctx, err)
+	}
+	defer rows.Close()
+
+	records := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		record, err := explorer.rowToMap(rows)
+		if err != nil {
+			return nil, statementError(ctx, err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, statementError(ctx, err)
+	}
+	return records, nil
+}
+
+// exec выполняет запрос на изменение с таймаутом
+func (explorer *DbExplorer) exec(ctx context.Context, db *sql.DB, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := explorer.queryContext(ctx)
+	defer cancel()
+	defer explorer.metrics.observeQuery(query, time.Now())
+
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, statementError(ctx, err)
+	}
+	return result, nil
+}
+
+// queryContext ограничивает один запрос к базе таймаутом.
+// Контекст запроса клиента родительский, поэтому запрос прерывается и при обрыве соединения
+func (explorer *DbExplorer) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if explorer.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, explorer.queryTimeout)
+}
+
+// statementError возвращает ошибку контекста, если запрос прерван по таймауту или отмене.
+// Драйвер в этом случае может вернуть и другую ошибку, например "invalid connection"
+func statementError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// capLimit ограничивает размер выборки: отрицательный limit заменяется значением по умолчанию,
+// слишком большой - максимальным
+func (explorer *DbExplorer) capLimit(limit int) int {
+	if limit < 0 {
+		return explorer.defaultLimit
+	}
+	if explorer.maxLimit > 0 && limit > explorer.maxLimit {
+		return explorer.maxLimit
+	}
+	return limit
+}
+
+// buildInsert проверяет данные запроса и формирует INSERT.
+// Первичный ключ игнорируется, непереданные NOT NULL поля заполняются пустыми значениями.
+// Ошибка - validation_failed со всеми невалидными полями
+func (explorer *DbExplorer) buildInsert(table string, columnTypes map[string]ColumnInfo, data map[string]interface{}) (string, []interface{}, error) {
+	columns := make([]string, 0)
+	values := make([]interface{}, 0)
+	placeholders := make([]string, 0)
+	details := make([]FieldError, 0)
+
+	// Проверяем все колонки
+	for field, info := range columnTypes {
+		if field == explorer.primaryKey[table] {
+			continue
+		}
+
+		value, exists := data[field]
+		if !exists {
+			// Если поле не передано и оно NOT NULL без default value
+			if !info.Nullable {
+				value = "" // для строк пустая строка, для int можно 0
+			}
+		}
+
+		// Проверяем значение на соответствие типу колонки
+		if err := explorer.validateValue(value, info, field); err != nil {
+			details = append(details, FieldError{Field: field, Code: fieldInvalidType, Message: err.Error()})
+			continue
+		}
+		value, fieldErr := explorer.decodeBinary(value, info, field)
+		if fieldErr != nil {
+			details = append(details, *fieldErr)
+			continue
+		}
+
+		columns = append(columns, fmt.Sprintf("`%s`", field))
+		values = append(values, value)
+		placeholders = append(placeholders, "?")
+	}
+
+	if len(details) > 0 {
+		explorer.metrics.validationFailed(table)
+		return "", nil, errValidation(details)
+	}
+
+	// Формируем запрос на создание новой записи в таблице
+	query := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s)",
+		table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	return query, values, nil
+}
+
+// buildUpdate проверяет данные запроса и формирует UPDATE записи с первичным ключом id.
+// Неизвестные поля игнорируются, изменять первичный ключ нельзя.
+// Пустой запрос означает, что обновлять нечего. Ошибка - validation_failed со всеми невалидными полями
+func (explorer *DbExplorer) buildUpdate(table string, columnTypes map[string]ColumnInfo, data map[string]interface{}, id string) (string, []interface{}, error) {
+	details := make([]FieldError, 0)
+
+	// Проверяем попытку обновить primary key
+	primaryKey := explorer.primaryKey[table]
+	if _, ok := data[primaryKey]; ok {
+		details = append(details, FieldError{
+			Field:   primaryKey,
+			Code:    fieldReadOnly,
+			Message: fmt.Sprintf("field %s have invalid type", primaryKey),
+		})
+	}
+
+	sets := make([]string, 0)
+	values := make([]interface{}, 0)
+
+	for key, value := range data {
+		colInfo, ok := columnTypes[key]
+		if !ok || key == primaryKey {
+			continue
+		}
+
+		if err := explorer.validateValue(value, colInfo, key); err != nil {
+			details = append(details, FieldError{Field: key, Code: fieldInvalidType, Message: err.Error()})
+			continue
+		}
+		value, fieldErr := explorer.decodeBinary(value, colInfo, key)
+		if fieldErr != nil {
+			details = append(details, *fieldErr)
+			continue
+		}
+
+		sets = append(sets, fmt.Sprintf("`%s` = ?", key))
+		values = append(values, value)
+	}
+
+	if len(details) > 0 {
+		explorer.metrics.validationFailed(table)
+		return "", nil, errValidation(details)
+	}
+	if len(sets) == 0 {
+		return "", nil, nil
+	}
+	values = append(values, id)
+
+	// Формируем запрос на обновление записи в таблице
+	query := fmt.Sprintf("UPDATE `%s` SET %s WHERE `%s` = ?", table, strings.Join(sets, ", "), primaryKey)
+	return query, values, nil
 }
 
 // validateValue проверяет значение на соответствие типу колонки
@@ -428,6 +1051,14 @@ func (explorer *DbExplorer) validateValue(value interface{}, colInfo ColumnInfo,
 	}
 
 	switch {
+	case normalizeType(colInfo.Type) == "binary":
+		// Двоичные значения передаются в JSON строкой base64, в MessagePack - как есть
+		switch value.(type) {
+		case string, []byte:
+		default:
+			return fmt.Errorf("field %s have invalid type", field)
+		}
+
 	case strings.Contains(colInfo.Type, "int"):
 		// Проверяем сначала float64 (из JSON)
 		if floatVal, ok := value.(float64); ok {
@@ -436,8 +1067,9 @@ func (explorer *DbExplorer) validateValue(value interface{}, colInfo ColumnInfo,
 				return nil
 			}
 		}
-		// Проверяем int (для других случаев)
-		if _, ok := value.(int); ok {
+		// Проверяем int (для других случаев), int64 и uint64 приходят из MessagePack и CSV
+		switch value.(type) {
+		case int, int64, uint64:
 			return nil
 		}
 		return fmt.Errorf("field %s have invalid type", field)
@@ -463,30 +1095,6 @@ func (explorer *DbExplorer) tableExists(table string) bool {
 	return false
 }
 
-// getColumnTypes получает типы колонок таблицы
-func (explorer *DbExplorer) getColumnTypes(table string) (map[string]ColumnInfo, error) {
-	query := fmt.Sprintf("SHOW COLUMNS FROM `%s`", table)
-	colsRows, err := explorer.db.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer colsRows.Close()
-
-	columnTypes := make(map[string]ColumnInfo)
-	for colsRows.Next() {
-		var field, typ, null, key, extra string
-		var def sql.NullString
-		if err := colsRows.Scan(&field, &typ, &null, &key, &def, &extra); err != nil {
-			return nil, err
-		}
-		columnTypes[field] = ColumnInfo{
-			Type:     typ,
-			Nullable: null == "YES",
-		}
-	}
-	return columnTypes, nil
-}
-
 // rowToMap преобразует строку результата sql.Rows в map[string]interface{}
 // Используется для формирования JSON-ответа
 // Ключи map - имена колонок, значения - данные из базы
@@ -496,6 +1104,11 @@ func (explorer *DbExplorer) rowToMap(rows *sql.Rows) (map[string]interface{}, er
 	if err != nil {
 		return nil, err
 	}
+	// Типы колонок нужны, чтобы разобрать числа, пришедшие в текстовом протоколе
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
 
 	// Создаем слайсы для значений и указателей на них
 	// КЛЮЧ valuePtrs (pointers) хранит указатели на них для Scan
@@ -518,9 +1131,10 @@ func (explorer *DbExplorer) rowToMap(rows *sql.Rows) (map[string]interface{}, er
 		var v interface{}
 		val := values[i]
 		// Особая обработка для []byte - конвертируем в string
-		// MySQL возвращает строковые типы как []byte
+		// MySQL возвращает строковые типы как []byte.
+		// Запросы без параметров идут по текстовому протоколу, и тогда числа тоже приходят как []byte
 		if b, ok := val.([]byte); ok {
-			v = string(b)
+			v = bytesToValue(b, columnTypes[i].DatabaseTypeName())
 		} else {
 			v = val
 		}
@@ -529,3 +1143,25 @@ func (explorer *DbExplorer) rowToMap(rows *sql.Rows) (map[string]interface{}, er
 
 	return record, nil
 }
+
+// bytesToValue приводит значение из текстового протокола MySQL к типу колонки:
+// целые - к int64/uint64, FLOAT и DOUBLE - к float64, двоичные - к строке base64, остальное (включая DECIMAL) - к string
+func bytesToValue(b []byte, databaseType string) interface{} {
+	switch strings.TrimPrefix(databaseType, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		if strings.HasPrefix(databaseType, "UNSIGNED ") {
+			if u, err := strconv.ParseUint(string(b), 10, 64); err == nil {
+				return u
+			}
+		} else if i, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			return i
+		}
+	case "FLOAT", "DOUBLE":
+		if f, err := strconv.ParseFloat(string(b), 64); err == nil {
+			return f
+		}
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB":
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return string(b)
+}
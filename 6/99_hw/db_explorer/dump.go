@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// dumpBatchSize строк в одном INSERT дампа
+	dumpBatchSize = 100
+	// maxRestoreSize ограничивает размер восстанавливаемого дампа
+	maxRestoreSize = 256 << 20
+)
+
+// nonSessionSet SET, который меняет не только текущую сессию: глобальные и сохраняемые переменные,
+// пароль, роли и группы ресурсов. Проверяется на тексте из statementFields, без содержимого строк
+var nonSessionSet = regexp.MustCompile(`(^set|,)\s*(global|persist|persist_only|password|role|default\s+role|resource\s+group)\b|@@(global|persist|persist_only)\.`)
+
+// handleDump обрабатывает запрос GET /_dump?tables=a,b - отдает SQL-дамп схемы и данных потоком.
+// Без tables в дамп попадают все таблицы из кеша, кроме представлений, с schema=false - только данные.
+// Дамп читается в одной транзакции REPEATABLE READ, поэтому данные таблиц согласованы между собой.
+// Таймаут запроса к дампу не применяется: выгрузка большой таблицы может идти долго.
+// Доступен только администратору, как и восстановление: в дамп попадают все данные таблиц.
+// Вызывается без schemaMu: кеш схемы читается только в dumpTables
+func (explorer *DbExplorer) handleDump(w http.ResponseWriter, r *http.Request) {
+	if !explorer.requireAdmin(w, r) {
+		return
+	}
+
+	tables, primaryKeys, apiErr := explorer.dumpTables(r.URL.Query().Get("tables"))
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	withSchema := r.URL.Query().Get("schema") != "false"
+
+	db := explorer.router.reader(r.Context())
+	tx, err := db.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	w.Header().Set("Content-Type", "application/sql; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="dump.sql"`)
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, "-- db_explorer dump %s\n\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintln(out, "SET NAMES utf8mb4;")
+	fmt.Fprintln(out, "SET foreign_key_checks = 0;")
+	fmt.Fprintln(out)
+
+	for _, table := range tables {
+		if withSchema {
+			err = explorer.dumpSchema(r.Context(), tx, out, table)
+		}
+		if err == nil {
+			err = explorer.dumpData(r.Context(), tx, out, table, primaryKeys[table])
+		}
+		if err != nil {
+			// Заголовки уже отправлены, статус не поменять. Обрываем ответ,
+			// чтобы клиент не принял неполный дамп за целый
+			log.Printf("dump of %s failed: %v", table, err)
+			fmt.Fprintf(out, "\n-- dump failed: %s\n", table)
+			out.Flush()
+			panic(http.ErrAbortHandler)
+		}
+		// Клиент получает дамп по таблицам, а не одним куском в конце
+		out.Flush()
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+
+	fmt.Fprintln(out, "SET foreign_key_checks = 1;")
+	out.Flush()
+}
+
+// dumpTables возвращает таблицы дампа и их первичные ключи. Кеш схемы читается под RLock,
+// который отпускается до выгрузки: иначе DDL, ожидающий Lock, на все время выгрузки
+// останавливал бы остальные запросы к explorer
+func (explorer *DbExplorer) dumpTables(param string) ([]string, map[string]string, *APIError) {
+	explorer.schemaMu.RLock()
+	defer explorer.schemaMu.RUnlock()
+
+	// Представления не выгружаются: у них нет своих данных, а восстановление принимает только таблицы
+	tables := make([]string, 0, len(explorer.tables))
+	for _, table := range explorer.tables {
+		if !explorer.views[table] {
+			tables = append(tables, table)
+		}
+	}
+	if param != "" {
+		tables = splitList(param)
+		for _, table := range tables {
+			if !explorer.tableExists(table) {
+				return nil, nil, errUnknownTable()
+			}
+			if explorer.views[table] {
+				return nil, nil, errBadRequest(fmt.Sprintf("view %s can not be dumped", table))
+			}
+		}
+	}
+
+	primaryKeys := make(map[string]string, len(tables))
+	for _, table := range tables {
+		primaryKeys[table] = explorer.primaryKey[table]
+	}
+	return tables, primaryKeys, nil
+}
+
+// dumpSchema пишет DROP TABLE и CREATE TABLE таблицы
+func (explorer *DbExplorer) dumpSchema(ctx context.Context, tx *sql.Tx, out io.Writer, table string) error {
+	query := fmt.Sprintf("SHOW CREATE TABLE `%s`", table)
+	defer explorer.metrics.observeQuery(query, time.Now())
+
+	var name, ddl string
+	if err := tx.QueryRowContext(ctx, query).Scan(&name, &ddl); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "DROP TABLE IF EXISTS `%s`;\n%s;\n\n", table, ddl)
+	return nil
+}
+
+// dumpData пишет строки таблицы пачками по dumpBatchSize в одном INSERT, по порядку первичного ключа pk
+func (explorer *DbExplorer) dumpData(ctx context.Context, tx *sql.Tx, out io.Writer, table, pk string) error {
+	query := fmt.Sprintf("SELECT * FROM `%s`", table)
+	if pk != "" {
+		query += fmt.Sprintf(" ORDER BY `%s`", pk)
+	}
+	defer explorer.metrics.observeQuery(query, time.Now())
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	header := fmt.Sprintf("INSERT INTO `%s` (`%s`) VALUES\n", table, strings.Join(columns, "`, `"))
+
+	values := make([]sql.RawBytes, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	literals := make([]string, len(columns))
+	batch := 0
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, value := range values {
+			literals[i] = sqlLiteral(value, columnTypes[i].DatabaseTypeName())
+		}
+
+		if batch == 0 {
+			io.WriteString(out, header)
+		} else {
+			io.WriteString(out, ",\n")
+		}
+		fmt.Fprintf(out, "(%s)", strings.Join(literals, ", "))
+
+		batch++
+		if batch == dumpBatchSize {
+			io.WriteString(out, ";\n")
+			batch = 0
+		}
+	}
+	if batch > 0 {
+		io.WriteString(out, ";\n")
+	}
+	io.WriteString(out, "\n")
+	return rows.Err()
+}
+
+// sqlLiteral записывает значение из базы литералом SQL: числа как есть, двоичные данные в hex, остальное строкой
+func sqlLiteral(value sql.RawBytes, databaseType string) string {
+	if value == nil {
+		return "NULL"
+	}
+	switch strings.TrimPrefix(databaseType, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR", "DECIMAL", "FLOAT", "DOUBLE":
+		return string(value)
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "BIT", "GEOMETRY":
+		if len(value) == 0 {
+			return "''"
+		}
+		return "X'" + hex.EncodeToString(value) + "'"
+	}
+	return quoteLiteral(string(value))
+}
+
+// handleSQLRestore обрабатывает запрос POST /_restore - выполняет SQL-дамп из тела запроса.
+// Доступен только администратору. Допускаются запросы, которые пишет /_dump и типичные дампы
+// (SET, DROP TABLE, CREATE TABLE, INSERT), LOCK и UNLOCK TABLES пропускаются.
+// Проверки внешних ключей отключаются на время восстановления, чтобы порядок таблиц был не важен.
+// Дамп без DDL выполняется в одной транзакции. DDL в MySQL неявно завершает транзакцию, поэтому дамп с ним
+// выполняется по одному запросу и только с non_transactional=1: при ошибке часть дампа останется примененной
+func (explorer *DbExplorer) handleSQLRestore(w http.ResponseWriter, r *http.Request) {
+	if !explorer.requireAdmin(w, r) {
+		return
+	}
+
+	script, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRestoreSize))
+	if err != nil {
+		writeError(w, errBadRequest("invalid dump body"))
+		return
+	}
+
+	var statements []string
+	transactional := true
+	for i, statement := range splitStatements(string(script)) {
+		kind, allowed := restoreStatementKind(statement)
+		if !allowed {
+			writeError(w, errBadRequest(fmt.Sprintf("statement %d is not allowed in restore: %s", i+1, kind)))
+			return
+		}
+		if kind == "lock" || kind == "unlock" {
+			continue
+		}
+		if isDDL(statement) {
+			transactional = false
+		}
+		statements = append(statements, statement)
+	}
+	if !transactional && r.URL.Query().Get("non_transactional") != "1" {
+		writeError(w, errBadRequest("dump contains DDL and can not be restored atomically, pass non_transactional=1 to restore it statement by statement"))
+		return
+	}
+
+	ctx := r.Context()
+	conn, err := explorer.db.Conn(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// SET из дампа меняет настройки сессии. Соединение не возвращаем в пул, чтобы они не достались другим запросам
+	defer conn.Close()
+	defer conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+
+	client := clientKey(r)
+	err = explorer.runRestore(ctx, conn, statements, transactional)
+	// Часть дампа без транзакции могла примениться и при ошибке
+	explorer.cache.clear()
+	if err != nil {
+		log.Printf("restore failed, client %s: %v", client, err)
+		writeError(w, err)
+		return
+	}
+	log.Printf("restore applied, client %s: %d statements, transactional %t", client, len(statements), transactional)
+
+	// Дамп мог создать и удалить таблицы
+	explorer.schemaMu.Lock()
+	err = explorer.loadSchema()
+	explorer.schemaMu.Unlock()
+	if err != nil {
+		log.Printf("schema refresh after restore failed: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	explorer.writeResponse(w, r, Response{
+		Response: map[string]interface{}{
+			"statements":    len(statements),
+			"transactional": transactional,
+		},
+	})
+}
+
+// runRestore выполняет запросы дампа на соединении с отключенными проверками внешних ключей
+func (explorer *DbExplorer) runRestore(ctx context.Context, conn *sql.Conn, statements []string, transactional bool) error {
+	if _, err := conn.ExecContext(ctx, "SET foreign_key_checks = 0"); err != nil {
+		return err
+	}
+
+	if !transactional {
+		for i, statement := range statements {
+			if _, err := conn.ExecContext(ctx, statement); err != nil {
+				return restoreError(i, err)
+			}
+		}
+		return nil
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for i, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			tx.Rollback()
+			return restoreError(i, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// restoreError добавляет к ошибке номер запроса дампа. Восстановление доступно только администратору,
+// поэтому ошибки MySQL, не означающие конфликт, отдаются как 400 с текстом сервера, а не как 500 без подробностей
+func restoreError(i int, err error) error {
+	apiErr := toAPIError(err)
+	var mysqlErr *mysql.MySQLError
+	if apiErr.Code == codeInternal && errors.As(err, &mysqlErr) {
+		apiErr = errBadRequest(mysqlErr.Message)
+	}
+	return newAPIError(apiErr.Status, apiErr.Code, fmt.Sprintf("statement %d: %s", i+1, apiErr.Message))
+}
+
+// restoreStatementKind возвращает вид запроса и допустим ли он в восстанавливаемом дампе
+func restoreStatementKind(statement string) (string, bool) {
+	fields := statementFields(statement)
+	if len(fields) == 0 {
+		return "", false
+	}
+	kind := fields[0]
+	switch kind {
+	case "set":
+		// Дамп может настраивать только свою сессию
+		return kind, !nonSessionSet.MatchString(strings.Join(fields, " "))
+	case "insert", "lock", "unlock":
+		return kind, true
+	case "drop", "create":
+		return kind, len(fields) > 1 && fields[1] == "table"
+	}
+	return kind, false
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec формат тел запросов и ответов API. Формат ответа выбирается по Accept, формат тела - по Content-Type.
+// Encode возвращает errNotRepresentable, если ответ нельзя записать в этом формате,
+// тогда берется следующий подходящий формат из Accept, а в конце - JSON.
+// Decode читает одну запись: имя поля -> значение
+type Codec interface {
+	ContentType() string
+	Encode(w io.Writer, response Response) error
+	Decode(r io.Reader) (map[string]interface{}, error)
+}
+
+// codecError ошибка формата, константа
+type codecError string
+
+func (e codecError) Error() string {
+	return string(e)
+}
+
+// errNotRepresentable ответ не укладывается в формат, например не табличный ответ в CSV
+const errNotRepresentable = codecError("response can not be represented in this format")
+
+// textValue значение из текстового формата (CSV, XML). Тип в таких форматах не передается,
+// поэтому значение приводится к типу колонки в decodeBody
+type textValue string
+
+// codecRegistry форматы, доступные explorer. Первый - формат по умолчанию
+type codecRegistry struct {
+	codecs []Codec
+}
+
+// newCodecRegistry создает реестр с JSON, CSV, XML и MessagePack
+func newCodecRegistry() *codecRegistry {
+	return &codecRegistry{
+		codecs: []Codec{jsonCodec{}, csvCodec{}, xmlCodec{}, msgpackCodec{}},
+	}
+}
+
+// register добавляет формат или заменяет формат с тем же Content-Type
+func (registry *codecRegistry) register(codec Codec) {
+	for i, existing := range registry.codecs {
+		if existing.ContentType() == codec.ContentType() {
+			registry.codecs[i] = codec
+			return
+		}
+	}
+	registry.codecs = append(registry.codecs, codec)
+}
+
+// lookup возвращает формат по Content-Type без параметров (charset и т.п.) или nil
+func (registry *codecRegistry) lookup(contentType string) Codec {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	for _, codec := range registry.codecs {
+		if codec.ContentType() == mediaType {
+			return codec
+		}
+	}
+	return nil
+}
+
+// negotiate возвращает форматы, подходящие под Accept, от более предпочтительного к менее.
+// Диапазоны вида text/* и */* раскрываются в зарегистрированные форматы в порядке регистрации,
+// q=0 исключает формат. Пустой Accept - формат по умолчанию.
+// Остальные форматы выбираются, только если клиент предпочитает их больше всего и ставит выше */*:
+// браузер с Accept "text/html,application/xhtml+xml,application/xml;q=0.9" получает JSON, а не XML
+func (registry *codecRegistry) negotiate(accept string) []Codec {
+	if strings.TrimSpace(accept) == "" {
+		return []Codec{registry.codecs[0]}
+	}
+
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	ranges := make([]mediaRange, 0)
+	excluded := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			excluded[mediaType] = true
+			continue
+		}
+		ranges = append(ranges, mediaRange{mediaType, q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	var topQ, anyQ float64
+	for _, r := range ranges {
+		topQ = math.Max(topQ, r.q)
+		if r.mediaType == "*/*" {
+			anyQ = math.Max(anyQ, r.q)
+		}
+	}
+
+	codecs := make([]Codec, 0, len(registry.codecs))
+	seen := make(map[string]bool)
+	for _, r := range ranges {
+		for _, codec := range registry.codecs {
+			contentType := codec.ContentType()
+			if seen[contentType] || excluded[contentType] || !matchMediaRange(r.mediaType, contentType) {
+				continue
+			}
+			if contentType != registry.codecs[0].ContentType() && (r.q < topQ || r.q <= anyQ) {
+				continue
+			}
+			seen[contentType] = true
+			codecs = append(codecs, codec)
+		}
+	}
+	return codecs
+}
+
+// matchMediaRange проверяет, что Content-Type попадает в диапазон из Accept
+func matchMediaRange(mediaRange, contentType string) bool {
+	if mediaRange == "*/*" || mediaRange == contentType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(mediaRange, "/*")
+	return ok && strings.HasPrefix(contentType, prefix+"/")
+}
+
+// writeResponse отдает успешный ответ в формате, выбранном по Accept.
+// Ошибки всегда отдаются в JSON, см. writeError
+func (explorer *DbExplorer) writeResponse(w http.ResponseWriter, r *http.Request, response Response) {
+	writeNegotiated(w, r, explorer.codecs, response)
+}
+
+// writeNegotiated кодирует ответ первым подходящим форматом. Ответ собирается в буфер,
+// чтобы Content-Type соответствовал формату, который действительно смог его записать.
+// Если ни один формат из Accept не подходит, ответ отдается в JSON
+func writeNegotiated(w http.ResponseWriter, r *http.Request, registry *codecRegistry, response Response) {
+	w.Header().Add("Vary", "Accept")
+	var buf bytes.Buffer
+	for _, codec := range append(registry.negotiate(r.Header.Get("Accept")), jsonCodec{}) {
+		buf.Reset()
+		err := codec.Encode(&buf, response)
+		if errors.Is(err, errNotRepresentable) {
+			continue
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", codec.ContentType())
+		w.Write(buf.Bytes())
+		return
+	}
+}
+
+// decodeBody читает запись из тела запроса в формате из Content-Type. Без Content-Type
+// или с незарегистрированным форматом тело читается как JSON, как до появления других форматов.
+// Значения текстовых форматов приводятся к типам колонок columns.
+// optional разрешает пустое тело, тогда возвращается пустая запись
+func (explorer *DbExplorer) decodeBody(r *http.Request, columns map[string]ColumnInfo, optional bool) (map[string]interface{}, error) {
+	codec := explorer.codecs.lookup(r.Header.Get("Content-Type"))
+	if codec == nil {
+		codec = jsonCodec{}
+	}
+
+	data, err := codec.Decode(r.Body)
+	if err == io.EOF && optional {
+		return make(map[string]interface{}), nil
+	}
+	if err != nil {
+		_, format, _ := strings.Cut(codec.ContentType(), "/")
+		return nil, errBadRequest(fmt.Sprintf("invalid %s body", format))
+	}
+
+	for field, value := range data {
+		if text, ok := value.(textValue); ok {
+			data[field] = parseText(string(text), columns[field])
+		}
+	}
+	return data, nil
+}
+
+// parseText приводит значение текстового формата к типу колонки так, как его передал бы JSON.
+// Пустое значение необязательной колонки - NULL, так же CSV записывает NULL в ответах.
+// Значение, которое не разбирается, остается строкой, и запись не пройдет проверку типа
+func parseText(value string, info ColumnInfo) interface{} {
+	if value == "" && info.Nullable {
+		return nil
+	}
+	switch normalizeType(info.Type) {
+	case "integer":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case "float", "decimal":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+// normalizeResponse приводит ответ к дереву из map[string]interface{}, []interface{}, json.Number,
+// string, bool и nil через JSON. Так CSV и XML пишут структуры с теми же именами полей, что и JSON
+func normalizeResponse(response Response) (interface{}, error) {
+	data, err := json.Marshal(response.Response)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var tree interface{}
+	err = decoder.Decode(&tree)
+	return tree, err
+}
+
+// jsonCodec JSON - формат по умолчанию
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Encode(w io.Writer, response Response) error {
+	return json.NewEncoder(w).Encode(response)
+}
+
+func (jsonCodec) Decode(r io.Reader) (map[string]interface{}, error) {
+	var data map[string]interface{}
+	err := json.NewDecoder(r).Decode(&data)
+	return data, err
+}
+
+// csvCodec CSV для табличных ответов: строка заголовка с именами колонок по алфавиту и строка на запись.
+// Записываются списки записей (records), одна запись (record) и ответы из одних скаляров, например {"id": 3}.
+// NULL - пустое значение. Тело запроса - заголовок и одна строка
+type csvCodec struct{}
+
+func (csvCodec) ContentType() string {
+	return "text/csv"
+}
+
+func (csvCodec) Encode(w io.Writer, response Response) error {
+	if response.Response == nil {
+		return errNotRepresentable
+	}
+	tree, err := normalizeResponse(response)
+	if err != nil {
+		return err
+	}
+	object, ok := tree.(map[string]interface{})
+	if !ok {
+		return errNotRepresentable
+	}
+
+	var rows []interface{}
+	if records, ok := object["records"].([]interface{}); ok {
+		rows = records
+	} else if record, ok := object["record"].(map[string]interface{}); ok {
+		rows = []interface{}{record}
+	} else {
+		rows = []interface{}{object}
+	}
+
+	columns := make(map[string]bool)
+	for _, row := range rows {
+		record, ok := row.(map[string]interface{})
+		if !ok {
+			return errNotRepresentable
+		}
+		for column, value := range record {
+			switch value.(type) {
+			case map[string]interface{}, []interface{}:
+				return errNotRepresentable
+			}
+			columns[column] = true
+		}
+	}
+	header := sortedKeys(columns)
+
+	out := csv.NewWriter(w)
+	if len(header) > 0 {
+		out.Write(header)
+	}
+	line := make([]string, len(header))
+	for _, row := range rows {
+		record := row.(map[string]interface{})
+		for i, column := range header {
+			line[i] = ""
+			if value := record[column]; value != nil {
+				line[i] = fmt.Sprint(value)
+			}
+		}
+		out.Write(line)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func (csvCodec) Decode(r io.Reader) (map[string]interface{}, error) {
+	lines, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, io.EOF
+	}
+	if len(lines) != 2 {
+		return nil, errors.New("csv body must contain a header and one record")
+	}
+	data := make(map[string]interface{}, len(lines[0]))
+	for i, column := range lines[0] {
+		data[column] = textValue(lines[1][i])
+	}
+	return data, nil
+}
+
+// xmlCodec XML: корневой элемент <response>, поля объектов - элементы с именем поля,
+// элементы списков - <item>. Имя, недопустимое в XML, пишется как <field name="count(*)">.
+// NULL - пустой элемент с атрибутом nil="true". Тело запроса - корневой элемент с полями записи
+type xmlCodec struct{}
+
+func (xmlCodec) ContentType() string {
+	return "application/xml"
+}
+
+func (xmlCodec) Encode(w io.Writer, response Response) error {
+	if response.Response == nil {
+		return errNotRepresentable
+	}
+	tree, err := normalizeResponse(response)
+	if err != nil {
+		return err
+	}
+	io.WriteString(w, xml.Header)
+	encoder := xml.NewEncoder(w)
+	if err := encodeXML(encoder, "response", tree); err != nil {
+		return err
+	}
+	return encoder.Flush()
+}
+
+// encodeXML пишет значение дерева normalizeResponse элементом name
+func encodeXML(encoder *xml.Encoder, name string, value interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !isXMLName(name) {
+		start = xml.StartElement{
+			Name: xml.Name{Local: "field"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: name}},
+		}
+	}
+	if value == nil {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "nil"}, Value: "true"})
+	}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			if err := encodeXML(encoder, key, v[key]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := encodeXML(encoder, "item", item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := encoder.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+// isXMLName проверяет, что имя поля можно использовать как имя элемента
+func isXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		case i > 0 && (c == '-' || c == '.' || (c >= '0' && c <= '9')):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (xmlCodec) Decode(r io.Reader) (map[string]interface{}, error) {
+	decoder := xml.NewDecoder(r)
+	data := make(map[string]interface{})
+	depth := 0
+	var field string
+	var text strings.Builder
+	null := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF && depth == 0 && len(data) == 0 {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth > 2 {
+				return nil, errors.New("xml body must contain flat fields")
+			}
+			if depth == 2 {
+				field, null = t.Name.Local, false
+				text.Reset()
+				for _, attr := range t.Attr {
+					switch {
+					case t.Name.Local == "field" && attr.Name.Local == "name":
+						field = attr.Value
+					case attr.Name.Local == "nil":
+						null = attr.Value == "true"
+					}
+				}
+			}
+		case xml.CharData:
+			if depth == 2 {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if depth == 2 {
+				data[field] = textValue(text.String())
+				if null {
+					data[field] = nil
+				}
+			}
+			depth--
+			if depth == 0 {
+				return data, nil
+			}
+		}
+	}
+}
+
+// msgpackCodec MessagePack: ответ с теми же именами полей, что и JSON.
+// Числа и двоичные значения в теле запроса передаются своими типами
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackCodec) Encode(w io.Writer, response Response) error {
+	encoder := msgpack.NewEncoder(w)
+	encoder.SetCustomStructTag("json")
+	encoder.SetSortMapKeys(true)
+	return encoder.Encode(response)
+}
+
+func (msgpackCodec) Decode(r io.Reader) (map[string]interface{}, error) {
+	decoder := msgpack.NewDecoder(r)
+	// Целые приходят как int64 или uint64, а не как наименьший тип, которым закодированы
+	decoder.UseLooseInterfaceDecoding(true)
+	var data map[string]interface{}
+	err := decoder.Decode(&data)
+	return data, err
+}
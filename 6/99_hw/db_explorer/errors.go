@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Коды ошибок API. Клиенты опираются на них, а не на текст сообщения, поэтому коды не меняются
+const (
+	codeUnknownTable     = "unknown_table"
+	codeUnknownDatabase  = "unknown_database"
+	codeUnknownProcedure = "unknown_procedure"
+	codeUnknownQuery     = "unknown_query"
+	codeUnknownRoute     = "unknown_route"
+	codeNotFound         = "not_found"
+	codeBadRequest       = "bad_request"
+	codeUnauthorized     = "unauthorized"
+	codeReadOnly         = "read_only"
+	codeValidationFailed = "validation_failed"
+	codeTooLarge         = "too_large"
+	codeConflict         = "conflict"
+	codeRateLimited      = "rate_limited"
+	codeQueryTimeout     = "query_timeout"
+	codeUnavailable      = "unavailable"
+	codeInternal         = "internal"
+)
+
+// Коды ошибок отдельных полей в details
+const (
+	fieldInvalidType  = "invalid_type"
+	fieldReadOnly     = "read_only"
+	fieldRequired     = "required"
+	fieldInvalidValue = "invalid_value"
+)
+
+// Номера ошибок MySQL, которые означают конфликт с данными или схемой в базе.
+// 1216 и 1217 - старые варианты 1452 и 1451
+const (
+	mysqlDuplicateEntry        = 1062
+	mysqlDuplicateEntryWithKey = 1586
+	mysqlRowIsReferenced       = 1451
+	mysqlRowIsReferencedOld    = 1217
+	mysqlNoReferencedRow       = 1452
+	mysqlNoReferencedRowOld    = 1216
+	mysqlTableExists           = 1050
+	mysqlDuplicateColumn       = 1060
+	mysqlDuplicateKeyName      = 1061
+)
+
+// mysqlSignalException ошибка, которую процедура выбрасывает через SIGNAL.
+// Ее текст задает автор процедуры, поэтому он отдается клиенту
+const mysqlSignalException = 1644
+
+// APIError ошибка, которая отдается клиенту: HTTP-статус, стабильный код,
+// сообщение и, для ошибок валидации, список ошибок по полям
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Details []FieldError
+}
+
+// FieldError ошибка одного поля запроса
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Extensions добавляет код и details в ошибки GraphQL
+func (e *APIError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"code": e.Code}
+	if len(e.Details) > 0 {
+		extensions["details"] = e.Details
+	}
+	return extensions
+}
+
+// newAPIError создает ошибку без details
+func newAPIError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// errUnknownTable ошибка обращения к таблице, которой нет в кеше
+func errUnknownTable() *APIError {
+	return newAPIError(http.StatusNotFound, codeUnknownTable, "unknown table")
+}
+
+// errUnknownDatabase ошибка обращения к базе /db/$name, которой нет в конфиге
+func errUnknownDatabase() *APIError {
+	return newAPIError(http.StatusNotFound, codeUnknownDatabase, "unknown database")
+}
+
+// errUnknownProcedure ошибка вызова хранимой процедуры, которой нет в кеше
+func errUnknownProcedure() *APIError {
+	return newAPIError(http.StatusNotFound, codeUnknownProcedure, "unknown procedure")
+}
+
+// errUnknownQuery ошибка обращения к именованному запросу, которого нет в конфиге
+func errUnknownQuery() *APIError {
+	return newAPIError(http.StatusNotFound, codeUnknownQuery, "unknown query")
+}
+
+// errReadOnly ошибка записи в представление
+func errReadOnly() *APIError {
+	return newAPIError(http.StatusMethodNotAllowed, codeReadOnly, "view is read-only")
+}
+
+// errUnknownRoute ошибка обращения к несуществующему маршруту
+func errUnknownRoute() *APIError {
+	return newAPIError(http.StatusNotFound, codeUnknownRoute, "unknown method")
+}
+
+// errRecordNotFound ошибка обращения к несуществующей записи
+func errRecordNotFound() *APIError {
+	return newAPIError(http.StatusNotFound, codeNotFound, "record not found")
+}
+
+// errBadRequest ошибка в параметрах или теле запроса
+func errBadRequest(message string) *APIError {
+	return newAPIError(http.StatusBadRequest, codeBadRequest, message)
+}
+
+// errTooLarge ошибка слишком большого тела запроса
+func errTooLarge(message string) *APIError {
+	return newAPIError(http.StatusRequestEntityTooLarge, codeTooLarge, message)
+}
+
+// errValidation собирает ошибки полей в одну ошибку validation_failed.
+// Поля сортируются, чтобы ответ не зависел от порядка обхода map
+func errValidation(details []FieldError) *APIError {
+	sort.Slice(details, func(i, j int) bool {
+		return details[i].Field < details[j].Field
+	})
+	messages := make([]string, 0, len(details))
+	for _, detail := range details {
+		messages = append(messages, detail.Message)
+	}
+	return &APIError{
+		Status:  http.StatusBadRequest,
+		Code:    codeValidationFailed,
+		Message: strings.Join(messages, "; "),
+		Details: details,
+	}
+}
+
+// toAPIError приводит ошибку выполнения запроса к ошибке API:
+//   - таймаут запроса - 504
+//   - нарушение уникальности и внешних ключей MySQL, существующие таблица, колонка, индекс - 409
+//   - остальное - 500 без подробностей, чтобы не раскрывать устройство базы
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newAPIError(http.StatusGatewayTimeout, codeQueryTimeout, "query timeout")
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case mysqlDuplicateEntry, mysqlDuplicateEntryWithKey:
+			return newAPIError(http.StatusConflict, codeConflict, "duplicate key")
+		case mysqlRowIsReferenced, mysqlRowIsReferencedOld:
+			return newAPIError(http.StatusConflict, codeConflict, "record is referenced by another table")
+		case mysqlNoReferencedRow, mysqlNoReferencedRowOld:
+			return newAPIError(http.StatusConflict, codeConflict, "referenced record does not exist")
+		case mysqlTableExists:
+			return newAPIError(http.StatusConflict, codeConflict, "table already exists")
+		case mysqlDuplicateColumn:
+			return newAPIError(http.StatusConflict, codeConflict, "column already exists")
+		case mysqlDuplicateKeyName:
+			return newAPIError(http.StatusConflict, codeConflict, "index already exists")
+		}
+	}
+	return newAPIError(http.StatusInternalServerError, codeInternal, "db error")
+}
+
+// writeError отдает ошибку в формате {"error": "...", "code": "...", "details": [...]}
+// с Content-Type application/json
+func writeError(w http.ResponseWriter, err error) {
+	apiErr := toAPIError(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(Response{
+		Error:   apiErr.Message,
+		Code:    apiErr.Code,
+		Details: apiErr.Details,
+	})
+}
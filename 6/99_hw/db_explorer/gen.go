@@ -0,0 +1,302 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// defaultClientImport путь пакета client этого модуля для сгенерированных оберток
+const defaultClientImport = "db_explorer/client"
+
+// genTable таблица или представление для генерации структуры
+type genTable struct {
+	Name       string
+	View       bool
+	PrimaryKey string
+	Columns    []genColumn // в порядке колонок таблицы
+}
+
+// genColumn колонка таблицы и ее тип Go
+type genColumn struct {
+	Name   string
+	GoType string
+	Info   ColumnInfo
+}
+
+// runGen выполняет подкоманду gen и возвращает код выхода:
+//
+//	db_explorer gen [-config file] [-dsn dsn] [-package models] [-out file] [-tables a,b] [-client] [-client-import path]
+//
+// Подключается к базе и печатает структуры Go с тегами json и db для каждой таблицы.
+// С -client добавляет типизированные обертки над пакетом client для API explorer
+func runGen(args []string, getenv func(string) string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gen", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: db_explorer gen [flags]")
+		fs.PrintDefaults()
+	}
+	path := fs.String("config", getenv(envPrefix+"CONFIG"), "path to YAML config file")
+	dsn := fs.String("dsn", "", "MySQL DSN")
+	pkg := fs.String("package", "models", "package name of the generated file")
+	out := fs.String("out", "", "output file, stdout if empty")
+	tables := fs.String("tables", "", "comma-separated tables to generate, overrides tables.allow from config")
+	withClient := fs.Bool("client", false, "generate typed helpers for the explorer API")
+	clientImport := fs.String("client-import", defaultClientImport, "import path of the client package")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if !token.IsIdentifier(*pkg) || token.IsKeyword(*pkg) {
+		fmt.Fprintf(stderr, "gen: package: %q is not a valid package name\n", *pkg)
+		return 2
+	}
+
+	config, err := commandConfig(*path, *dsn, getenv)
+	if err != nil {
+		fmt.Fprintln(stderr, "gen:", err)
+		return 2
+	}
+	if *tables != "" {
+		config.Tables.Allow = splitList(*tables)
+	}
+
+	db, err := sql.Open("mysql", config.DSN)
+	if err != nil {
+		fmt.Fprintln(stderr, "gen:", err)
+		return 1
+	}
+	defer db.Close()
+
+	schema, err := loadGenTables(db, config.Tables)
+	if err != nil {
+		fmt.Fprintln(stderr, "gen:", err)
+		return 1
+	}
+	code, err := generateModels(schema, *pkg, *withClient, *clientImport)
+	if err != nil {
+		fmt.Fprintln(stderr, "gen:", err)
+		return 1
+	}
+
+	if *out == "" {
+		stdout.Write(code)
+		return 0
+	}
+	if err := os.WriteFile(*out, code, 0644); err != nil {
+		fmt.Fprintln(stderr, "gen:", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "generated %d tables in %s\n", len(schema), *out)
+	return 0
+}
+
+// loadGenTables читает таблицы, разрешенные filter, и их колонки так же, как explorer при загрузке схемы
+func loadGenTables(db *sql.DB, filter FilterConfig) ([]genTable, error) {
+	names, tableTypes, err := showTables(db)
+	if err != nil {
+		return nil, err
+	}
+	names, err = filterNames("table", names, filter.Allow, filter.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]genTable, 0, len(names))
+	for _, name := range names {
+		columns, primaryKey, err := showColumns(db, name)
+		if err != nil {
+			return nil, err
+		}
+		table := genTable{Name: name, View: tableTypes[name] == "VIEW", PrimaryKey: primaryKey}
+		for column, info := range columns {
+			table.Columns = append(table.Columns, genColumn{Name: column, GoType: goType(info), Info: info})
+		}
+		sort.Slice(table.Columns, func(i, j int) bool {
+			return table.Columns[i].Info.Position < table.Columns[j].Info.Position
+		})
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// goType подбирает тип Go, который читается и через database/sql, и из JSON ответа explorer.
+// Даты, время, DECIMAL, BIT и JSON explorer отдает строками, поэтому в структуре они тоже строки.
+// Двоичные колонки - []byte (в JSON base64), NULL у них - nil. Остальные NULL-колонки - указатели
+func goType(info ColumnInfo) string {
+	base, _ := parseSQLType(info.Type)
+	unsigned := strings.Contains(strings.ToLower(info.Type), "unsigned")
+
+	var typ string
+	switch base {
+	case "tinyint":
+		typ = "int8"
+	case "smallint", "year":
+		typ = "int16"
+	case "mediumint", "int", "integer":
+		typ = "int32"
+	case "bigint":
+		typ = "int64"
+	case "float":
+		typ = "float32"
+	case "double", "real":
+		typ = "float64"
+	default:
+		typ = "string"
+	}
+	if unsigned && strings.HasPrefix(typ, "int") {
+		typ = "u" + typ
+	}
+	if normalizeType(info.Type) == "binary" {
+		return "[]byte"
+	}
+	if info.Nullable {
+		return "*" + typ
+	}
+	return typ
+}
+
+// commonInitialisms части имен, которые в Go пишутся заглавными целиком: user_id -> UserID
+func commonInitialisms() map[string]bool {
+	return map[string]bool{
+		"api": true, "html": true, "http": true, "id": true, "ip": true, "json": true,
+		"sql": true, "uid": true, "uri": true, "url": true, "uuid": true, "xml": true,
+	}
+}
+
+// goName переводит имя таблицы или колонки в экспортируемый идентификатор Go: created_at -> CreatedAt.
+// Имя, которое не начинается с буквы, получает префикс X
+func goName(name string, initialisms map[string]bool) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var result strings.Builder
+	for _, part := range parts {
+		if initialisms[strings.ToLower(part)] {
+			result.WriteString(strings.ToUpper(part))
+			continue
+		}
+		runes := []rune(part)
+		result.WriteRune(unicode.ToUpper(runes[0]))
+		result.WriteString(string(runes[1:]))
+	}
+	identifier := result.String()
+	if identifier == "" || !unicode.IsLetter([]rune(identifier)[0]) {
+		identifier = "X" + identifier
+	}
+	return identifier
+}
+
+// uniqueName возвращает name или name2, name3 и т.д., если имя уже занято, и занимает его
+func uniqueName(name string, taken map[string]bool) string {
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	taken[unique] = true
+	return unique
+}
+
+// generateModels формирует файл Go со структурами таблиц и, с withClient, обертками над пакетом client.
+// У автоинкрементного ключа тег json с omitempty: при создании записи explorer его не ждет
+func generateModels(tables []genTable, pkg string, withClient bool, clientImport string) ([]byte, error) {
+	initialisms := commonInitialisms()
+	taken := make(map[string]bool)
+
+	// Тело пишется первым: fmt нужен, только если у какой-то таблицы есть обертки по id
+	var b strings.Builder
+	usesID := false
+	for _, table := range tables {
+		typeName := uniqueName(goName(table.Name, initialisms), taken)
+		kind := "таблицы"
+		if table.View {
+			kind = "представления"
+		}
+
+		fmt.Fprintf(&b, "\n// %s запись %s %s\ntype %s struct {\n", typeName, kind, table.Name, typeName)
+		fields := make(map[string]bool)
+		idType := ""
+		for _, column := range table.Columns {
+			field := uniqueName(goName(column.Name, initialisms), fields)
+			jsonTag := column.Name
+			if column.Info.AutoIncrement {
+				jsonTag += ",omitempty"
+			}
+			fmt.Fprintf(&b, "\t%s %s `json:%q db:%q`\n", field, column.GoType, jsonTag, column.Name)
+			if column.Name == table.PrimaryKey {
+				idType = strings.TrimPrefix(column.GoType, "*")
+			}
+		}
+		b.WriteString("}\n")
+
+		if withClient {
+			writeTableClient(&b, table, typeName, uniqueName(typeName+"Table", taken), idType)
+			usesID = usesID || (!table.View && idType != "")
+		}
+	}
+
+	var header strings.Builder
+	header.WriteString("// Code generated by db_explorer gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&header, "package %s\n", pkg)
+	if withClient && len(tables) > 0 {
+		header.WriteString("\nimport (\n\t\"context\"\n")
+		if usesID {
+			header.WriteString("\t\"fmt\"\n")
+		}
+		fmt.Fprintf(&header, "\n\tclient %q\n)\n", clientImport)
+	}
+
+	code, err := format.Source([]byte(header.String() + b.String()))
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+	return code, nil
+}
+
+// writeTableClient пишет обертку над client для одной таблицы. У представления и таблицы
+// без первичного ключа есть только List: записи нельзя адресовать по id или изменить
+func writeTableClient(b *strings.Builder, table genTable, typeName, clientName, idType string) {
+	fmt.Fprintf(b, "\n// %s доступ к %s через API db_explorer\n", clientName, table.Name)
+	fmt.Fprintf(b, "type %s struct {\n\tc *client.Client\n}\n", clientName)
+	fmt.Fprintf(b, "\n// New%s создает обертку для %s\n", clientName, table.Name)
+	fmt.Fprintf(b, "func New%s(c *client.Client) %s {\n\treturn %s{c: c}\n}\n", clientName, clientName, clientName)
+
+	fmt.Fprintf(b, "\n// List читает страницу записей %s\n", table.Name)
+	fmt.Fprintf(b, "func (t %s) List(ctx context.Context, opts client.ListOptions) (*client.Page[%s], error) {\n", clientName, typeName)
+	fmt.Fprintf(b, "\treturn client.List[%s](ctx, t.c, %q, opts)\n}\n", typeName, table.Name)
+	if table.View || idType == "" {
+		return
+	}
+
+	// Двоичный ключ в пути передается строкой, как его отдает explorer
+	if idType == "[]byte" {
+		idType = "string"
+	}
+	fmt.Fprintf(b, "\n// Get читает запись %s по %s\n", table.Name, table.PrimaryKey)
+	fmt.Fprintf(b, "func (t %s) Get(ctx context.Context, id %s) (*%s, error) {\n", clientName, idType, typeName)
+	fmt.Fprintf(b, "\treturn client.Get[%s](ctx, t.c, %q, fmt.Sprint(id))\n}\n", typeName, table.Name)
+
+	fmt.Fprintf(b, "\n// Create создает запись %s и возвращает ее %s\n", table.Name, table.PrimaryKey)
+	fmt.Fprintf(b, "func (t %s) Create(ctx context.Context, record %s) (string, error) {\n", clientName, typeName)
+	fmt.Fprintf(b, "\treturn t.c.Create(ctx, %q, record)\n}\n", table.Name)
+
+	fmt.Fprintf(b, "\n// Update обновляет переданные поля записи %s\n", table.Name)
+	fmt.Fprintf(b, "func (t %s) Update(ctx context.Context, id %s, fields client.Record) (int64, error) {\n", clientName, idType)
+	fmt.Fprintf(b, "\treturn t.c.Update(ctx, %q, fmt.Sprint(id), fields)\n}\n", table.Name)
+
+	fmt.Fprintf(b, "\n// Delete удаляет запись %s\n", table.Name)
+	fmt.Fprintf(b, "func (t %s) Delete(ctx context.Context, id %s) (int64, error) {\n", clientName, idType)
+	fmt.Fprintf(b, "\treturn t.c.Delete(ctx, %q, fmt.Sprint(id))\n}\n", table.Name)
+}
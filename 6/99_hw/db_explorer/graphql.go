@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+)
+
+// graphqlRequest тело запроса POST /graphql
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// graphqlLoadersKey ключ контекста, под которым лежат загрузчики связей текущего запроса
+type graphqlLoadersKey struct{}
+
+// handleGraphQL обрабатывает запрос POST /graphql.
+// Ответ отдается в формате GraphQL ({"data": ..., "errors": [...]}), а не в Response
+func (explorer *DbExplorer) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var request graphqlRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, errBadRequest("invalid json body"))
+		return
+	}
+
+	// Загрузчики живут ровно один запрос, чтобы не отдавать устаревшие данные
+	ctx := context.WithValue(r.Context(), graphqlLoadersKey{}, make(map[string]*graphqlLoader))
+
+	result := graphql.Do(graphql.Params{
+		Schema:         explorer.graphqlSchema,
+		RequestString:  request.Query,
+		VariableValues: request.Variables,
+		OperationName:  request.OperationName,
+		Context:        ctx,
+	})
+	json.NewEncoder(w).Encode(result)
+}
+
+// buildGraphQLSchema генерирует схему по закешированным таблицам.
+// Для каждой таблицы создаются:
+//   - тип с колонками и вложенными полями по внешним ключам
+//   - запросы $table(filter, order_by, desc, limit, offset) и $table_by_pk(id)
+//   - мутации create_$table, update_$table, delete_$table
+//
+// Таблицы и колонки, имена которых недопустимы в GraphQL, пропускаются
+func (explorer *DbExplorer) buildGraphQLSchema() (graphql.Schema, error) {
+	objects := make(map[string]*graphql.Object)
+	for _, table := range explorer.tables {
+		if isGraphQLName(table) {
+			objects[table] = explorer.graphqlObject(table, objects)
+		}
+	}
+
+	// Query не может быть пустым, поэтому список таблиц есть всегда
+	queryFields := graphql.Fields{
+		"_tables": &graphql.Field{
+			Type: graphql.NewList(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return explorer.tables, nil
+			},
+		},
+	}
+	mutationFields := graphql.Fields{}
+
+	for _, table := range explorer.tables {
+		object, ok := objects[table]
+		if !ok {
+			continue
+		}
+
+		listArgs := graphql.FieldConfigArgument{
+			"order_by": &graphql.ArgumentConfig{Type: graphql.String},
+			"desc":     &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+			"limit":    &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: explorer.defaultLimit},
+			"offset":   &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+		}
+		if _, ok := explorer.softDelete[table]; ok {
+			listArgs["include_deleted"] = &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false}
+		}
+		if filter := explorer.graphqlInput(table, table+"_filter", true); filter != nil {
+			listArgs["filter"] = &graphql.ArgumentConfig{Type: filter}
+		}
+		queryFields[table] = &graphql.Field{
+			Type:    graphql.NewList(object),
+			Args:    listArgs,
+			Resolve: explorer.resolveList(table),
+		}
+
+		// Без первичного ключа нельзя адресовать запись
+		if explorer.primaryKey[table] == "" {
+			continue
+		}
+		idArgs := graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+		}
+		queryFields[table+"_by_pk"] = &graphql.Field{
+			Type:    object,
+			Args:    idArgs,
+			Resolve: explorer.resolveByPk(table),
+		}
+		mutationFields["delete_"+table] = &graphql.Field{
+			Type:    graphql.Int,
+			Args:    idArgs,
+			Resolve: explorer.resolveDelete(table),
+		}
+
+		input := explorer.graphqlInput(table, table+"_input", false)
+		if input == nil {
+			continue
+		}
+		mutationFields["create_"+table] = &graphql.Field{
+			Type: object,
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(input)},
+			},
+			Resolve: explorer.resolveCreate(table),
+		}
+		mutationFields["update_"+table] = &graphql.Field{
+			Type: graphql.Int,
+			Args: graphql.FieldConfigArgument{
+				"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(input)},
+			},
+			Resolve: explorer.resolveUpdate(table),
+		}
+	}
+
+	config := graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: queryFields}),
+	}
+	if len(mutationFields) > 0 {
+		config.Mutation = graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: mutationFields})
+	}
+	return graphql.NewSchema(config)
+}
+
+// graphqlObject создает тип таблицы. Поля задаются через thunk,
+// потому что связанные таблицы могут ссылаться друг на друга
+func (explorer *DbExplorer) graphqlObject(table string, objects map[string]*graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: table,
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			fields := graphql.Fields{}
+			// Колонки читаются из map записи резолвером по умолчанию
+			for column, info := range explorer.columns[table] {
+				if !isGraphQLName(column) {
+					continue
+				}
+				var fieldType graphql.Output = graphqlScalar(info)
+				if !info.Nullable {
+					fieldType = graphql.NewNonNull(fieldType)
+				}
+				fields[column] = &graphql.Field{Type: fieldType}
+			}
+
+			// Ссылка на родительскую запись: items.user_id -> items.user
+			for _, fk := range explorer.foreignKeys[table] {
+				parent, ok := objects[fk.RefTable]
+				name := graphqlRelationName(fk)
+				if _, exists := fields[name]; !ok || exists || !isGraphQLName(name) {
+					continue
+				}
+				fields[name] = &graphql.Field{Type: parent, Resolve: explorer.resolveParent(fk)}
+			}
+
+			// Обратная связь на дочерние записи: users.items_by_user_id
+			for _, child := range explorer.tables {
+				childObject, ok := objects[child]
+				if !ok {
+					continue
+				}
+				for _, fk := range explorer.foreignKeys[child] {
+					name := child + "_by_" + fk.Column
+					if _, exists := fields[name]; fk.RefTable != table || exists || !isGraphQLName(name) {
+						continue
+					}
+					fields[name] = &graphql.Field{
+						Type:    graphql.NewList(childObject),
+						Resolve: explorer.resolveChildren(child, fk),
+					}
+				}
+			}
+			return fields
+		}),
+	})
+}
+
+// graphqlInput создает входной тип из колонок таблицы.
+// Для фильтра берутся все колонки, кроме зарезервированных имен параметров (их не пропустит buildFilters),
+// для мутаций - все, кроме первичного ключа.
+// Возвращает nil, если подходящих колонок нет
+func (explorer *DbExplorer) graphqlInput(table, name string, withPrimaryKey bool) *graphql.InputObject {
+	reserved := reservedParams()
+	fields := graphql.InputObjectConfigFieldMap{}
+	for column, info := range explorer.columns[table] {
+		if !isGraphQLName(column) || (!withPrimaryKey && column == explorer.primaryKey[table]) ||
+			(withPrimaryKey && reserved[column]) {
+			continue
+		}
+		fields[column] = &graphql.InputObjectFieldConfig{Type: graphqlScalar(info)}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return graphql.NewInputObject(graphql.InputObjectConfig{Name: name, Fields: fields})
+}
+
+// resolveList возвращает записи таблицы с фильтром, сортировкой и пагинацией
+func (explorer *DbExplorer) resolveList(table string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		// Фильтр переводится в query параметры, чтобы использовать тот же buildFilters, что и REST
+		values := url.Values{}
+		if filter, ok := p.Args["filter"].(map[string]interface{}); ok {
+			for field, value := range filter {
+				if value != nil {
+					values.Set(field, fmt.Sprint(value))
+				}
+			}
+		}
+		if includeDeleted, _ := p.Args["include_deleted"].(bool); includeDeleted {
+			values.Set("include_deleted", "1")
+		}
+		where, args := explorer.buildFilters(table, values)
+
+		order := ""
+		if orderBy, _ := p.Args["order_by"].(string); orderBy != "" {
+			if _, ok := explorer.columns[table][orderBy]; !ok {
+				return nil, fmt.Errorf("unknown column %s", orderBy)
+			}
+			order = fmt.Sprintf(" ORDER BY `%s`", orderBy)
+			if desc, _ := p.Args["desc"].(bool); desc {
+				order += " DESC"
+			}
+		}
+
+		query := fmt.Sprintf("SELECT %s FROM `%s`%s%s LIMIT ? OFFSET ?", explorer.listColumns(table, false), table, where, order)
+		// Явный null в limit или offset означает значение по умолчанию
+		limit, ok := p.Args["limit"].(int)
+		if !ok {
+			limit = explorer.defaultLimit
+		}
+		offset, _ := p.Args["offset"].(int)
+		args = append(args, explorer.capLimit(limit), offset)
+		return explorer.queryRecords(p.Context, explorer.router.reader(p.Context), query, args...)
+	}
+}
+
+// resolveByPk возвращает запись по первичному ключу или null
+func (explorer *DbExplorer) resolveByPk(table string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		record, err := explorer.findRecord(p.Context, explorer.router.reader(p.Context), table, p.Args["id"].(string), false)
+		if err != nil || record == nil {
+			return nil, err
+		}
+		return record, nil
+	}
+}
+
+// resolveCreate создает запись и возвращает ее целиком
+func (explorer *DbExplorer) resolveCreate(table string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		input, _ := p.Args["input"].(map[string]interface{})
+		query, values, err := explorer.buildInsert(table, explorer.columns[table], input)
+		if err != nil {
+			return nil, err
+		}
+
+		// Созданную запись читаем из primary: в реплику она могла еще не попасть
+		db := explorer.router.writer(p.Context)
+		result, err := explorer.exec(p.Context, db, query, values...)
+		explorer.invalidateCache(table)
+		if err != nil {
+			return nil, err
+		}
+		id, _ := result.LastInsertId()
+
+		record, err := explorer.findRecord(p.Context, db, table, fmt.Sprint(id), false)
+		if err != nil || record == nil {
+			return nil, err
+		}
+		return record, nil
+	}
+}
+
+// resolveUpdate обновляет запись и возвращает количество обновленных строк
+func (explorer *DbExplorer) resolveUpdate(table string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		input, _ := p.Args["input"].(map[string]interface{})
+		query, values, err := explorer.buildUpdate(table, explorer.columns[table], input, p.Args["id"].(string))
+		if err != nil || query == "" {
+			return 0, err
+		}
+
+		result, err := explorer.exec(p.Context, explorer.router.writer(p.Context), query, values...)
+		explorer.invalidateCache(table)
+		if err != nil {
+			return nil, err
+		}
+		affected, _ := result.RowsAffected()
+		return affected, nil
+	}
+}
+
+// resolveDelete удаляет запись и возвращает количество удаленных строк
+func (explorer *DbExplorer) resolveDelete(table string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		return explorer.deleteRecord(p.Context, explorer.router.writer(p.Context), table, p.Args["id"].(string))
+	}
+}
+
+// resolveParent возвращает запись, на которую ссылается внешний ключ.
+// Загрузка откладывается через thunk, чтобы собрать ключи всех записей уровня в один запрос
+func (explorer *DbExplorer) resolveParent(fk ForeignKey) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		source, _ := p.Source.(map[string]interface{})
+		if source[fk.Column] == nil {
+			return nil, nil
+		}
+		load := graphqlLoaderFrom(p.Context, explorer, fk.RefTable, fk.RefColumn).load(p.Context, source[fk.Column])
+		return func() (interface{}, error) {
+			records, err := load()
+			if err != nil || len(records) == 0 {
+				return nil, err
+			}
+			return records[0], nil
+		}, nil
+	}
+}
+
+// resolveChildren возвращает записи таблицы child, которые ссылаются на текущую запись
+func (explorer *DbExplorer) resolveChildren(child string, fk ForeignKey) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		source, _ := p.Source.(map[string]interface{})
+		if source[fk.RefColumn] == nil {
+			return []map[string]interface{}{}, nil
+		}
+		load := graphqlLoaderFrom(p.Context, explorer, child, fk.Column).load(p.Context, source[fk.RefColumn])
+		return func() (interface{}, error) {
+			records, err := load()
+			if err != nil {
+				return nil, err
+			}
+			if records == nil {
+				records = []map[string]interface{}{}
+			}
+			return records, nil
+		}, nil
+	}
+}
+
+// graphqlLoader собирает ключи, запрошенные на одном уровне запроса,
+// и загружает их одним SELECT ... WHERE column IN (...), чтобы вложенные поля не давали N+1 запросов.
+// Резолверы graphql-go выполняются последовательно, поэтому мьютекс не нужен
+type graphqlLoader struct {
+	explorer *DbExplorer
+	db       *sql.DB
+	table    string
+	column   string
+	pending  []interface{}
+	seen     map[string]bool
+	results  map[string][]map[string]interface{}
+	err      error
+}
+
+// graphqlLoaderFrom возвращает загрузчик для пары таблица-колонка из контекста запроса
+func graphqlLoaderFrom(ctx context.Context, explorer *DbExplorer, table, column string) *graphqlLoader {
+	loaders := ctx.Value(graphqlLoadersKey{}).(map[string]*graphqlLoader)
+	key := table + "." + column
+	if loader, ok := loaders[key]; ok {
+		return loader
+	}
+	loader := &graphqlLoader{
+		explorer: explorer,
+		db:       explorer.router.reader(ctx),
+		table:    table,
+		column:   column,
+		seen:     make(map[string]bool),
+		results:  make(map[string][]map[string]interface{}),
+	}
+	loaders[key] = loader
+	return loader
+}
+
+// load ставит ключ в очередь и возвращает функцию, которая при первом вызове
+// загружает сразу все накопленные ключи
+func (loader *graphqlLoader) load(ctx context.Context, key interface{}) func() ([]map[string]interface{}, error) {
+	normalized := fmt.Sprint(key)
+	if !loader.seen[normalized] {
+		loader.seen[normalized] = true
+		loader.pending = append(loader.pending, key)
+	}
+	return func() ([]map[string]interface{}, error) {
+		if len(loader.pending) > 0 {
+			loader.fetch(ctx)
+		}
+		return loader.results[normalized], loader.err
+	}
+}
+
+// fetch загружает все ключи из очереди одним запросом
+func (loader *graphqlLoader) fetch(ctx context.Context) {
+	keys := loader.pending
+	loader.pending = nil
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")
+	query := fmt.Sprintf("SELECT * FROM `%s` WHERE `%s` IN (%s)", loader.table, loader.column, placeholders)
+	if condition := loader.explorer.softDeleteCondition(loader.table, false); condition != "" {
+		query += " AND " + condition
+	}
+	// Дочерние записи отдаются в порядке первичного ключа
+	if primaryKey := loader.explorer.primaryKey[loader.table]; primaryKey != "" {
+		query += fmt.Sprintf(" ORDER BY `%s`", primaryKey)
+	}
+	records, err := loader.explorer.queryRecords(ctx, loader.db, query, keys...)
+	if err != nil {
+		loader.err = err
+		return
+	}
+	for _, record := range records {
+		normalized := fmt.Sprint(record[loader.column])
+		loader.results[normalized] = append(loader.results[normalized], record)
+	}
+}
+
+// graphqlScalar сопоставляет SQL-тип колонки скалярному типу GraphQL.
+// bigint отдается как Float, потому что Int в GraphQL 32-битный
+func graphqlScalar(info ColumnInfo) *graphql.Scalar {
+	typ := strings.ToLower(info.Type)
+	switch {
+	case strings.Contains(typ, "bigint"):
+		return graphql.Float
+	case strings.Contains(typ, "int"):
+		return graphql.Int
+	case strings.Contains(typ, "float") || strings.Contains(typ, "double") || strings.Contains(typ, "decimal"):
+		return graphql.Float
+	}
+	return graphql.String
+}
+
+// graphqlRelationName имя поля для ссылки на родителя: user_id -> user, author -> author_users
+func graphqlRelationName(fk ForeignKey) string {
+	if name := strings.TrimSuffix(fk.Column, "_id"); name != fk.Column && name != "" {
+		return name
+	}
+	return fk.Column + "_" + fk.RefTable
+}
+
+// isGraphQLName проверяет, что строка подходит как имя в GraphQL: /^[_A-Za-z][_0-9A-Za-z]*$/,
+// имена с "__" зарезервированы
+func isGraphQLName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// readyTimeout ограничивает пинг базы в /readyz, чтобы оркестратор не ждал дольше своего таймаута
+const readyTimeout = 2 * time.Second
+
+// handleHealth отвечает на /healthz и /readyz. Возвращает false для остальных путей.
+// ready возвращает причину, по которой сервис не готов принимать запросы, или nil
+func handleHealth(w http.ResponseWriter, r *http.Request, ready func(ctx context.Context) error) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	switch strings.Trim(r.URL.Path, "/") {
+	case "healthz":
+		// процесс жив и обрабатывает запросы, состояние базы не проверяется
+		writeStatus(w, "ok")
+	case "readyz":
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		if err := ready(ctx); err != nil {
+			writeError(w, newAPIError(http.StatusServiceUnavailable, codeUnavailable, err.Error()))
+			return true
+		}
+		writeStatus(w, "ready")
+	default:
+		return false
+	}
+	return true
+}
+
+// writeStatus отдает {"status": status}
+func writeStatus(w http.ResponseWriter, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
+
+// ready проверяет, что primary отвечает. Схема к этому моменту уже загружена в NewDbExplorer
+func (explorer *DbExplorer) ready(ctx context.Context) error {
+	if err := explorer.db.PingContext(ctx); err != nil {
+		return errors.New("database unavailable")
+	}
+	return nil
+}
+
+// startupHandler отвечает на запросы, пока идет подключение к базе и загрузка схемы:
+// /healthz - 200, /readyz и остальные пути - 503.
+// После setReady все запросы передаются готовому обработчику
+type startupHandler struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+// setReady подключает готовый обработчик
+func (startup *startupHandler) setReady(handler http.Handler) {
+	startup.handler.Store(&handler)
+}
+
+// Close останавливает готовый обработчик, если он подключен
+func (startup *startupHandler) Close() error {
+	if handler := startup.handler.Load(); handler != nil {
+		return closeHandler(*handler)
+	}
+	return nil
+}
+
+func (startup *startupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler := startup.handler.Load(); handler != nil {
+		(*handler).ServeHTTP(w, r)
+		return
+	}
+
+	notReady := func(ctx context.Context) error { return errors.New("schema not loaded") }
+	if handleHealth(w, r, notReady) {
+		return
+	}
+	writeError(w, newAPIError(http.StatusServiceUnavailable, codeUnavailable, "schema not loaded"))
+}
+
+// permanentError ошибка, которую повтор не исправит, например неверная настройка
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// permanent помечает ошибку attempt как окончательную: retryWithBackoff сразу вернет ее
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// retryWithBackoff вызывает attempt, пока он не выполнится без ошибки или не отменится ctx.
+// Пауза между попытками удваивается от initial до max. Ошибка, обернутая в permanent,
+// возвращается сразу, без повторов
+func retryWithBackoff(ctx context.Context, initial, max time.Duration, attempt func() error) error {
+	delay := initial
+	for {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		var stop *permanentError
+		if errors.As(err, &stop) {
+			return stop.err
+		}
+		log.Printf("startup: %v, retrying in %s", err, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > max {
+			delay = max
+		}
+	}
+}
@@ -1,32 +1,154 @@
-// тут лежит тестовый код
-// менять вам может потребоваться только коннект к базе
+// Запуск сервиса: подкоманды migrate и gen, загрузка конфига, подключение к базам
+// с повторами, сервер с корректной остановкой по SIGTERM
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
-var (
-	// DSN это соединение с базой
-	DSN = "root:love@tcp(127.0.0.1:3306)/photolist?charset=utf8"
-)
+// DSN соединение с базой по умолчанию, переопределяется конфигом (см. Config)
+const DSN = "root:love@tcp(127.0.0.1:3306)/photolist?charset=utf8"
 
 func main() {
-	db, err := sql.Open("mysql", DSN)
-	err = db.Ping() // вот тут будет первое подключение к базе
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:], os.Getenv, os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gen" {
+		os.Exit(runGen(os.Args[2:], os.Getenv, os.Stdout, os.Stderr))
+	}
+
+	config, err := loadConfig(os.Args[1:], os.Getenv, os.Stderr)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
 	}
 
-	handler, err := NewDbExplorer(db)
+	// ctx отменяется по SIGTERM от оркестратора или Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	db := openDB(config, config.DSN)
+	defer db.Close()
+	replicas := openReplicas(config, config.Replicas.DSNs)
+	defer closeAll(replicas)
+	// Именованные базы из конфига, доступные по /db/$name
+	databases := make(map[string]*sql.DB, len(config.Databases))
+	databaseReplicas := make(map[string][]*sql.DB, len(config.Databases))
+	for name, database := range config.Databases {
+		databases[name] = openDB(config, database.DSN)
+		defer databases[name].Close()
+		databaseReplicas[name] = openReplicas(config, database.Replicas.DSNs)
+		defer closeAll(databaseReplicas[name])
+	}
+
+	// Сервер стартует сразу и отвечает на /healthz, пока база недоступна.
+	// Остальные запросы получают 503, пока не загрузится схема
+	startup := &startupHandler{}
+	server := &http.Server{Addr: config.Listen, Handler: startup}
+	serveErr := make(chan error, 1)
+	go func() {
+		fmt.Println("starting server at", config.Listen)
+		if config.TLS.Cert != "" {
+			serveErr <- server.ListenAndServeTLS(config.TLS.Cert, config.TLS.Key)
+		} else {
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	// Повторяются только подключения к базам. Ошибка настройки (неизвестная таблица в tables.allow,
+	// неверный именованный запрос и т.п.) повтором не исправится, с ней процесс завершается
+	startupErr := make(chan error, 1)
+	go func() {
+		err := retryWithBackoff(ctx, time.Second, 30*time.Second, func() error {
+			if err := db.PingContext(ctx); err != nil { // вот тут будет первое подключение к базе
+				return err
+			}
+			for name, namedDB := range databases {
+				if err := namedDB.PingContext(ctx); err != nil {
+					return fmt.Errorf("database %s: %w", name, err)
+				}
+			}
+
+			handler, err := NewDbExplorer(db, config.options(replicas)...)
+			if err != nil {
+				return permanent(err)
+			}
+			if len(databases) == 0 {
+				startup.setReady(handler)
+				return nil
+			}
+
+			named := make(map[string]http.Handler, len(databases))
+			for name, namedDB := range databases {
+				named[name], err = NewDbExplorer(namedDB, config.databaseOptions(name, databaseReplicas[name])...)
+				if err != nil {
+					return permanent(fmt.Errorf("database %s: %w", name, err))
+				}
+			}
+			startup.setReady(NewMultiDbExplorer(handler, named))
+			return nil
+		})
+		if err == nil {
+			log.Println("database connected, schema loaded")
+		} else if ctx.Err() == nil {
+			startupErr <- err
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case err := <-startupErr:
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	case <-ctx.Done():
+	}
+
+	// Новые соединения больше не принимаются, начатые запросы дорабатывают до таймаута
+	log.Printf("shutting down, waiting up to %s for in-flight requests", config.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("shutdown: %v", err)
+	}
+	startup.Close()
+}
+
+// openDB создает пул соединений с настройками пула из конфига
+func openDB(config Config, dsn string) *sql.DB {
+	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
+	db.SetMaxOpenConns(config.Pool.MaxOpen)
+	db.SetMaxIdleConns(config.Pool.MaxIdle)
+	db.SetConnMaxLifetime(config.Pool.ConnMaxLifetime)
+	return db
+}
 
-	fmt.Println("starting server at :8082")
-	http.ListenAndServe(":8082", handler)
+// openReplicas открывает пулы реплик для чтения с теми же настройками пула
+func openReplicas(config Config, dsns []string) []*sql.DB {
+	replicas := make([]*sql.DB, 0, len(dsns))
+	for _, dsn := range dsns {
+		replicas = append(replicas, openDB(config, dsn))
+	}
+	return replicas
+}
+
+// closeAll закрывает пулы соединений
+func closeAll(dbs []*sql.DB) {
+	for _, db := range dbs {
+		db.Close()
+	}
 }
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 
 	"bytes"
@@ -14,6 +20,9 @@ import (
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/vmihailenco/msgpack/v5"
+
+	dbclient "db_explorer/client"
 )
 
 // CaseResponse
@@ -85,6 +94,46 @@ func CleanupTestApis(db *sql.DB) {
 	}
 }
 
+// newTestDB подключается к тестовой базе и создает items и users.
+// После теста таблицы удаляются, а подключение закрывается
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("mysql", DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	PrepareTestApis(db)
+	t.Cleanup(func() { CleanupTestApis(db) })
+	return db
+}
+
+// serveTest запускает тестовый сервер и останавливает его вместе с фоновой работой handler после теста
+func serveTest(t *testing.T, handler http.Handler) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		ts.Close()
+		closeHandler(handler)
+	})
+	return ts
+}
+
+// newTestServer запускает DbExplorer с opts над тестовой базой из newTestDB
+func newTestServer(t *testing.T, opts ...Option) (*httptest.Server, *sql.DB) {
+	t.Helper()
+	db := newTestDB(t)
+	handler, err := NewDbExplorer(db, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveTest(t, handler), db
+}
+
 func TestApis(t *testing.T) {
 	db, err := sql.Open("mysql", DSN)
 	err = db.Ping()
@@ -119,6 +168,7 @@ func TestApis(t *testing.T) {
 			Status: http.StatusNotFound,
 			Result: CR{
 				"error": "unknown table",
+				"code":  "unknown_table",
 			},
 		},
 		Case{
@@ -192,6 +242,7 @@ func TestApis(t *testing.T) {
 			Status: http.StatusNotFound,
 			Result: CR{
 				"error": "record not found",
+				"code":  "not_found",
 			},
 		},
 
@@ -319,6 +370,10 @@ func TestApis(t *testing.T) {
 			},
 			Result: CR{
 				"error": "field id have invalid type",
+				"code":  "validation_failed",
+				"details": []CR{
+					CR{"field": "id", "code": "read_only", "message": "field id have invalid type"},
+				},
 			},
 		},
 		Case{
@@ -330,6 +385,10 @@ func TestApis(t *testing.T) {
 			},
 			Result: CR{
 				"error": "field title have invalid type",
+				"code":  "validation_failed",
+				"details": []CR{
+					CR{"field": "title", "code": "invalid_type", "message": "field title have invalid type"},
+				},
 			},
 		},
 		// Устанавливать nil можно только для null-полей
@@ -342,6 +401,10 @@ func TestApis(t *testing.T) {
 			},
 			Result: CR{
 				"error": "field title have invalid type",
+				"code":  "validation_failed",
+				"details": []CR{
+					CR{"field": "title", "code": "invalid_type", "message": "field title have invalid type"},
+				},
 			},
 		},
 
@@ -354,6 +417,10 @@ func TestApis(t *testing.T) {
 			},
 			Result: CR{
 				"error": "field updated have invalid type",
+				"code":  "validation_failed",
+				"details": []CR{
+					CR{"field": "updated", "code": "invalid_type", "message": "field updated have invalid type"},
+				},
 			},
 		},
 
@@ -381,6 +448,7 @@ func TestApis(t *testing.T) {
 			Status: http.StatusNotFound,
 			Result: CR{
 				"error": "record not found", // Возвращается, если элемент не найден
+				"code":  "not_found",
 			},
 		},
 
@@ -439,6 +507,10 @@ func TestApis(t *testing.T) {
 			},
 			Result: CR{
 				"error": "field user_id have invalid type",
+				"code":  "validation_failed",
+				"details": []CR{
+					CR{"field": "user_id", "code": "read_only", "message": "field user_id have invalid type"},
+				},
 			},
 		},
 		// не забываем про sql-инъекции
@@ -505,70 +577,2739 @@ func TestApis(t *testing.T) {
 	runCases(t, ts, db, cases)
 }
 
-func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
-	for idx, item := range cases {
-		var (
-			err      error
-			result   interface{}
-			expected interface{}
-			req      *http.Request
-		)
+func TestAggregate(t *testing.T) {
+	ts, db := newTestServer(t)
 
-		caseName := fmt.Sprintf("case %d: [%s] %s %s", idx, item.Method, item.Path, item.Query)
+	cases := []Case{
+		Case{
+			Path:  "/items/_aggregate", // группировка, NULL идёт первым
+			Query: "group_by=updated&agg=count(*),max(id)",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"updated":  nil,
+							"count(*)": 1,
+							"max(id)":  2,
+						},
+						CR{
+							"updated":  "rvasily",
+							"count(*)": 1,
+							"max(id)":  1,
+						},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/items/_aggregate", // группы листаются, обрезанная страница помечается
+			Query: "group_by=updated&agg=count(*)&limit=1",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"updated": nil, "count(*)": 1},
+					},
+					"truncated": true,
+				},
+			},
+		},
+		Case{
+			Path:  "/items/_aggregate",
+			Query: "group_by=updated&agg=count(*)&limit=1&offset=1",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"updated": "rvasily", "count(*)": 1},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/items/_aggregate", // без группировки, с фильтром как у списка
+			Query: "agg=count(*)&title=memcache",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"count(*)": 1,
+						},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/items/", // тот же фильтр в списке записей
+			Query: "title=memcache",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":          2,
+							"title":       "memcache",
+							"description": "Рассказать про мемкеш с примером использования",
+							"updated":     nil,
+						},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/items/_aggregate",
+			Query:  "agg=max(password)",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown column password",
+				"code":  "bad_request",
+			},
+		},
+		Case{
+			Path:   "/items/_aggregate",
+			Query:  "agg=sleep(10)",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "invalid aggregate sleep(10)",
+				"code":  "bad_request",
+			},
+		},
+		Case{
+			Path:   "/items/_aggregate",
+			Query:  "group_by=id%60&agg=count(*)", // backtick в имени колонки
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown column id`",
+				"code":  "bad_request",
+			},
+		},
+	}
 
-		// если у вас случилась это ошибка - значит вы не делаете где-то rows.Close и у вас текут соединения с базой
-		// если такое случилось на первом тесте - значит вы не закрываете коннект где-то при иницаилизации в NewDbExplorer
-		if db.Stats().OpenConnections != 1 {
-			t.Fatalf("[%s] you have %d open connections, must be 1", caseName, db.Stats().OpenConnections)
-		}
+	runCases(t, ts, db, cases)
+}
 
-		if item.Method == "" || item.Method == http.MethodGet {
-			req, err = http.NewRequest(item.Method, ts.URL+item.Path+"?"+item.Query, nil)
-		} else {
-			data, err := json.Marshal(item.Body)
-			if err != nil {
-				panic(err)
-			}
-			reqBody := bytes.NewReader(data)
-			req, err = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
-			req.Header.Add("Content-Type", "application/json")
-		}
+func TestTotalCount(t *testing.T) {
+	ts, db := newTestServer(t)
 
-		resp, err := client.Do(req)
-		if err != nil {
-			t.Fatalf("[%s] request error: %v", caseName, err)
-			continue
-		}
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
+	cases := []Case{
+		Case{
+			Path:  "/items", // total считается по всей таблице, а не по странице
+			Query: "limit=1&count=exact",
+			Result: CR{
+				"response": CR{
+					"total": 2,
+					"records": []CR{
+						CR{
+							"id":          1,
+							"title":       "database/sql",
+							"description": "Рассказать про базы данных",
+							"updated":     "rvasily",
+						},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/items", // точный подсчет учитывает фильтры
+			Query: "limit=0&count=exact&title=memcache",
+			Result: CR{
+				"response": CR{
+					"total":   1,
+					"records": []CR{},
+				},
+			},
+		},
+		Case{
+			Path:   "/items",
+			Query:  "count=all",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "invalid count",
+				"code":  "bad_request",
+			},
+		},
+	}
 
-		// fmt.Printf("[%s] body: %s\n", caseName, string(body))
-		if item.Status == 0 {
-			item.Status = http.StatusOK
-		}
+	runCases(t, ts, db, cases)
+}
 
-		if resp.StatusCode != item.Status {
-			t.Fatalf("[%s] expected http status %v, got %v", caseName, item.Status, resp.StatusCode)
-			continue
-		}
+func TestReservedParams(t *testing.T) {
+	db := newTestDB(t)
 
-		err = json.Unmarshal(body, &result)
-		if err != nil {
-			t.Fatalf("[%s] cant unpack json: %v", caseName, err)
-			continue
+	for _, q := range []string{
+		"DROP TABLE IF EXISTS counters",
+		"CREATE TABLE counters (id int NOT NULL AUTO_INCREMENT, `limit` int NOT NULL, `count` int NOT NULL, PRIMARY KEY (id))",
+		"INSERT INTO counters (`limit`, `count`) VALUES (1, 10), (2, 20), (3, 30)",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
 		}
+	}
+	defer db.Exec("DROP TABLE IF EXISTS counters")
 
-		// reflect.DeepEqual не работает если нам приходят разные типы
-		// а там приходят разные типы (string VS interface{}) по сравнению с тем что в ожидаемом результате
-		// этот маленький грязный хак конвертит данные сначала в json, а потом обратно в interface - получаем совместимые результаты
-		// не используйте это в продакшен-коде - надо явно писать что ожидается интерфейс или использовать другой подход с точным форматом ответа
-		data, err := json.Marshal(item.Result)
-		json.Unmarshal(data, &expected)
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveTest(t, handler)
 
-		if !reflect.DeepEqual(result, expected) {
-			t.Fatalf("[%s] results not match\nGot : %#v\nWant: %#v", caseName, result, expected)
-			continue
-		}
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:  "/counters", // limit ограничивает выборку, а не фильтрует по колонке limit
+			Query: "limit=2",
+			Result: CR{"response": CR{"records": []CR{
+				CR{"id": 1, "limit": 1, "count": 10},
+				CR{"id": 2, "limit": 2, "count": 20},
+			}}},
+		},
+		Case{
+			Path:  "/counters", // count включает подсчет, id остается фильтром
+			Query: "count=exact&id=3",
+			Result: CR{"response": CR{"total": 1, "records": []CR{
+				CR{"id": 3, "limit": 3, "count": 30},
+			}}},
+		},
+	})
+}
+
+func TestGraphQL(t *testing.T) {
+	db := newTestDB(t)
+
+	// таблица с внешним ключом для проверки вложенных полей, удаляется раньше items
+	_, err := db.Exec(`CREATE TABLE comments (
+  comment_id int(11) NOT NULL AUTO_INCREMENT,
+  item_id int(11) NOT NULL,
+  body varchar(255) NOT NULL,
+  PRIMARY KEY (comment_id),
+  FOREIGN KEY (item_id) REFERENCES items (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS comments;`)
+	_, err = db.Exec(`INSERT INTO comments (comment_id, item_id, body) VALUES (1, 1, 'first'), (2, 1, 'second'), (3, 2, 'third');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
 	}
 
+	ts := serveTest(t, handler)
+
+	cases := []Case{
+		Case{
+			Path:   "/graphql",
+			Method: http.MethodPost,
+			Body: CR{
+				"query": `{ items(limit: 1, order_by: "id", desc: true) { id title } }`,
+			},
+			Result: CR{
+				"data": CR{
+					"items": []CR{
+						CR{"id": 2, "title": "memcache"},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/graphql",
+			Method: http.MethodPost,
+			Body: CR{
+				"query": `{ items(filter: {updated: "rvasily"}) { id } }`,
+			},
+			Result: CR{
+				"data": CR{
+					"items": []CR{
+						CR{"id": 1},
+					},
+				},
+			},
+		},
+		// вложенные поля по внешнему ключу в обе стороны
+		Case{
+			Path:   "/graphql",
+			Method: http.MethodPost,
+			Body: CR{
+				"query": `{ comments(order_by: "comment_id") { body item { title } } }`,
+			},
+			Result: CR{
+				"data": CR{
+					"comments": []CR{
+						CR{"body": "first", "item": CR{"title": "database/sql"}},
+						CR{"body": "second", "item": CR{"title": "database/sql"}},
+						CR{"body": "third", "item": CR{"title": "memcache"}},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/graphql",
+			Method: http.MethodPost,
+			Body: CR{
+				"query": `{ items(order_by: "id") { id comments_by_item_id { comment_id } } }`,
+			},
+			Result: CR{
+				"data": CR{
+					"items": []CR{
+						CR{"id": 1, "comments_by_item_id": []CR{CR{"comment_id": 1}, CR{"comment_id": 2}}},
+						CR{"id": 2, "comments_by_item_id": []CR{CR{"comment_id": 3}}},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/graphql",
+			Method: http.MethodPost,
+			Body: CR{
+				"query": `mutation { create_items(input: {title: "graphql", description: "schema"}) { id title updated } }`,
+			},
+			Result: CR{
+				"data": CR{
+					"create_items": CR{"id": 3, "title": "graphql", "updated": nil},
+				},
+			},
+		},
+		Case{
+			Path:   "/graphql",
+			Method: http.MethodPost,
+			Body: CR{
+				"query":     `mutation($id: String!) { update_items(id: $id, input: {updated: "now"}) }`,
+				"variables": CR{"id": "3"},
+			},
+			Result: CR{
+				"data": CR{
+					"update_items": 1,
+				},
+			},
+		},
+		Case{
+			Path:   "/graphql",
+			Method: http.MethodPost,
+			Body: CR{
+				"query": `{ items_by_pk(id: "3") { updated } }`,
+			},
+			Result: CR{
+				"data": CR{
+					"items_by_pk": CR{"updated": "now"},
+				},
+			},
+		},
+		Case{
+			Path:   "/graphql",
+			Method: http.MethodPost,
+			Body: CR{
+				"query": `mutation { delete_items(id: "3") }`,
+			},
+			Result: CR{
+				"data": CR{
+					"delete_items": 1,
+				},
+			},
+		},
+		Case{
+			Path:   "/graphql",
+			Method: http.MethodPost,
+			Body: CR{
+				"query": `{ items_by_pk(id: "3") { id } }`,
+			},
+			Result: CR{
+				"data": CR{
+					"items_by_pk": nil,
+				},
+			},
+		},
+		// явный null в limit и offset равен значениям по умолчанию
+		Case{
+			Path:   "/graphql",
+			Method: http.MethodPost,
+			Body: CR{
+				"query":     `query($limit: Int, $offset: Int) { items(limit: $limit, offset: $offset, order_by: "id") { id } }`,
+				"variables": CR{"limit": nil, "offset": nil},
+			},
+			Result: CR{
+				"data": CR{
+					"items": []CR{
+						CR{"id": 1},
+						CR{"id": 2},
+					},
+				},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	// таблица graphql перекрывалась бы эндпоинтом POST /graphql, поэтому пропускается
+	_, err = db.Exec("CREATE TABLE graphql (id int NOT NULL AUTO_INCREMENT, PRIMARY KEY (id))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DROP TABLE IF EXISTS graphql")
+	handler, err = NewDbExplorer(db)
+	if err != nil {
+		t.Fatalf("graphql table must be skipped, got %v", err)
+	}
+	explorer := handler.(*metricsMiddleware).explorer
+	if explorer.tableExists("graphql") || len(explorer.tables) != 3 {
+		t.Errorf("expected graphql table to be skipped, got %v", explorer.tables)
+	}
+	if err := explorer.loadSchema(); err != nil {
+		t.Errorf("schema refresh with graphql table: %v", err)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := db.Exec(`CREATE TABLE notes (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  title varchar(255) NOT NULL,
+  deleted_at datetime DEFAULT NULL,
+  PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS notes;`)
+	_, err = db.Exec(`INSERT INTO notes (id, title) VALUES (1, 'first'), (2, 'second');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// колонка должна допускать NULL
+	if _, err := NewDbExplorer(db, WithSoftDelete("notes", "title")); err == nil {
+		t.Fatalf("expected error for NOT NULL soft delete column")
+	}
+
+	handler, err := NewDbExplorer(db, WithSoftDelete("notes", "deleted_at"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := serveTest(t, handler)
+
+	cases := []Case{
+		Case{
+			Path:   "/notes/1",
+			Method: http.MethodDelete,
+			Result: CR{
+				"response": CR{
+					"deleted": 1,
+				},
+			},
+		},
+		Case{
+			Path:   "/notes/1", // повторное удаление уже помеченной строки
+			Method: http.MethodDelete,
+			Result: CR{
+				"response": CR{
+					"deleted": 0,
+				},
+			},
+		},
+		Case{
+			Path:   "/notes/1",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "record not found",
+				"code":  "not_found",
+			},
+		},
+		Case{
+			Path:  "/notes",
+			Query: "count=exact",
+			Result: CR{
+				"response": CR{
+					"total": 1,
+					"records": []CR{
+						CR{"id": 2, "title": "second", "deleted_at": nil},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/notes", // строка физически осталась в таблице
+			Query: "count=exact&limit=0&include_deleted=1",
+			Result: CR{
+				"response": CR{
+					"total":   2,
+					"records": []CR{},
+				},
+			},
+		},
+		Case{
+			Path:   "/notes/1/_restore",
+			Method: http.MethodPost,
+			Result: CR{
+				"response": CR{
+					"restored": 1,
+				},
+			},
+		},
+		Case{
+			Path: "/notes/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{"id": 1, "title": "first", "deleted_at": nil},
+				},
+			},
+		},
+		Case{
+			Path:   "/items/1/_restore",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "soft delete is not enabled",
+				"code":  "bad_request",
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestReplicaRouting(t *testing.T) {
+	// sql.Open не подключается к базе, для проверки выбора подключения этого достаточно
+	primary, _ := sql.Open("mysql", DSN)
+	first, _ := sql.Open("mysql", DSN)
+	second, _ := sql.Open("mysql", DSN)
+	defer primary.Close()
+	defer first.Close()
+	defer second.Close()
+
+	router := newDbRouter(primary, []*sql.DB{first, second}, 50*time.Millisecond)
+	alice := context.WithValue(context.Background(), clientKeyCtx{}, "alice")
+	bob := context.WithValue(context.Background(), clientKeyCtx{}, "bob")
+
+	// чтение по кругу
+	got := []*sql.DB{router.reader(alice), router.reader(alice), router.reader(alice)}
+	if got[0] != first || got[1] != second || got[2] != first {
+		t.Fatalf("replicas must be used in round-robin")
+	}
+
+	// после записи клиент читает из primary, другие клиенты - из реплик
+	if router.writer(alice) != primary {
+		t.Fatalf("writes must go to primary")
+	}
+	if router.reader(alice) != primary {
+		t.Fatalf("read after write must stick to primary")
+	}
+	if router.reader(bob) == primary {
+		t.Fatalf("other clients must read from replicas")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if router.reader(alice) == primary {
+		t.Fatalf("sticky window must expire")
+	}
+
+	// нездоровые реплики пропускаются, без здоровых реплик чтение идет в primary
+	router.replicas[0].healthy.Store(false)
+	for i := 0; i < 3; i++ {
+		if router.reader(bob) != second {
+			t.Fatalf("unhealthy replica must be skipped")
+		}
+	}
+	router.replicas[0].healthy.Store(true)
+	if router.reader(context.WithValue(bob, primaryReadCtx{}, true)) != primary {
+		t.Fatalf("forced primary read must go to primary")
+	}
+	router.replicas[1].healthy.Store(false)
+	router.replicas[0].healthy.Store(false)
+	if router.reader(bob) != primary {
+		t.Fatalf("without healthy replicas reads must go to primary")
+	}
+
+	// клиент тот же, что у лимитов частоты: за одним IP разные API-ключи - разные клиенты
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+	if key := clientKey(req); key != "ip:192.0.2.1" {
+		t.Fatalf("unexpected client key %q", key)
+	}
+	req.Header.Set("X-API-Key", "alice")
+	if key := clientKey(req); key != "key:alice" {
+		t.Fatalf("unexpected client key %q", key)
+	}
+
+	// close останавливает проверку реплик
+	done := make(chan struct{})
+	go func() {
+		router.watch(time.Millisecond)
+		close(done)
+	}()
+	router.close()
+	router.close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("watch must stop after close")
+	}
+}
+
+func TestQueryLimits(t *testing.T) {
+	db := newTestDB(t)
+
+	handler, err := NewDbExplorer(db, WithMaxLimit(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveTest(t, handler)
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:  "/items", // limit больше максимального уменьшается до него
+			Query: "limit=1000000",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":          1,
+							"title":       "database/sql",
+							"description": "Рассказать про базы данных",
+							"updated":     "rvasily",
+						},
+					},
+				},
+			},
+		},
+	})
+
+	// таймаут меньше любого запроса
+	handler, err = NewDbExplorer(db, WithQueryTimeout(time.Nanosecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts = serveTest(t, handler)
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/items",
+			Status: http.StatusGatewayTimeout,
+			Result: CR{
+				"error": "query timeout",
+				"code":  "query_timeout",
+			},
+		},
+		Case{
+			Path:   "/items/1",
+			Method: http.MethodDelete,
+			Status: http.StatusGatewayTimeout,
+			Result: CR{
+				"error": "query timeout",
+				"code":  "query_timeout",
+			},
+		},
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	db := newTestDB(t)
+
+	// бюджет почти не восстанавливается за время теста
+	handler, err := NewDbExplorer(db,
+		WithRateLimit(RateLimit{Rate: 0.01, Burst: 2}, RateLimit{Rate: 0.01, Burst: 1}),
+		WithTableRateLimit("users", RateLimit{Rate: 0.01, Burst: 1}, RateLimit{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveTest(t, handler)
+
+	limited := CR{
+		"error": "rate limit exceeded",
+		"code":  "rate_limited",
+	}
+	cases := []Case{
+		Case{Path: "/items", Query: "limit=0", Result: CR{"response": CR{"records": []CR{}}}},
+		Case{Path: "/items", Query: "limit=0", Result: CR{"response": CR{"records": []CR{}}}},
+		Case{Path: "/items", Query: "limit=0", Status: http.StatusTooManyRequests, Result: limited},
+		// запись считается отдельно от чтения
+		Case{
+			Path:   "/items/1",
+			Method: http.MethodPost,
+			Body:   CR{"updated": "limits"},
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		Case{
+			Path:   "/items/1",
+			Method: http.MethodPost,
+			Body:   CR{"updated": "limits"},
+			Status: http.StatusTooManyRequests,
+			Result: limited,
+		},
+		// у users свой лимит на чтение и нет лимита на запись
+		Case{Path: "/users", Query: "limit=0", Result: CR{"response": CR{"records": []CR{}}}},
+		Case{Path: "/users", Query: "limit=0", Status: http.StatusTooManyRequests, Result: limited},
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodPost,
+			Body:   CR{"info": "limits"},
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodPost,
+			Body:   CR{"info": "no write limit"},
+			Result: CR{"response": CR{"updated": 1}},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	// у другого API-ключа свой бюджет
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/items?limit=0", nil)
+	req.Header.Set("X-API-Key", "other")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-RateLimit-Remaining") != "1" {
+		t.Fatalf("expected separate budget for api key, got %d remaining %q", resp.StatusCode, resp.Header.Get("X-RateLimit-Remaining"))
+	}
+
+	// после исчерпания бюджета отдается Retry-After
+	req, _ = http.NewRequest(http.MethodGet, ts.URL+"/items?limit=0", nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ts, db := newTestServer(t)
+
+	runCases(t, ts, db, []Case{
+		Case{Path: "/items", Query: "limit=0", Result: CR{"response": CR{"records": []CR{}}}},
+		Case{Path: "/unknown_table", Status: http.StatusNotFound, Result: CR{"error": "unknown table", "code": "unknown_table"}},
+		Case{
+			Path:   "/items/1",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body:   CR{"title": 42},
+			Result: CR{
+				"error":   "field title have invalid type",
+				"code":    "validation_failed",
+				"details": []CR{CR{"field": "title", "code": "invalid_type", "message": "field title have invalid type"}},
+			},
+		},
+	})
+
+	// произвольный метод не создает отдельную метку
+	req, _ := http.NewRequest("BREW", ts.URL+"/items", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+
+	// таблица с именем служебного маршрута не попадает в схему, а не перекрывается молча
+	for _, name := range []string{"metrics", "healthz", "readyz", "_ui"} {
+		if _, err := db.Exec("CREATE TABLE `" + name + "` (id int NOT NULL AUTO_INCREMENT, PRIMARY KEY (id))"); err != nil {
+			t.Fatal(err)
+		}
+		defer db.Exec("DROP TABLE IF EXISTS `" + name + "`")
+	}
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tables := handler.(*metricsMiddleware).explorer.tables; len(tables) != 2 {
+		t.Errorf("expected tables named after service routes to be skipped, got %v", tables)
+	}
+
+	resp, err = client.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	expected := []string{
+		`db_explorer_http_requests_total{table="items",method="other",status="404"} 1`,
+		`db_explorer_http_requests_total{table="items",method="GET",status="200"} 1`,
+		`db_explorer_http_requests_total{table="",method="GET",status="404"} 1`,
+		`db_explorer_http_requests_total{table="items",method="POST",status="400"} 1`,
+		`db_explorer_http_request_duration_seconds_count{table="items",method="GET",status="200"} 1`,
+		`db_explorer_query_duration_seconds_count{operation="select"} 1`,
+		`db_explorer_validation_failures_total{table="items"} 1`,
+		`db_explorer_db_open_connections{db="primary"} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Fatalf("metrics must contain %q, got:\n%s", line, body)
+		}
+	}
+	if strings.Contains(string(body), "BREW") {
+		t.Fatalf("arbitrary methods must not become labels:\n%s", body)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := db.Exec(`CREATE TABLE accounts (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  login varchar(255) NOT NULL,
+  age int(11) DEFAULT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY login (login)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS accounts;`)
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveTest(t, handler)
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/accounts/",
+			Method: http.MethodPut,
+			Body:   CR{"login": "rvasily"},
+			Result: CR{"response": CR{"id": 1}},
+		},
+		// нарушение уникального ключа
+		Case{
+			Path:   "/accounts/",
+			Method: http.MethodPut,
+			Status: http.StatusConflict,
+			Body:   CR{"login": "rvasily"},
+			Result: CR{
+				"error": "duplicate key",
+				"code":  "conflict",
+			},
+		},
+		// в details попадают все невалидные поля
+		Case{
+			Path:   "/accounts/1",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body:   CR{"id": 2, "login": 42, "age": "old"},
+			Result: CR{
+				"error": "field age have invalid type; field id have invalid type; field login have invalid type",
+				"code":  "validation_failed",
+				"details": []CR{
+					CR{"field": "age", "code": "invalid_type", "message": "field age have invalid type"},
+					CR{"field": "id", "code": "read_only", "message": "field id have invalid type"},
+					CR{"field": "login", "code": "invalid_type", "message": "field login have invalid type"},
+				},
+			},
+		},
+		Case{
+			Path:   "/accounts/1/2/3",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown method",
+				"code":  "unknown_route",
+			},
+		},
+	})
+
+	// ошибки отдаются как JSON, а не text/plain
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/accounts/", strings.NewReader("{not json"))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("expected 400 application/json, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), `"code":"bad_request"`) {
+		t.Fatalf("expected bad_request code, got %s", body)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/config.yaml"
+	err := os.WriteFile(path, []byte(`
+dsn: "root:love@tcp(db:3306)/photolist"
+listen: ":9000"
+tables:
+  deny: [users]
+limits:
+  default: 10
+  max: 100
+pool:
+  max_open: 20
+  conn_max_lifetime: 5m
+cache:
+  ttl: 30s
+databases:
+  archive:
+    dsn: "root:love@tcp(db:3306)/archive"
+    tables:
+      allow: [notes]
+    admin_token: secret
+    soft_delete:
+      notes: removed_at
+    replicas:
+      dsns: ["root:love@tcp(archive-replica:3306)/archive"]
+soft_delete:
+  items: removed_at
+replicas:
+  dsns: ["root:love@tcp(replica1:3306)/photolist", "root:love@tcp(replica2:3306)/photolist"]
+rate_limit:
+  read: {rate: 10, burst: 20}
+  tables:
+    users:
+      read: {rate: 2, burst: 5}
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"DB_EXPLORER_CONFIG":                  path,
+		"DB_EXPLORER_MAX_LIMIT":               "50",
+		"DB_EXPLORER_LISTEN":                  ":9001",
+		"DB_EXPLORER_SOFT_DELETE":             "users=deleted_at, items = updated",
+		"DB_EXPLORER_STICKY_WINDOW":           "3s",
+		"DB_EXPLORER_QUERY_TIMEOUT":           "4s",
+		"DB_EXPLORER_RATE_LIMIT_TABLES_WRITE": "users=0.5/1",
+	}
+	getenv := func(name string) string { return env[name] }
+
+	// флаги важнее окружения, окружение важнее файла
+	config, err := loadConfig([]string{"-listen", ":9002", "-replica-health-check", "10s", "-rate-limit-write", "1/5"}, getenv, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.DSN != "root:love@tcp(db:3306)/photolist" || config.Listen != ":9002" ||
+		config.Limits.Default != 10 || config.Limits.Max != 50 ||
+		config.Pool.MaxOpen != 20 || config.Pool.ConnMaxLifetime != 5*time.Minute ||
+		config.Cache.TTL != 30*time.Second || config.Cache.MaxEntries != 10000 ||
+		config.Blobs.MaxSize != 16<<20 ||
+		config.Databases["archive"].DSN != "root:love@tcp(db:3306)/archive" || config.Databases["archive"].AdminToken != "secret" ||
+		!reflect.DeepEqual(config.Databases["archive"].Tables.Allow, []string{"notes"}) ||
+		!reflect.DeepEqual(config.Tables.Deny, []string{"users"}) ||
+		!reflect.DeepEqual(config.SoftDelete, map[string]string{"users": "deleted_at", "items": "updated"}) ||
+		!reflect.DeepEqual(config.Databases["archive"].SoftDelete, map[string]string{"notes": "removed_at"}) ||
+		len(config.Replicas.DSNs) != 2 || len(config.Databases["archive"].Replicas.DSNs) != 1 ||
+		config.Replicas.StickyWindow != 3*time.Second || config.Replicas.HealthCheck != 10*time.Second ||
+		config.QueryTimeout != 4*time.Second ||
+		config.RateLimit.Read != (RateLimit{Rate: 10, Burst: 20}) || config.RateLimit.Write != (RateLimit{Rate: 1, Burst: 5}) ||
+		config.RateLimit.Tables["users"] != (TableRateLimitConfig{Read: RateLimit{Rate: 2, Burst: 5}, Write: RateLimit{Rate: 0.5, Burst: 1}}) {
+		t.Fatalf("unexpected config: %+v", config)
+	}
+
+	// опции переносят настройки в explorer
+	replica, err := sql.Open("mysql", config.Replicas.DSNs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	explorer := &DbExplorer{softDelete: make(map[string]string), rateLimiter: newRateLimiter()}
+	for _, option := range config.options([]*sql.DB{replica}) {
+		option(explorer)
+	}
+	if !reflect.DeepEqual(explorer.softDelete, config.SoftDelete) ||
+		len(explorer.replicas) != 1 || explorer.stickyWindow != 3*time.Second || explorer.healthInterval != 10*time.Second ||
+		explorer.queryTimeout != 4*time.Second ||
+		*explorer.rateLimiter.defaults != (tableRateLimits{read: RateLimit{Rate: 10, Burst: 20}, write: RateLimit{Rate: 1, Burst: 5}}) ||
+		explorer.rateLimiter.tables["users"] != (tableRateLimits{read: RateLimit{Rate: 2, Burst: 5}, write: RateLimit{Rate: 0.5, Burst: 1}}) {
+		t.Fatalf("unexpected explorer settings: %+v", explorer)
+	}
+
+	// все ошибки сообщаются разом
+	env["DB_EXPLORER_DEFAULT_LIMIT"] = "many"
+	env["DB_EXPLORER_SOFT_DELETE"] = "users"
+	env["DB_EXPLORER_RATE_LIMIT_READ"] = "10"
+	_, err = loadConfig([]string{"-max-limit", "-1", "-tls-cert", "cert.pem", "-tables-allow", "users", "-cache-max-entries", "0", "-procedures-allow", "report", "-procedures-deny", "report", "-blob-max-size", "0", "-sticky-window", "-1s", "-replicas", "bad", "-query-timeout", "-1s", "-rate-limit-write", "NaN/1"}, getenv, ioutil.Discard)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	for _, expected := range []string{
+		"DB_EXPLORER_DEFAULT_LIMIT",
+		`DB_EXPLORER_SOFT_DELETE: "users" is not key=value`,
+		"limits.max: must not be negative",
+		"tls: cert and key must be set together",
+		"tables: users is both allowed and denied",
+		"cache.max_entries: must be positive",
+		"procedures: report is both allowed and denied",
+		"blobs.max_size: must be positive",
+		"replicas.sticky_window: must not be negative",
+		"replicas.dsns[0]: invalid DSN",
+		"query_timeout: must not be negative",
+		`DB_EXPLORER_RATE_LIMIT_READ: "10" is not rate/burst`,
+		"rate_limit.write.rate: must be a finite non-negative number",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in error:\n%v", expected, err)
+		}
+	}
+
+	// ошибки именованных баз называют базу
+	os.WriteFile(path, []byte("databases:\n  bad-name:\n    dsn: \"root@tcp(db:3306)/x\"\n  archive:\n    dsn: \"\"\n    tables:\n      allow: [a]\n      deny: [a]\n    queries:\n      report:\n        sql: \"UPDATE items SET title = ''\"\n    soft_delete:\n      notes: \"deleted at\"\n    replicas:\n      sticky_window: 1s\n    rate_limit:\n      read: {rate: 1, burst: 1}\n"), 0600)
+	_, err = loadConfig(nil, getenv, ioutil.Discard)
+	for _, expected := range []string{
+		`databases: invalid name "bad-name"`,
+		"databases.archive.dsn: must not be empty",
+		"databases.archive.tables: a is both allowed and denied",
+		"databases.archive.queries.report.sql: must be a SELECT",
+		`databases.archive.soft_delete.notes: invalid column "deleted at"`,
+		"databases.archive.replicas: set replicas.sticky_window and replicas.health_check instead",
+		"databases.archive.rate_limit: set rate_limit.read and rate_limit.write instead",
+	} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in error:\n%v", expected, err)
+		}
+	}
+
+	// опечатка в ключе файла - ошибка
+	os.WriteFile(path, []byte("limit:\n  max: 10\n"), 0600)
+	if _, err = loadConfig(nil, getenv, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "limit") {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestTablesFilter(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, err := NewDbExplorer(db, WithTables([]string{"unknown"}, nil)); err == nil {
+		t.Fatalf("expected error for unknown allowed table")
+	}
+
+	handler, err := NewDbExplorer(db, WithTables(nil, []string{"users"}), WithDefaultLimit(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveTest(t, handler)
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path: "/",
+			Result: CR{
+				"response": CR{
+					"tables": []string{"items"},
+				},
+			},
+		},
+		Case{
+			Path:   "/users",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown table",
+				"code":  "unknown_table",
+			},
+		},
+		Case{
+			Path: "/items", // limit по умолчанию из WithDefaultLimit
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":          1,
+							"title":       "database/sql",
+							"description": "Рассказать про базы данных",
+							"updated":     "rvasily",
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+func TestHealth(t *testing.T) {
+	db := newTestDB(t)
+
+	// пока схема не загружена, процесс жив, но не готов
+	startup := &startupHandler{}
+	ts := serveTest(t, startup)
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/healthz",
+			Result: CR{"status": "ok"},
+		},
+		Case{
+			Path:   "/readyz",
+			Status: http.StatusServiceUnavailable,
+			Result: CR{
+				"error": "schema not loaded",
+				"code":  "unavailable",
+			},
+		},
+		Case{
+			Path:   "/items",
+			Status: http.StatusServiceUnavailable,
+			Result: CR{
+				"error": "schema not loaded",
+				"code":  "unavailable",
+			},
+		},
+	})
+
+	attempts := 0
+	err := retryWithBackoff(context.Background(), time.Millisecond, 2*time.Millisecond, func() error {
+		attempts++
+		if attempts < 3 {
+			return fmt.Errorf("connection refused")
+		}
+		handler, err := NewDbExplorer(db)
+		if err != nil {
+			return err
+		}
+		startup.setReady(handler)
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("expected success on third attempt, got %v after %d attempts", err, attempts)
+	}
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/healthz",
+			Result: CR{"status": "ok"},
+		},
+		Case{
+			Path:   "/readyz",
+			Result: CR{"status": "ready"},
+		},
+	})
+
+	// ошибка настройки не повторяется
+	attempts = 0
+	_, configErr := NewDbExplorer(db, WithTables([]string{"unknown"}, nil))
+	err = retryWithBackoff(context.Background(), time.Hour, time.Hour, func() error {
+		attempts++
+		return permanent(configErr)
+	})
+	if err != configErr || attempts != 1 {
+		t.Fatalf("expected configuration error after one attempt, got %v after %d attempts", err, attempts)
+	}
+
+	// отмена контекста прерывает ожидание следующей попытки
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = retryWithBackoff(ctx, time.Hour, time.Hour, func() error { return fmt.Errorf("connection refused") })
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := db.Exec(`CREATE TABLE profiles (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  login varchar(255) NOT NULL DEFAULT 'guest',
+  age int(11) DEFAULT NULL,
+  rating double NOT NULL DEFAULT 0,
+  PRIMARY KEY (id),
+  UNIQUE KEY login (login),
+  KEY age_rating (age, rating)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS profiles;`)
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveTest(t, handler)
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/unknown/_schema",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown table",
+				"code":  "unknown_table",
+			},
+		},
+	})
+
+	resp, err := client.Get(ts.URL + "/profiles/_schema")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Response struct {
+			Table      string         `json:"table"`
+			PrimaryKey string         `json:"primary_key"`
+			Columns    []schemaColumn `json:"columns"`
+			Indexes    []Index        `json:"indexes"`
+		} `json:"response"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("cant unpack json: %v", err)
+	}
+
+	// sql_type зависит от версии сервера (int или int(11)), поэтому сравниваем без него
+	guest, zero := "guest", "0"
+	expectedColumns := []schemaColumn{
+		{Name: "id", Type: "integer", Key: "primary", AutoIncrement: true},
+		{Name: "login", Type: "string", Default: &guest, Key: "unique"},
+		{Name: "age", Type: "integer", Nullable: true, Key: "index"},
+		{Name: "rating", Type: "float", Default: &zero},
+	}
+	for i := range result.Response.Columns {
+		result.Response.Columns[i].SQLType = ""
+	}
+	if result.Response.Table != "profiles" || result.Response.PrimaryKey != "id" ||
+		!reflect.DeepEqual(result.Response.Columns, expectedColumns) {
+		t.Fatalf("unexpected schema: %+v", result.Response)
+	}
+
+	expectedIndexes := map[string]Index{
+		"PRIMARY":    {Name: "PRIMARY", Columns: []string{"id"}, Unique: true},
+		"login":      {Name: "login", Columns: []string{"login"}, Unique: true},
+		"age_rating": {Name: "age_rating", Columns: []string{"age", "rating"}},
+	}
+	if len(result.Response.Indexes) != len(expectedIndexes) {
+		t.Fatalf("unexpected indexes: %+v", result.Response.Indexes)
+	}
+	for _, index := range result.Response.Indexes {
+		if !reflect.DeepEqual(index, expectedIndexes[index.Name]) {
+			t.Fatalf("unexpected index: %+v", index)
+		}
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Exec(`DROP TABLE IF EXISTS books;`)
+
+	// без токена эндпоинты отключены
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveTest(t, handler)
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/_admin/books",
+			Method: http.MethodPut,
+			Status: http.StatusNotFound,
+			Body:   CR{},
+			Result: CR{
+				"error": "unknown method",
+				"code":  "unknown_route",
+			},
+		},
+	})
+
+	handler, err = NewDbExplorer(db, WithAdminToken("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts = serveTest(t, handler)
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/_admin/books",
+			Method: http.MethodPut,
+			Status: http.StatusUnauthorized,
+			Body:   CR{},
+			Result: CR{
+				"error": "admin token required",
+				"code":  "unauthorized",
+			},
+		},
+	})
+
+	admin := serveTest(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", "Bearer secret")
+		handler.ServeHTTP(w, r)
+	}))
+	books := CR{
+		"primary_key": "id",
+		"columns": []CR{
+			CR{"name": "id", "type": "int", "auto_increment": true},
+			CR{"name": "title", "type": "varchar", "length": 255, "default": "it's new"},
+		},
+	}
+
+	runCases(t, admin, db, []Case{
+		Case{
+			Path:   "/_admin/books?dry_run=true",
+			Method: http.MethodPut,
+			Body:   books,
+			Result: CR{
+				"response": CR{
+					"ddl":     "CREATE TABLE `books` (`id` int NOT NULL AUTO_INCREMENT, `title` varchar(255) NOT NULL DEFAULT 'it''s new', PRIMARY KEY (`id`))",
+					"applied": false,
+				},
+			},
+		},
+		Case{
+			Path:   "/books",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown table",
+				"code":  "unknown_table",
+			},
+		},
+		// ошибки описания проверяются до выполнения
+		Case{
+			Path:   "/_admin/books",
+			Method: http.MethodPut,
+			Status: http.StatusBadRequest,
+			Body: CR{
+				"primary_key": "id",
+				"columns": []CR{
+					CR{"name": "id", "type": "text", "auto_increment": true},
+					CR{"name": "title`", "type": "varchar"},
+				},
+			},
+			Result: CR{
+				"error": "field columns[0].auto_increment: only integer columns can be auto_increment; " +
+					"field columns[1].length: required for varchar; field columns[1].name: invalid name",
+				"code": "validation_failed",
+				"details": []CR{
+					CR{"field": "columns[0].auto_increment", "code": "invalid_value", "message": "field columns[0].auto_increment: only integer columns can be auto_increment"},
+					CR{"field": "columns[1].length", "code": "required", "message": "field columns[1].length: required for varchar"},
+					CR{"field": "columns[1].name", "code": "invalid_value", "message": "field columns[1].name: invalid name"},
+				},
+			},
+		},
+		Case{
+			Path:   "/_admin/books",
+			Method: http.MethodPut,
+			Body:   books,
+			Result: CR{
+				"response": CR{
+					"ddl":     "CREATE TABLE `books` (`id` int NOT NULL AUTO_INCREMENT, `title` varchar(255) NOT NULL DEFAULT 'it''s new', PRIMARY KEY (`id`))",
+					"applied": true,
+				},
+			},
+		},
+		Case{
+			Path:   "/_admin/books",
+			Method: http.MethodPut,
+			Status: http.StatusConflict,
+			Body:   books,
+			Result: CR{
+				"error": "table already exists",
+				"code":  "conflict",
+			},
+		},
+		Case{
+			Path:   "/_admin/graphql",
+			Method: http.MethodPut,
+			Status: http.StatusConflict,
+			Body:   books,
+			Result: CR{
+				"error": "table name is reserved for a service route",
+				"code":  "conflict",
+			},
+		},
+		Case{
+			Path:   "/_admin/books/columns",
+			Method: http.MethodPut,
+			Body:   CR{"name": "pages", "type": "int", "nullable": true},
+			Result: CR{
+				"response": CR{
+					"ddl":     "ALTER TABLE `books` ADD COLUMN `pages` int NULL",
+					"applied": true,
+				},
+			},
+		},
+		// кеш схемы обновился: новая таблица и колонка доступны без перезапуска
+		Case{
+			Path:   "/books/",
+			Method: http.MethodPut,
+			Body:   CR{"title": "Go", "pages": 300},
+			Result: CR{
+				"response": CR{"id": 1},
+			},
+		},
+		Case{
+			Path:   "/_admin/books/columns/pages",
+			Method: http.MethodPost,
+			Body:   CR{"name": "page_count", "type": "int", "default": "0"},
+			Result: CR{
+				"response": CR{
+					"ddl":     "ALTER TABLE `books` CHANGE COLUMN `pages` `page_count` int NOT NULL DEFAULT 0",
+					"applied": true,
+				},
+			},
+		},
+		Case{
+			Path: "/books/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{"id": 1, "title": "Go", "page_count": 300},
+				},
+			},
+		},
+		Case{
+			Path:   "/_admin/books/indexes",
+			Method: http.MethodPut,
+			Body:   CR{"name": "title", "columns": []string{"title"}, "unique": true},
+			Result: CR{
+				"response": CR{
+					"ddl":     "ALTER TABLE `books` ADD UNIQUE INDEX `title` (`title`)",
+					"applied": true,
+				},
+			},
+		},
+		Case{
+			Path:   "/_admin/books/indexes",
+			Method: http.MethodPut,
+			Status: http.StatusConflict,
+			Body:   CR{"name": "title", "columns": []string{"title"}},
+			Result: CR{
+				"error": "index already exists",
+				"code":  "conflict",
+			},
+		},
+		Case{
+			Path:   "/_admin/books/columns/id",
+			Method: http.MethodDelete,
+			Status: http.StatusConflict,
+			Result: CR{
+				"error": "primary key column cannot be dropped",
+				"code":  "conflict",
+			},
+		},
+		Case{
+			Path:   "/_admin/books/columns/page_count",
+			Method: http.MethodDelete,
+			Result: CR{
+				"response": CR{
+					"ddl":     "ALTER TABLE `books` DROP COLUMN `page_count`",
+					"applied": true,
+				},
+			},
+		},
+		Case{
+			Path: "/books/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{"id": 1, "title": "Go"},
+				},
+			},
+		},
+	})
+
+	// отказ по имени служебного маршрута приходит до DDL
+	var reserved string
+	if err := db.QueryRow("SHOW TABLES LIKE 'graphql'").Scan(&reserved); err != sql.ErrNoRows {
+		db.Exec("DROP TABLE IF EXISTS graphql")
+		t.Fatalf("table graphql must not be created, got %q %v", reserved, err)
+	}
+
+	// значение по умолчанию числовой колонки подставляется в DDL, поэтому только десятичные литералы
+	for value, valid := range map[string]bool{
+		"12": true, "-0.5": true, ".5": true, "1e3": true, "+2.": true,
+		"NaN": false, "Inf": false, "0x1p-2": false, "1_000": false, "1;DROP": false, "": false,
+	} {
+		_, problems := columnDDL(ColumnDefinition{Name: "price", Type: "decimal", Length: 10, Scale: 2, Default: &value}, "")
+		if (len(problems) == 0) != valid {
+			t.Errorf("default %q: expected valid=%v, got %v", value, valid, problems)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := "-- комментарий; не запрос\n" +
+		"INSERT INTO t VALUES ('a;b', \"c\\\";d\", 'it''s');\n" +
+		"# еще комментарий\n" +
+		"CREATE TABLE `x;y` (id int) /* ; */;\n" +
+		";\n" +
+		"SELECT 1--1"
+	expected := []string{
+		"INSERT INTO t VALUES ('a;b', \"c\\\";d\", 'it''s')",
+		"CREATE TABLE `x;y` (id int)",
+		"SELECT 1--1",
+	}
+	if got := splitStatements(script); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected statements:\n%#v", got)
+	}
+
+	// исполняемые комментарии остаются в запросе и раскрываются при определении его вида
+	got := splitStatements("/*!40101 SET NAMES utf8mb4 */;\nCREATE TABLE t (id int) /*!50100 ENGINE=InnoDB; */ /* c */;")
+	expected = []string{"/*!40101 SET NAMES utf8mb4 */", "CREATE TABLE t (id int) /*!50100 ENGINE=InnoDB; */"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected statements:\n%#v", got)
+	}
+	if kind, ok := restoreStatementKind(got[0]); kind != "set" || !ok {
+		t.Fatalf("unexpected kind %q of %q", kind, got[0])
+	}
+	if !isDDL(got[1]) || isDDL("/*!40101 SET @x = '/*!50100 DROP */' */") {
+		t.Fatalf("unexpected DDL detection")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	db, err := sql.Open("mysql", DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DROP TABLE IF EXISTS schema_migrations, mig_books;")
+
+	dir := t.TempDir()
+	getenv := func(string) string { return "" }
+	run := func(args ...string) (int, string) {
+		var out bytes.Buffer
+		code := runMigrate(append([]string{"-dir", dir, "-dsn", DSN, "-lock-timeout", "0s"}, args...), getenv, &out, &out)
+		return code, out.String()
+	}
+
+	if code, out := run("create", "create books"); code != 0 || !strings.Contains(out, "0001_create_books.up.sql") {
+		t.Fatalf("create failed: %d %s", code, out)
+	}
+	run("create", "seed_books")
+	run("create", "broken")
+	files := map[string]string{
+		"0001_create_books.up.sql":   "CREATE TABLE mig_books (id int NOT NULL, title varchar(255) NOT NULL, PRIMARY KEY (id));",
+		"0001_create_books.down.sql": "DROP TABLE mig_books;",
+		"0002_seed_books.up.sql":     "INSERT INTO mig_books VALUES (1, 'a;b');\nINSERT INTO mig_books VALUES (2, 'c');",
+		"0002_seed_books.down.sql":   "DELETE FROM mig_books;",
+		// вторая вставка нарушает первичный ключ, первая должна откатиться вместе с ней
+		"0003_broken.up.sql": "INSERT INTO mig_books VALUES (3, 'd');\nINSERT INTO mig_books VALUES (1, 'e');",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(dir+"/"+name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if code, out := run("up", "2"); code != 0 || !strings.Contains(out, "applied 0002_seed_books") {
+		t.Fatalf("up failed: %d %s", code, out)
+	}
+	if code, out := run("up"); code != 1 || !strings.Contains(out, "0003_broken: statement 2") {
+		t.Fatalf("expected broken migration to fail: %d %s", code, out)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM mig_books").Scan(&count)
+	if count != 2 {
+		t.Fatalf("failed migration must be rolled back, got %d rows", count)
+	}
+
+	// таблица учета миграций не видна через API
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	explorer := handler.(*metricsMiddleware).explorer
+	if explorer.tableExists(migrationsTable) || !explorer.tableExists("mig_books") {
+		t.Fatalf("expected %s to be hidden, got %v", migrationsTable, explorer.tables)
+	}
+
+	code, out := run("status")
+	if code != 0 || !regexp.MustCompile(`0002\s+seed_books\s+applied`).MatchString(out) ||
+		!regexp.MustCompile(`0003\s+broken\s+pending`).MatchString(out) {
+		t.Fatalf("unexpected status: %d\n%s", code, out)
+	}
+
+	// пока блокировку держит другой запуск, миграции не применяются
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.ExecContext(context.Background(), "SELECT GET_LOCK(?, 0)", migrationLock)
+	if code, out := run("status"); code != 1 || !strings.Contains(out, "another migration is running") {
+		t.Fatalf("expected lock error: %d %s", code, out)
+	}
+	conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", migrationLock)
+	conn.Close()
+
+	if code, out := run("down", "2"); code != 0 || !strings.Contains(out, "rolled back 0001_create_books") {
+		t.Fatalf("down failed: %d %s", code, out)
+	}
+	if _, err := db.Exec("SELECT 1 FROM mig_books"); err == nil {
+		t.Fatalf("table must be dropped by down migration")
+	}
+}
+
+// pausedWriter останавливает ответ на первом Flush, пока тест не закроет resume
+type pausedWriter struct {
+	*httptest.ResponseRecorder
+	flushed, resume chan struct{}
+	paused          bool
+}
+
+func (w *pausedWriter) Flush() {
+	if !w.paused {
+		w.paused = true
+		close(w.flushed)
+		<-w.resume
+	}
+	w.ResponseRecorder.Flush()
+}
+
+func TestDumpRestore(t *testing.T) {
+	db := newTestDB(t)
+
+	// 101 строка - два INSERT по dumpBatchSize
+	for i := 3; i <= 101; i++ {
+		db.Exec("INSERT INTO items (id, title, description) VALUES (?, ?, '')", i, fmt.Sprintf("it's %d; \\ item", i))
+	}
+
+	handler, err := NewDbExplorer(db, WithAdminToken("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveTest(t, handler)
+
+	request := func(method, path, body string, admin bool) (int, string) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if admin {
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	count := func() int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM items").Scan(&n)
+		return n
+	}
+
+	if status, _ := request(http.MethodGet, "/_dump?tables=items", "", false); status != http.StatusUnauthorized {
+		t.Fatalf("dump must require admin token, got %d", status)
+	}
+
+	// пока дамп отдается клиенту, кеш схемы не заблокирован: DDL не ждет конца выгрузки
+	writer := &pausedWriter{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan struct{}), resume: make(chan struct{})}
+	req := httptest.NewRequest(http.MethodGet, "/_dump?tables=items,users", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(writer, req)
+		close(done)
+	}()
+	<-writer.flushed
+	locked := make(chan struct{})
+	explorer := handler.(*metricsMiddleware).explorer
+	go func() {
+		explorer.schemaMu.Lock()
+		explorer.schemaMu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatalf("schema lock must not wait for the dump stream")
+	}
+	close(writer.resume)
+	<-done
+	if !strings.Contains(writer.Body.String(), "INSERT INTO `users`") {
+		t.Fatalf("expected full dump after resume, got:\n%s", writer.Body.String())
+	}
+
+	status, dump := request(http.MethodGet, "/_dump?tables=items", "", true)
+	if status != http.StatusOK || !strings.Contains(dump, "CREATE TABLE `items`") ||
+		strings.Count(dump, "INSERT INTO `items`") != 2 ||
+		!strings.Contains(dump, "(1, 'database/sql', 'Рассказать про базы данных', 'rvasily')") ||
+		!strings.Contains(dump, "(2, 'memcache', 'Рассказать про мемкеш с примером использования', NULL)") {
+		t.Fatalf("unexpected dump: %d\n%s", status, dump)
+	}
+	if status, body := request(http.MethodGet, "/_dump?tables=unknown", "", true); status != http.StatusNotFound {
+		t.Fatalf("expected unknown table, got %d %s", status, body)
+	}
+	_, dataDump := request(http.MethodGet, "/_dump?tables=items&schema=false", "", true)
+	if strings.Contains(dataDump, "CREATE TABLE") {
+		t.Fatalf("schema=false dump must not contain DDL")
+	}
+
+	if status, _ := request(http.MethodPost, "/_restore", dataDump, false); status != http.StatusUnauthorized {
+		t.Fatalf("restore must require admin token, got %d", status)
+	}
+	if status, body := request(http.MethodPost, "/_restore", "DELETE FROM items;", true); status != http.StatusBadRequest ||
+		!strings.Contains(body, "statement 1 is not allowed in restore: delete") {
+		t.Fatalf("expected not allowed statement, got %d %s", status, body)
+	}
+	// SET может менять только настройки сессии
+	for _, statement := range []string{
+		"SET GLOBAL max_connections = 1",
+		"SET @@global.max_connections = 1",
+		"SET NAMES utf8, PERSIST max_connections = 1",
+		"/*!80000 SET PERSIST_ONLY max_connections = 1 */",
+		"SET PASSWORD = 'x'",
+		"SET DEFAULT ROLE ALL TO root",
+	} {
+		if status, body := request(http.MethodPost, "/_restore", statement+";", true); status != http.StatusBadRequest ||
+			!strings.Contains(body, "statement 1 is not allowed in restore: set") {
+			t.Fatalf("%s: expected not allowed statement, got %d %s", statement, status, body)
+		}
+	}
+	for _, statement := range []string{"SET NAMES utf8mb4", "SET SESSION sql_mode = 'global'", "SET @@session.foreign_key_checks = 0, @role = 1", "SET @x = 'it''s global'"} {
+		if _, ok := restoreStatementKind(statement); !ok {
+			t.Fatalf("%s: session SET must be allowed", statement)
+		}
+	}
+
+	// данные без DDL восстанавливаются в одной транзакции: конфликт на второй пачке откатывает первую
+	db.Exec("DELETE FROM items WHERE id <= 100")
+	status, body := request(http.MethodPost, "/_restore", dataDump, true)
+	if status != http.StatusConflict || !strings.Contains(body, "duplicate key") || count() != 1 {
+		t.Fatalf("expected rolled back conflict, got %d %s, %d rows", status, body, count())
+	}
+
+	db.Exec("DELETE FROM items")
+	status, body = request(http.MethodPost, "/_restore", dataDump, true)
+	if status != http.StatusOK || body != `{"response":{"statements":5,"transactional":true}}`+"\n" || count() != 101 {
+		t.Fatalf("unexpected data restore: %d %s, %d rows", status, body, count())
+	}
+
+	// полный дамп нельзя восстановить атомарно, без явного согласия он не выполняется
+	status, body = request(http.MethodPost, "/_restore", dump, true)
+	if status != http.StatusBadRequest || !strings.Contains(body, "non_transactional=1") || count() != 101 {
+		t.Fatalf("expected non-transactional restore to be rejected, got %d %s", status, body)
+	}
+
+	// полный дамп пересоздает таблицу
+	db.Exec("DROP TABLE items")
+	status, body = request(http.MethodPost, "/_restore?non_transactional=1", dump, true)
+	if status != http.StatusOK || !strings.Contains(body, `"transactional":false`) || count() != 101 {
+		t.Fatalf("unexpected full restore: %d %s, %d rows", status, body, count())
+	}
+	status, body = request(http.MethodGet, "/items/101", "", false)
+	if status != http.StatusOK || !strings.Contains(body, `"title":"it's 101; \\ item"`) {
+		t.Fatalf("restored record mismatch: %d %s", status, body)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	db := newTestDB(t)
+
+	qs := []string{
+		`DROP TABLE IF EXISTS reviews`,
+		`DROP TABLE IF EXISTS tags`,
+		`CREATE TABLE reviews (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  item_id int(11) NOT NULL,
+  approved tinyint(1) NOT NULL,
+  price decimal(6,2) NOT NULL,
+  kind enum('short','long, detailed') NOT NULL,
+  created datetime DEFAULT NULL,
+  code varchar(12) NOT NULL,
+  author_email varchar(255) NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY code (code),
+  FOREIGN KEY (item_id) REFERENCES items (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+		`CREATE TABLE tags (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  review_id int(11) NOT NULL,
+  PRIMARY KEY (id),
+  FOREIGN KEY (review_id) REFERENCES reviews (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+	}
+	for _, q := range qs {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS reviews`)
+	defer db.Exec(`DROP TABLE IF EXISTS tags`)
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveTest(t, handler)
+
+	post := func(path string) (int, string) {
+		resp, err := client.Post(ts.URL+path, "application/json", nil)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	generated := func() string {
+		rows, err := db.Query("SELECT title, description, updated FROM items WHERE id > 2 ORDER BY id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var out strings.Builder
+		for rows.Next() {
+			var title, description string
+			var updated sql.NullString
+			rows.Scan(&title, &description, &updated)
+			if title == "" || len(title) > 255 {
+				t.Fatalf("invalid generated title %q", title)
+			}
+			fmt.Fprintf(&out, "%s|%s|%v\n", title, description, updated)
+		}
+		return out.String()
+	}
+
+	for _, path := range []string{"/items/_seed?count=0", "/items/_seed?count=abc", "/items/_seed?count=10001", "/items/_seed?seed=x"} {
+		if status, body := post(path); status != http.StatusBadRequest {
+			t.Fatalf("%s: expected bad request, got %d %s", path, status, body)
+		}
+	}
+	if status, _ := post("/unknown_table/_seed"); status != http.StatusNotFound {
+		t.Fatalf("expected unknown table, got %d", status)
+	}
+	// Родителей нет - заполнить обязательный внешний ключ нечем
+	if status, body := post("/tags/_seed?count=1"); status != http.StatusConflict ||
+		!strings.Contains(body, "column review_id references empty table reviews") {
+		t.Fatalf("expected conflict, got %d %s", status, body)
+	}
+
+	status, body := post("/items/_seed?count=250&seed=7")
+	if status != http.StatusOK || body != `{"response":{"inserted":250,"seed":7}}`+"\n" {
+		t.Fatalf("unexpected seed response: %d %s", status, body)
+	}
+	first := generated()
+	if strings.Count(first, "\n") != 250 {
+		t.Fatalf("expected 250 generated rows, got %d", strings.Count(first, "\n"))
+	}
+
+	// Тот же seed дает те же данные
+	db.Exec("DELETE FROM items WHERE id > 2")
+	post("/items/_seed?count=250&seed=7")
+	if second := generated(); second != first {
+		t.Fatalf("seed is not reproducible")
+	}
+	db.Exec("DELETE FROM items WHERE id > 2")
+	post("/items/_seed?count=250&seed=8")
+	if other := generated(); other == first {
+		t.Fatalf("different seeds generated the same data")
+	}
+
+	status, body = post("/reviews/_seed?count=120&seed=1")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected reviews seed: %d %s", status, body)
+	}
+	var orphans, badKind, badApproved, badCode, badEmail, total int
+	db.QueryRow(`SELECT COUNT(*) FROM reviews WHERE item_id NOT IN (SELECT id FROM items)`).Scan(&orphans)
+	db.QueryRow(`SELECT COUNT(*) FROM reviews WHERE kind NOT IN ('short', 'long, detailed')`).Scan(&badKind)
+	db.QueryRow(`SELECT COUNT(*) FROM reviews WHERE approved NOT IN (0, 1) OR price < 0 OR price >= 10000`).Scan(&badApproved)
+	db.QueryRow(`SELECT COUNT(*) FROM reviews WHERE LENGTH(code) > 12 OR code = ''`).Scan(&badCode)
+	db.QueryRow(`SELECT COUNT(*) FROM reviews WHERE author_email NOT LIKE '%@example.com'`).Scan(&badEmail)
+	db.QueryRow(`SELECT COUNT(DISTINCT code) FROM reviews`).Scan(&total)
+	if orphans != 0 || badKind != 0 || badApproved != 0 || badCode != 0 || badEmail != 0 || total != 120 {
+		t.Fatalf("invalid generated reviews: orphans %d, kind %d, approved/price %d, code %d, email %d, distinct codes %d",
+			orphans, badKind, badApproved, badCode, badEmail, total)
+	}
+
+	if status, body := post("/tags/_seed?count=10"); status != http.StatusOK {
+		t.Fatalf("unexpected tags seed: %d %s", status, body)
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	now := time.Now()
+	entry := func(key, table string) *cacheEntry {
+		return &cacheEntry{key: key, table: table, body: []byte(`{"response":{}}`)}
+	}
+
+	// вытесняется давно использованная запись
+	cache := newResponseCache(time.Minute, 2, 1<<20)
+	_, gen := cache.get("a", "items", now)
+	cache.put(entry("a", "items"), gen, now)
+	_, gen = cache.get("b", "items", now)
+	cache.put(entry("b", "items"), gen, now)
+	if hit, _ := cache.get("a", "items", now); hit == nil {
+		t.Fatalf("expected hit for a")
+	}
+	_, gen = cache.get("c", "users", now)
+	cache.put(entry("c", "users"), gen, now)
+	if hit, _ := cache.get("b", "items", now); hit != nil {
+		t.Fatalf("expected b to be evicted")
+	}
+	if hit, _ := cache.get("a", "items", now); hit == nil {
+		t.Fatalf("expected a to survive eviction")
+	}
+
+	// истекшая запись не отдается
+	if hit, _ := cache.get("a", "items", now.Add(time.Minute)); hit != nil {
+		t.Fatalf("expected expired entry")
+	}
+
+	// ответ, прочитанный до записи в таблицу, не сохраняется
+	_, gen = cache.get("d", "items", now)
+	cache.invalidate("items")
+	cache.put(entry("d", "items"), gen, now)
+	if hit, _ := cache.get("d", "items", now); hit != nil {
+		t.Fatalf("stale response must not be stored")
+	}
+	// пока реплики могут отставать от изменения, промах читается из primary
+	if !cache.recentlyInvalidated("items", time.Minute, time.Now()) || cache.recentlyInvalidated("users", time.Minute, time.Now()) ||
+		cache.recentlyInvalidated("items", time.Minute, time.Now().Add(2*time.Minute)) {
+		t.Fatalf("unexpected invalidation window")
+	}
+
+	_, gen = cache.get("e", "users", now)
+	cache.clear()
+	if !cache.recentlyInvalidated("users", time.Minute, time.Now()) {
+		t.Fatalf("clear must invalidate every table")
+	}
+	cache.put(entry("e", "users"), gen, now)
+	if hit, _ := cache.get("e", "users", now); hit != nil || cache.lru.Len() != 0 || cache.bytes != 0 {
+		t.Fatalf("expected empty cache after clear: %d entries, %d bytes", cache.lru.Len(), cache.bytes)
+	}
+
+	// ограничение по размеру
+	cache = newResponseCache(time.Minute, 100, 2*entry("x", "items").size())
+	for _, key := range []string{"x", "y", "z"} {
+		_, gen = cache.get(key, "items", now)
+		cache.put(entry(key, "items"), gen, now)
+	}
+	if cache.lru.Len() != 2 || cache.evictions != 1 {
+		t.Fatalf("expected 2 entries and 1 eviction, got %d and %d", cache.lru.Len(), cache.evictions)
+	}
+}
+
+func TestCache(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE comments (
+  comment_id int(11) NOT NULL AUTO_INCREMENT,
+  item_id int(11) NOT NULL,
+  body varchar(255) NOT NULL,
+  PRIMARY KEY (comment_id),
+  FOREIGN KEY (item_id) REFERENCES items (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS comments;`)
+	db.Exec(`INSERT INTO comments (comment_id, item_id, body) VALUES (1, 1, 'first')`)
+
+	handler, err := NewDbExplorer(db, WithCache(time.Minute, 100, 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveTest(t, handler)
+
+	request := func(method, path, body string) (string, string) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp.Header.Get("X-Cache"), string(data)
+	}
+	expect := func(path, cache, contains string) {
+		t.Helper()
+		status, body := request(http.MethodGet, path, "")
+		if status != cache || !strings.Contains(body, contains) {
+			t.Fatalf("GET %s: expected %s with %q, got %s %s", path, cache, contains, status, body)
+		}
+	}
+
+	expect("/items/1", "MISS", `"title":"database/sql"`)
+	expect("/items/1", "HIT", `"title":"database/sql"`)
+	// порядок параметров не важен
+	expect("/items?limit=1&offset=1", "MISS", `"title":"memcache"`)
+	expect("/items?offset=1&limit=1", "HIT", `"title":"memcache"`)
+	// ошибки не кешируются
+	expect("/items/100500", "MISS", "record not found")
+	expect("/items/100500", "MISS", "record not found")
+	expect("/comments/1", "MISS", `"body":"first"`)
+
+	// запись мимо API не видна до истечения ttl
+	db.Exec("UPDATE items SET title = 'direct' WHERE id = 1")
+	expect("/items/1", "HIT", `"title":"database/sql"`)
+
+	// запись через API сбрасывает таблицу и таблицы, ссылающиеся на нее
+	request(http.MethodPost, "/items/1", `{"description": "updated"}`)
+	expect("/items/1", "MISS", `"title":"direct"`)
+	expect("/items?limit=1&offset=1", "MISS", `"title":"memcache"`)
+	expect("/comments/1", "MISS", `"body":"first"`)
+	expect("/comments/1", "HIT", `"body":"first"`)
+
+	request(http.MethodPut, "/comments/", `{"item_id": 2, "body": "second"}`)
+	expect("/comments/1", "MISS", `"body":"first"`)
+	expect("/items/1", "HIT", `"title":"direct"`)
+
+	_, metrics := request(http.MethodGet, "/metrics", "")
+	for _, line := range []string{
+		`db_explorer_cache_hits_total{table="items"} 4`,
+		`db_explorer_cache_misses_total{table="items"} 6`,
+		`db_explorer_cache_hits_total{table="comments"} 1`,
+		"db_explorer_cache_entries 3",
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("expected %q in metrics", line)
+		}
+	}
+}
+
+func TestMultipleDatabases(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, q := range []string{
+		"CREATE DATABASE IF NOT EXISTS archive",
+		"DROP TABLE IF EXISTS archive.notes",
+		"DROP TABLE IF EXISTS archive.secrets",
+		"CREATE TABLE archive.notes (id int(11) NOT NULL AUTO_INCREMENT, body varchar(255) NOT NULL, PRIMARY KEY (id))",
+		"CREATE TABLE archive.secrets (id int(11) NOT NULL AUTO_INCREMENT, PRIMARY KEY (id))",
+		"INSERT INTO archive.notes (id, body) VALUES (1, 'archived')",
+		"CREATE TABLE db (id int(11) NOT NULL AUTO_INCREMENT, PRIMARY KEY (id))",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer db.Exec("DROP DATABASE IF EXISTS archive")
+	defer db.Exec("DROP TABLE IF EXISTS db")
+
+	// без именованных баз таблица db доступна как раньше, с ними путь /db занят
+	single, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !single.(*metricsMiddleware).explorer.tableExists("db") {
+		t.Fatalf("table db must be available without named databases")
+	}
+
+	archiveDB, err := sql.Open("mysql", strings.Replace(DSN, "/photolist", "/archive", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archiveDB.Close()
+
+	defaultHandler, err := NewDbExplorer(db, WithDatabases("archive"), WithCodec(plainCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := NewDbExplorer(archiveDB, WithTables(nil, []string{"secrets"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveTest(t, NewMultiDbExplorer(defaultHandler, map[string]http.Handler{"archive": archive}))
+
+	for _, c := range []struct {
+		method, path, body string
+		status             int
+		expected           string
+	}{
+		{"GET", "/", "", http.StatusOK, `{"response":{"databases":["archive"],"tables":["items","users"]}}`},
+		{"GET", "/db", "", http.StatusOK, `{"response":{"databases":["archive"]}}`},
+		{"GET", "/db/archive/", "", http.StatusOK, `{"response":{"tables":["notes"]}}`},
+		{"GET", "/db/archive/notes/1", "", http.StatusOK, `{"response":{"record":{"body":"archived","id":1}}}`},
+		{"PUT", "/db/archive/notes/", `{"body":"new"}`, http.StatusOK, `{"response":{"id":2}}`},
+		{"GET", "/db/archive/secrets", "", http.StatusNotFound, `{"error":"unknown table","code":"unknown_table"}`},
+		{"GET", "/db/archive/items", "", http.StatusNotFound, `{"error":"unknown table","code":"unknown_table"}`},
+		{"GET", "/db/unknown/items", "", http.StatusNotFound, `{"error":"unknown database","code":"unknown_database"}`},
+		{"POST", "/db", "", http.StatusNotFound, `{"error":"unknown method","code":"unknown_route"}`},
+		{"GET", "/items/1", "", http.StatusOK, `"title":"database/sql"`},
+		{"GET", "/db/archive/healthz", "", http.StatusOK, `{"status":"ok"}`},
+	} {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.body))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", c.method, c.path, err)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != c.status || !strings.Contains(string(data), c.expected) {
+			t.Errorf("%s %s: expected %d %s, got %d %s", c.method, c.path, c.status, c.expected, resp.StatusCode, data)
+		}
+	}
+
+	// Список баз отдается в форматах explorer по умолчанию
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/db", nil)
+	req.Header.Set("Accept", "text/plain")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET /db: %v", err)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/plain" || string(data) != "map[databases:[archive]]" {
+		t.Fatalf("expected text/plain database list, got %s %s", resp.Header.Get("Content-Type"), data)
+	}
+}
+
+// plainCodec формат для проверки WithCodec
+type plainCodec struct{}
+
+func (plainCodec) ContentType() string {
+	return "text/plain"
+}
+
+func (plainCodec) Encode(w io.Writer, response Response) error {
+	_, err := fmt.Fprint(w, response.Response)
+	return err
+}
+
+func (plainCodec) Decode(r io.Reader) (map[string]interface{}, error) {
+	return nil, errors.New("plain body is not supported")
+}
+
+func TestViewsAndProcedures(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, q := range []string{
+		"CREATE VIEW item_titles AS SELECT id, title FROM items",
+		`CREATE PROCEDURE items_since(IN min_id INT, INOUT label VARCHAR(32), OUT total INT)
+BEGIN
+  SET total = (SELECT COUNT(*) FROM items WHERE id >= min_id);
+  SET label = CONCAT(label, '!');
+  SELECT id, title FROM items WHERE id >= min_id ORDER BY id;
+END`,
+		"CREATE PROCEDURE always_fails() BEGIN SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'nothing to do'; END",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer db.Exec("DROP PROCEDURE IF EXISTS always_fails")
+	defer db.Exec("DROP PROCEDURE IF EXISTS items_since")
+	defer db.Exec("DROP VIEW IF EXISTS item_titles")
+
+	handler, err := NewDbExplorer(db, WithAdminToken("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// go-mysql-server не заполняет information_schema.ROUTINES и PARAMETERS, поэтому процедуры описываем сами
+	explorer := handler.(*metricsMiddleware).explorer
+	if len(explorer.procedures) == 0 {
+		explorer.procedures = map[string]Procedure{
+			"items_since": {Name: "items_since", Params: []ProcedureParam{
+				{Name: "min_id", Mode: "IN", Type: "int"},
+				{Name: "label", Mode: "INOUT", Type: "varchar(32)"},
+				{Name: "total", Mode: "OUT", Type: "int"},
+			}},
+			"always_fails": {Name: "always_fails", Params: []ProcedureParam{}},
+		}
+	}
+	ts := serveTest(t, handler)
+
+	for _, c := range []struct {
+		method, path, body string
+		status             int
+		expected           string
+	}{
+		{"GET", "/", "", http.StatusOK, `{"response":{"tables":["item_titles","items","users"],"views":["item_titles"]}}`},
+		{"GET", "/item_titles?limit=1", "", http.StatusOK, `{"response":{"records":[{"id":1,"title":"database/sql"}]}}`},
+		{"GET", "/item_titles/_schema", "", http.StatusOK, `"view":true`},
+		{"GET", "/item_titles/_aggregate?agg=count(*)", "", http.StatusOK, `"count(*)":2`},
+		{"GET", "/item_titles/1", "", http.StatusNotFound, `{"error":"unknown method","code":"unknown_route"}`},
+		{"PUT", "/item_titles/", `{"title":"x"}`, http.StatusMethodNotAllowed, `{"error":"view is read-only","code":"read_only"}`},
+		{"POST", "/item_titles/1", `{"title":"x"}`, http.StatusMethodNotAllowed, `"code":"read_only"`},
+		{"DELETE", "/item_titles/1", "", http.StatusMethodNotAllowed, `"code":"read_only"`},
+		{"POST", "/item_titles/_seed", "", http.StatusMethodNotAllowed, `"code":"read_only"`},
+		{"GET", "/_dump?tables=item_titles", "", http.StatusBadRequest, "view item_titles can not be dumped"},
+
+		{"GET", "/_rpc", "", http.StatusOK, `{"name":"items_since","params":[{"name":"min_id","mode":"IN","type":"int"}`},
+		{"POST", "/_rpc/items_since", `{"min_id": 2, "label": "x"}`, http.StatusOK,
+			`{"response":{"out":{"label":"x!","total":1},"result_sets":[[{"id":2,"title":"memcache"}]]}}`},
+		{"POST", "/_rpc/items_since", `{}`, http.StatusBadRequest,
+			`"details":[{"field":"label","code":"required","message":"field label is required"},{"field":"min_id","code":"required","message":"field min_id is required"}]`},
+		{"POST", "/_rpc/items_since", `{"min_id": "a", "label": "x", "total": 1, "extra": 1}`, http.StatusBadRequest,
+			`[{"field":"extra","code":"invalid_value","message":"field extra is not a parameter of items_since"},` +
+				`{"field":"min_id","code":"invalid_type","message":"field min_id have invalid type"},` +
+				`{"field":"total","code":"read_only","message":"field total is an OUT parameter"}]`},
+		{"POST", "/_rpc/always_fails", "", http.StatusBadRequest, `{"error":"nothing to do","code":"bad_request"}`},
+		{"POST", "/_rpc/unknown", "", http.StatusNotFound, `{"error":"unknown procedure","code":"unknown_procedure"}`},
+	} {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.body))
+		req.Header.Set("Authorization", "Bearer secret") // для /_dump
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", c.method, c.path, err)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != c.status || !strings.Contains(string(data), c.expected) {
+			t.Errorf("%s %s: expected %d %s, got %d %s", c.method, c.path, c.status, c.expected, resp.StatusCode, data)
+		}
+	}
+}
+
+func TestNamedQueries(t *testing.T) {
+	db := newTestDB(t)
+
+	zero := "0"
+	queries := map[string]NamedQuery{
+		"item_authors": {
+			SQL: `SELECT i.id, i.title, u.email FROM items i LEFT JOIN users u ON u.login = i.updated
+				WHERE i.id > :after AND (:author IS NULL OR u.login = :author) AND i.title <> ':after' ORDER BY i.id`,
+			Params: map[string]QueryParam{
+				"after":  {Type: "integer", Default: &zero},
+				"author": {Type: "string"},
+			},
+		},
+		"items_since": {
+			SQL:    "SELECT id FROM items WHERE id >= :min_id ORDER BY id",
+			Params: map[string]QueryParam{"min_id": {Type: "integer", Required: true}},
+		},
+	}
+
+	for _, c := range []struct {
+		query NamedQuery
+		err   string
+	}{
+		{NamedQuery{SQL: "DELETE FROM items"}, "queries.bad.sql: must be a SELECT"},
+		{NamedQuery{SQL: "SELECT 1; SELECT 2"}, "queries.bad.sql: must be a single statement"},
+		{NamedQuery{SQL: "SELECT :x"}, "queries.bad.sql: parameter :x is not declared in params"},
+		{NamedQuery{SQL: "SELECT 1", Params: map[string]QueryParam{"x": {Type: "integer"}}}, "queries.bad.params.x: not used in sql"},
+		{NamedQuery{SQL: "SELECT :x", Params: map[string]QueryParam{"x": {Type: "uuid"}}}, `queries.bad.params.x.type: unknown type "uuid"`},
+		{NamedQuery{SQL: "SELECT :limit", Params: map[string]QueryParam{"limit": {Type: "integer"}}}, "queries.bad.params: limit is reserved for paging"},
+	} {
+		if _, err := NewDbExplorer(db, WithQueries(map[string]NamedQuery{"bad": c.query})); err == nil || err.Error() != c.err {
+			t.Errorf("%s: expected error %q, got %v", c.query.SQL, c.err, err)
+		}
+	}
+
+	handler, err := NewDbExplorer(db, WithQueries(queries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveTest(t, handler)
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path: "/_queries",
+			Result: CR{
+				"response": CR{
+					"queries": CR{
+						"item_authors": CR{"params": CR{
+							"after":  CR{"type": "integer", "required": false, "default": "0"},
+							"author": CR{"type": "string", "required": false},
+						}},
+						"items_since": CR{"params": CR{
+							"min_id": CR{"type": "integer", "required": true},
+						}},
+					},
+				},
+			},
+		},
+		Case{
+			Path: "/_queries/item_authors",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "title": "database/sql", "email": "rvasily@example.com"},
+						CR{"id": 2, "title": "memcache", "email": nil},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/_queries/item_authors",
+			Query: "author=rvasily",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "title": "database/sql", "email": "rvasily@example.com"},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/_queries/item_authors",
+			Query: "limit=1&offset=1&count=exact",
+			Result: CR{
+				"response": CR{
+					"total": 2,
+					"records": []CR{
+						CR{"id": 2, "title": "memcache", "email": nil},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/_queries/items_since",
+			Query: "min_id=2",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 2},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/_queries/items_since",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "field min_id is required",
+				"code":  "validation_failed",
+				"details": []CR{
+					CR{"field": "min_id", "code": "required", "message": "field min_id is required"},
+				},
+			},
+		},
+		Case{
+			Path:   "/_queries/items_since",
+			Query:  "min_id=two",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": `field min_id: "two" is not an integer`,
+				"code":  "validation_failed",
+				"details": []CR{
+					CR{"field": "min_id", "code": "invalid_type", "message": `field min_id: "two" is not an integer`},
+				},
+			},
+		},
+		Case{
+			Path:   "/_queries/unknown",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown query",
+				"code":  "unknown_query",
+			},
+		},
+	})
+}
+
+func TestBlobs(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := db.Exec(`CREATE TABLE files (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  name varchar(255) NOT NULL,
+  mime varchar(100) DEFAULT NULL,
+  hash varbinary(4) DEFAULT NULL,
+  data mediumblob,
+  deleted_at datetime DEFAULT NULL,
+  PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DROP TABLE IF EXISTS files")
+
+	if _, err := NewDbExplorer(db, WithBlobs(0, map[string]string{"files.name": "mime"})); err == nil ||
+		err.Error() != "blob content type: files.name is not a binary column" {
+		t.Fatalf("expected content type error, got %v", err)
+	}
+
+	handler, err := NewDbExplorer(db, WithBlobs(8, map[string]string{"files.data": "mime"}), WithSoftDelete("files", "deleted_at"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveTest(t, handler)
+
+	for _, c := range []struct {
+		method, path, contentType, body string
+		status                          int
+		expectedType, expected          string
+	}{
+		{"PUT", "/files/", "", `{"name": "a", "hash": "AQID", "data": "aGVsbG8="}`, http.StatusOK, "", `{"response":{"id":1}}`},
+		{"PUT", "/files/", "", `{"name": "b"}`, http.StatusOK, "", `{"response":{"id":2}}`},
+		{"GET", "/files/1", "", "", http.StatusOK, "",
+			`{"response":{"record":{"data":"aGVsbG8=","deleted_at":null,"hash":"AQID","id":1,"mime":null,"name":"a"}}}`},
+		{"GET", "/files?limit=1", "", "", http.StatusOK, "", `{"response":{"records":[{"deleted_at":null,"hash":"AQID","id":1,"mime":null,"name":"a"}]}}`},
+		{"GET", "/files?limit=1&include_blobs=1", "", "", http.StatusOK, "", `"data":"aGVsbG8="`},
+		// список в GraphQL выбирает те же колонки, что и REST
+		{"POST", "/graphql", "", `{"query": "{ files(limit: 1) { id data } }"}`, http.StatusOK, "", `{"data":{"files":[{"data":null,"id":1}]}}`},
+		{"GET", "/files/1/data", "", "", http.StatusOK, "application/octet-stream", "hello"},
+		{"PUT", "/files/1/data", "image/svg+xml", "<svg/>", http.StatusOK, "", `{"response":{"updated":1}}`},
+		{"GET", "/files/1/data", "", "", http.StatusOK, "image/svg+xml", "<svg/>"},
+		{"GET", "/files/1", "", "", http.StatusOK, "", `"mime":"image/svg+xml"`},
+		{"PUT", "/files/1/data", "", "123456789", http.StatusRequestEntityTooLarge, "",
+			`{"error":"body is larger than 8 bytes","code":"too_large"}`},
+		{"POST", "/files/1", "", `{"data": "!!", "hash": 1}`, http.StatusBadRequest, "",
+			`[{"field":"data","code":"invalid_type","message":"field data is not valid base64"},` +
+				`{"field":"hash","code":"invalid_type","message":"field hash have invalid type"}]`},
+		{"POST", "/files/1", "", `{"data": "MTIzNDU2Nzg5"}`, http.StatusBadRequest, "",
+			`[{"field":"data","code":"invalid_value","message":"field data is larger than 8 bytes"}]`},
+		{"GET", "/files/2/data", "", "", http.StatusNotFound, "", `{"error":"value is null","code":"not_found"}`},
+		// мягко удаленная запись не перезаписывается
+		{"DELETE", "/files/2", "", "", http.StatusOK, "", `{"response":{"deleted":1}}`},
+		{"PUT", "/files/2/data", "text/plain", "x", http.StatusOK, "", `{"response":{"updated":0}}`},
+		{"GET", "/files/2/data?include_deleted=1", "", "", http.StatusNotFound, "", `{"error":"value is null","code":"not_found"}`},
+		{"GET", "/files/3/data", "", "", http.StatusNotFound, "", `{"error":"record not found","code":"not_found"}`},
+		{"GET", "/files/1/name", "", "", http.StatusBadRequest, "", `{"error":"column name is not binary","code":"bad_request"}`},
+		{"GET", "/files/1/unknown", "", "", http.StatusNotFound, "", `{"error":"unknown column","code":"not_found"}`},
+	} {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.body))
+		if c.contentType != "" {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", c.method, c.path, err)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != c.status || !strings.Contains(string(data), c.expected) {
+			t.Errorf("%s %s: expected %d %s, got %d %s", c.method, c.path, c.status, c.expected, resp.StatusCode, data)
+		}
+		if c.expectedType != "" && resp.Header.Get("Content-Type") != c.expectedType {
+			t.Errorf("%s %s: expected Content-Type %s, got %s", c.method, c.path, c.expectedType, resp.Header.Get("Content-Type"))
+		}
+		// загруженный SVG или HTML не должен выполняться на origin explorer
+		if c.expectedType != "" && (resp.Header.Get("Content-Disposition") != "attachment" || resp.Header.Get("Content-Security-Policy") != "sandbox") {
+			t.Errorf("%s %s: blob must be served as a sandboxed attachment, got %v", c.method, c.path, resp.Header)
+		}
+	}
+}
+
+func TestContentNegotiation(t *testing.T) {
+	ts, _ := newTestServer(t, WithCache(time.Minute, 100, 1<<20))
+
+	request := func(method, path, accept, contentType string, body []byte) (*http.Response, []byte) {
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp, data
+	}
+
+	for _, c := range []struct {
+		method, path, accept, contentType, body string
+		status                                  int
+		expectedType, expected                  string
+	}{
+		{"GET", "/items?limit=2", "text/csv", "", "", http.StatusOK, "text/csv",
+			"description,id,title,updated\n" +
+				"Рассказать про базы данных,1,database/sql,rvasily\n" +
+				"Рассказать про мемкеш с примером использования,2,memcache,\n"},
+		{"GET", "/items/2", "application/xml", "", "", http.StatusOK, "application/xml",
+			`<response><record><description>Рассказать про мемкеш с примером использования</description>` +
+				`<id>2</id><title>memcache</title><updated nil="true"></updated></record></response>`},
+		{"GET", "/items/_aggregate?agg=count(*)", "application/xml", "", "", http.StatusOK, "application/xml",
+			`<field name="count(*)">2</field>`},
+		{"GET", "/items/1", "text/csv;q=0.5, application/xml", "", "", http.StatusOK, "application/xml", "<id>1</id>"},
+		{"GET", "/items/1", "text/*", "", "", http.StatusOK, "text/csv", "1,database/sql,rvasily"},
+		{"GET", "/items/1", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "", "", http.StatusOK,
+			"application/json", `"title":"database/sql"`},
+		{"GET", "/items/1", "text/html,application/xhtml+xml,application/xml;q=0.9", "", "", http.StatusOK,
+			"application/json", `"title":"database/sql"`},
+		{"GET", "/items/1", "application/xml;q=0.8, */*;q=0.8", "", "", http.StatusOK, "application/json", `"title":"database/sql"`},
+		{"GET", "/items/1", "application/xml, */*;q=0.8", "", "", http.StatusOK, "application/xml", "<id>1</id>"},
+		{"GET", "/", "text/csv", "", "", http.StatusOK, "application/json", `{"response":{"tables":["items","users"]}}`},
+		{"GET", "/items/1", "image/png", "", "", http.StatusOK, "application/json", `"title":"database/sql"`},
+		{"GET", "/unknown", "application/xml", "", "", http.StatusNotFound, "application/json", `"code":"unknown_table"`},
+
+		{"PUT", "/items/", "text/csv", "text/csv", "title,description,updated\nnew,csv,\n", http.StatusOK, "text/csv", "id\n3\n"},
+		{"GET", "/items/3", "", "", "", http.StatusOK, "application/json",
+			`{"response":{"record":{"description":"csv","id":3,"title":"new","updated":null}}}`},
+		{"POST", "/items/3", "", "application/xml; charset=utf-8", `<record><title>xml</title><field name="updated">rvasily</field></record>`,
+			http.StatusOK, "application/json", `{"response":{"updated":1}}`},
+		{"GET", "/items/3", "", "", "", http.StatusOK, "application/json", `"title":"xml","updated":"rvasily"`},
+		{"POST", "/items/3", "", "application/xml", `<record><updated nil="true"/></record>`, http.StatusOK, "application/json", `{"response":{"updated":1}}`},
+		{"PUT", "/items/", "", "text/csv", "title\na\nb\n", http.StatusBadRequest, "application/json", `{"error":"invalid csv body","code":"bad_request"}`},
+		{"POST", "/items/3", "", "text/csv", "id\n4\n", http.StatusBadRequest, "application/json", `"code":"validation_failed"`},
+	} {
+		resp, data := request(c.method, c.path, c.accept, c.contentType, []byte(c.body))
+		if resp.StatusCode != c.status || !strings.Contains(string(data), c.expected) {
+			t.Errorf("%s %s (%s): expected %d %s, got %d %s", c.method, c.path, c.accept, c.status, c.expected, resp.StatusCode, data)
+		}
+		if contentType := resp.Header.Get("Content-Type"); contentType != c.expectedType {
+			t.Errorf("%s %s (%s): expected Content-Type %s, got %s", c.method, c.path, c.accept, c.expectedType, contentType)
+		}
+	}
+
+	// MessagePack в обе стороны: числа приходят числами, а не float64
+	body, _ := msgpack.Marshal(map[string]interface{}{"title": "msgpack", "description": "mp"})
+	resp, data := request("PUT", "/items/", "application/msgpack", "application/msgpack", body)
+	var created map[string]map[string]int64
+	if err := msgpack.Unmarshal(data, &created); err != nil || resp.StatusCode != http.StatusOK || created["response"]["id"] != 4 {
+		t.Fatalf("unexpected msgpack create: %d %v %v", resp.StatusCode, created, err)
+	}
+	body, _ = msgpack.Marshal(map[string]interface{}{"title": 5})
+	if resp, data := request("POST", "/items/4", "", "application/msgpack", body); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected validation error, got %d %s", resp.StatusCode, data)
+	}
+	resp, data = request("GET", "/items/4", "application/msgpack", "", nil)
+	var record struct {
+		Response struct {
+			Record struct {
+				ID    int64  `msgpack:"id"`
+				Title string `msgpack:"title"`
+			} `msgpack:"record"`
+		} `msgpack:"response"`
+	}
+	if err := msgpack.Unmarshal(data, &record); err != nil || resp.Header.Get("Content-Type") != "application/msgpack" ||
+		record.Response.Record.ID != 4 || record.Response.Record.Title != "msgpack" {
+		t.Fatalf("unexpected msgpack record: %v %+v", err, record)
+	}
+
+	// Кеш хранит ответ для каждого Accept отдельно
+	if resp, _ := request("GET", "/items/4", "application/xml", "", nil); resp.Header.Get("X-Cache") != "MISS" ||
+		resp.Header.Get("Content-Type") != "application/xml" {
+		t.Fatalf("expected xml cache miss, got %s %s", resp.Header.Get("X-Cache"), resp.Header.Get("Content-Type"))
+	}
+	if resp, _ := request("GET", "/items/4", "application/msgpack", "", nil); resp.Header.Get("X-Cache") != "HIT" {
+		t.Fatalf("expected msgpack cache hit, got %s", resp.Header.Get("X-Cache"))
+	}
+}
+
+func TestGen(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, q := range []string{
+		"DROP TABLE IF EXISTS gen_types",
+		`CREATE TABLE gen_types (
+			uuid binary(16) NOT NULL,
+			counter bigint unsigned NOT NULL,
+			score double DEFAULT NULL,
+			price decimal(10,2) NOT NULL,
+			created_at datetime DEFAULT NULL,
+			avatar blob,
+			PRIMARY KEY (uuid)
+		)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer db.Exec("DROP TABLE IF EXISTS gen_types")
+
+	getenv := func(string) string { return "" }
+	run := func(args ...string) (int, string) {
+		var out bytes.Buffer
+		code := runGen(append([]string{"-dsn", DSN}, args...), getenv, &out, &out)
+		return code, out.String()
+	}
+
+	code, out := run("-package", "models", "-tables", "items,users,gen_types")
+	expected := []string{
+		"// Code generated by db_explorer gen. DO NOT EDIT.",
+		"package models",
+		"type Items struct {",
+		"ID          int32   `json:\"id,omitempty\" db:\"id\"`",
+		"Updated     *string `json:\"updated\" db:\"updated\"`",
+		"type Users struct {",
+		"UserID   int32   `json:\"user_id,omitempty\" db:\"user_id\"`",
+		"type GenTypes struct {",
+		"UUID      []byte   `json:\"uuid\" db:\"uuid\"`",
+		"Counter   uint64   `json:\"counter\" db:\"counter\"`",
+		"Score     *float64 `json:\"score\" db:\"score\"`",
+		"Price     string   `json:\"price\" db:\"price\"`",
+		"CreatedAt *string  `json:\"created_at\" db:\"created_at\"`",
+		"Avatar    []byte   `json:\"avatar\" db:\"avatar\"`",
+	}
+	for _, line := range expected {
+		if code != 0 || !strings.Contains(out, line) {
+			t.Fatalf("expected %q in generated code: %d\n%s", line, code, out)
+		}
+	}
+	if strings.Contains(out, "import") {
+		t.Fatalf("structs without -client must not import anything:\n%s", out)
+	}
+
+	code, out = run("-tables", "items,gen_types", "-client")
+	expected = []string{
+		"client \"db_explorer/client\"",
+		"type ItemsTable struct {",
+		"func (t ItemsTable) List(ctx context.Context, opts client.ListOptions) (*client.Page[Items], error) {",
+		"func (t ItemsTable) Get(ctx context.Context, id int32) (*Items, error) {",
+		"return client.Get[Items](ctx, t.c, \"items\", fmt.Sprint(id))",
+		"func (t ItemsTable) Update(ctx context.Context, id int32, fields client.Record) (int64, error) {",
+		"func (t GenTypesTable) Delete(ctx context.Context, id string) (int64, error) {",
+	}
+	for _, line := range expected {
+		if code != 0 || !strings.Contains(out, line) {
+			t.Fatalf("expected %q in generated client: %d\n%s", line, code, out)
+		}
+	}
+
+	file := t.TempDir() + "/models.go"
+	if code, out := run("-out", file, "-tables", "items"); code != 0 || !strings.Contains(out, "generated 1 tables in "+file) {
+		t.Fatalf("unexpected -out result: %d %s", code, out)
+	}
+	if data, err := os.ReadFile(file); err != nil || !strings.Contains(string(data), "type Items struct") {
+		t.Fatalf("unexpected generated file: %v\n%s", err, data)
+	}
+
+	if code, out := run("-tables", "unknown"); code != 1 || !strings.Contains(out, "unknown table unknown") {
+		t.Fatalf("expected unknown table error: %d %s", code, out)
+	}
+	if code, out := run("-package", "func"); code != 2 || !strings.Contains(out, "not a valid package name") {
+		t.Fatalf("expected invalid package error: %d %s", code, out)
+	}
+}
+
+func TestUI(t *testing.T) {
+	db := newTestDB(t)
+
+	defaultHandler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveTest(t, NewMultiDbExplorer(defaultHandler, map[string]http.Handler{"archive": archive}))
+
+	// редирект проверяется сам, без перехода по Location
+	noRedirect := &http.Client{
+		Timeout:       time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	for _, c := range []struct {
+		method, path string
+		status       int
+		expectedType string
+		expected     string
+	}{
+		{"GET", "/_ui", http.StatusFound, "", ""},
+		{"GET", "/_ui/", http.StatusOK, "text/html", `<script src="app.js"></script>`},
+		{"GET", "/_ui/app.js", http.StatusOK, "javascript", "/_ui"},
+		{"GET", "/_ui/style.css", http.StatusOK, "text/css", "#sidebar"},
+		{"GET", "/_ui/missing.js", http.StatusNotFound, "", ""},
+		{"GET", "/db/archive/_ui/", http.StatusOK, "text/html", `<script src="app.js"></script>`},
+		{"POST", "/_ui/", http.StatusNotFound, "application/json", `"code":"unknown_route"`},
+	} {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, nil)
+		resp, err := noRedirect.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", c.method, c.path, err)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != c.status || !strings.Contains(resp.Header.Get("Content-Type"), c.expectedType) || !strings.Contains(string(data), c.expected) {
+			t.Errorf("%s %s: expected %d %s %s, got %d %s %s", c.method, c.path, c.status, c.expectedType, c.expected,
+				resp.StatusCode, resp.Header.Get("Content-Type"), data)
+		}
+		if c.status == http.StatusFound && resp.Header.Get("Location") != "_ui/" {
+			t.Errorf("%s %s: expected relative redirect to _ui/, got %q", c.method, c.path, resp.Header.Get("Location"))
+		}
+		if c.status == http.StatusOK && resp.Header.Get("Content-Security-Policy") != "default-src 'self'" {
+			t.Errorf("%s %s: expected Content-Security-Policy header", c.method, c.path)
+		}
+	}
+}
+
+func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
+	for idx, item := range cases {
+		var (
+			err      error
+			result   interface{}
+			expected interface{}
+			req      *http.Request
+		)
+
+		caseName := fmt.Sprintf("case %d: [%s] %s %s", idx, item.Method, item.Path, item.Query)
+
+		// если у вас случилась это ошибка - значит вы не делаете где-то rows.Close и у вас текут соединения с базой
+		// если такое случилось на первом тесте - значит вы не закрываете коннект где-то при иницаилизации в NewDbExplorer
+		if db.Stats().OpenConnections != 1 {
+			t.Fatalf("[%s] you have %d open connections, must be 1", caseName, db.Stats().OpenConnections)
+		}
+
+		if item.Method == "" || item.Method == http.MethodGet {
+			req, err = http.NewRequest(item.Method, ts.URL+item.Path+"?"+item.Query, nil)
+		} else {
+			data, err := json.Marshal(item.Body)
+			if err != nil {
+				panic(err)
+			}
+			reqBody := bytes.NewReader(data)
+			req, err = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
+			req.Header.Add("Content-Type", "application/json")
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("[%s] request error: %v", caseName, err)
+			continue
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+
+		// fmt.Printf("[%s] body: %s\n", caseName, string(body))
+		if item.Status == 0 {
+			item.Status = http.StatusOK
+		}
+
+		if resp.StatusCode != item.Status {
+			t.Fatalf("[%s] expected http status %v, got %v", caseName, item.Status, resp.StatusCode)
+			continue
+		}
+
+		err = json.Unmarshal(body, &result)
+		if err != nil {
+			t.Fatalf("[%s] cant unpack json: %v", caseName, err)
+			continue
+		}
+
+		// reflect.DeepEqual не работает если нам приходят разные типы
+		// а там приходят разные типы (string VS interface{}) по сравнению с тем что в ожидаемом результате
+		// этот маленький грязный хак конвертит данные сначала в json, а потом обратно в interface - получаем совместимые результаты
+		// не используйте это в продакшен-коде - надо явно писать что ожидается интерфейс или использовать другой подход с точным форматом ответа
+		data, err := json.Marshal(item.Result)
+		json.Unmarshal(data, &expected)
+
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("[%s] results not match\nGot : %#v\nWant: %#v", caseName, result, expected)
+			continue
+		}
+	}
+
+}
+
+func TestClient(t *testing.T) {
+	ts, _ := newTestServer(t)
+	c := dbclient.New(ts.URL, dbclient.WithHTTPClient(client))
+	ctx := context.Background()
+
+	type item struct {
+		ID          int64   `json:"id,omitempty"`
+		Title       string  `json:"title"`
+		Description string  `json:"description"`
+		Updated     *string `json:"updated"`
+	}
+
+	page, err := dbclient.List[item](ctx, c, "items", dbclient.ListOptions{Limit: 1, Offset: 1, Count: true})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if page.Total != 2 || len(page.Records) != 1 || page.Records[0].Title != "memcache" || page.Records[0].Updated != nil {
+		t.Fatalf("unexpected page %+v", page)
+	}
+
+	filtered, err := c.List(ctx, "items", dbclient.ListOptions{Filters: map[string]string{"title": "database/sql"}})
+	if err != nil || len(filtered.Records) != 1 || filtered.Records[0]["id"] != json.Number("1") {
+		t.Fatalf("unexpected filtered list %+v: %v", filtered, err)
+	}
+
+	id, err := c.Create(ctx, "items", item{Title: "db_crud", Description: ""})
+	if err != nil || id != "3" {
+		t.Fatalf("Create: expected id 3, got %q: %v", id, err)
+	}
+	if updated, err := c.Update(ctx, "items", id, dbclient.Record{"description": "Написать программу db_crud"}); err != nil || updated != 1 {
+		t.Fatalf("Update: expected 1, got %d: %v", updated, err)
+	}
+	created, err := dbclient.Get[item](ctx, c, "items", id)
+	if err != nil || created.Description != "Написать программу db_crud" {
+		t.Fatalf("Get: unexpected %+v: %v", created, err)
+	}
+
+	_, err = c.Update(ctx, "items", id, dbclient.Record{"title": 42})
+	var apiErr *dbclient.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != dbclient.CodeValidationFailed || apiErr.Field("title") == nil {
+		t.Fatalf("expected validation_failed for title, got %v", err)
+	}
+
+	if deleted, err := c.Delete(ctx, "items", id); err != nil || deleted != 1 {
+		t.Fatalf("Delete: expected 1, got %d: %v", deleted, err)
+	}
+	if _, err := c.Get(ctx, "items", id); !dbclient.IsNotFound(err) {
+		t.Fatalf("expected not found after delete, got %v", err)
+	}
+	if _, err := c.List(ctx, "unknown_table", dbclient.ListOptions{}); dbclient.ErrorCode(err) != dbclient.CodeUnknownTable {
+		t.Fatalf("expected unknown_table, got %v", err)
+	}
 }
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// histogramBuckets границы бакетов гистограмм в секундах, как по умолчанию в клиентах Prometheus
+func histogramBuckets() []float64 {
+	return []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+}
+
+// histogram гистограмма длительностей. counts[i] - число наблюдений в бакете i (не накопительно),
+// последний элемент - наблюдения больше последней границы
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// observe добавляет наблюдение
+func (h *histogram) observe(seconds float64) {
+	buckets := histogramBuckets()
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets)+1)
+	}
+	i := sort.SearchFloat64s(buckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+// requestLabels метки HTTP-запроса
+type requestLabels struct {
+	table  string
+	method string
+	status string
+}
+
+// metrics хранит метрики сервиса и отдает их в текстовом формате Prometheus
+type metrics struct {
+	mu                 sync.Mutex
+	requests           map[requestLabels]uint64
+	requestDurations   map[requestLabels]*histogram
+	queryDurations     map[string]*histogram // вид запроса (select, insert...) -> длительность
+	validationFailures map[string]uint64     // таблица -> число отклоненных запросов
+}
+
+// newMetrics создает пустые метрики
+func newMetrics() *metrics {
+	return &metrics{
+		requests:           make(map[requestLabels]uint64),
+		requestDurations:   make(map[requestLabels]*histogram),
+		queryDurations:     make(map[string]*histogram),
+		validationFailures: make(map[string]uint64),
+	}
+}
+
+// observeRequest учитывает обработанный HTTP-запрос
+func (m *metrics) observeRequest(labels requestLabels, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[labels]++
+	h, ok := m.requestDurations[labels]
+	if !ok {
+		h = &histogram{}
+		m.requestDurations[labels] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// observeQuery учитывает длительность запроса к базе, начатого в start.
+// Удобно вызывать через defer: defer explorer.metrics.observeQuery(query, time.Now())
+func (m *metrics) observeQuery(query string, start time.Time) {
+	operation := "other"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		switch word := strings.ToLower(fields[0]); word {
+		case "select", "insert", "update", "delete", "show", "create", "alter", "drop", "call":
+			operation = word
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.queryDurations[operation]
+	if !ok {
+		h = &histogram{}
+		m.queryDurations[operation] = h
+	}
+	h.observe(time.Since(start).Seconds())
+}
+
+// validationFailed учитывает запрос, отклоненный валидацией
+func (m *metrics) validationFailed(table string) {
+	m.mu.Lock()
+	m.validationFailures[table]++
+	m.mu.Unlock()
+}
+
+// writeTo пишет метрики в текстовом формате Prometheus.
+// dbs - пулы соединений, для которых отдаются gauge из sql.DB.Stats()
+func (m *metrics) writeTo(w io.Writer, dbs map[string]*sql.DB) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP db_explorer_http_requests_total Total number of HTTP requests.")
+	fmt.Fprintln(w, "# TYPE db_explorer_http_requests_total counter")
+	for _, labels := range sortedRequestLabels(m.requests) {
+		fmt.Fprintf(w, "db_explorer_http_requests_total{%s} %d\n", labels.format(), m.requests[labels])
+	}
+
+	fmt.Fprintln(w, "# HELP db_explorer_http_request_duration_seconds HTTP request latency.")
+	fmt.Fprintln(w, "# TYPE db_explorer_http_request_duration_seconds histogram")
+	for _, labels := range sortedRequestLabels(m.requests) {
+		writeHistogram(w, "db_explorer_http_request_duration_seconds", labels.format(), m.requestDurations[labels])
+	}
+
+	fmt.Fprintln(w, "# HELP db_explorer_query_duration_seconds Database query latency by statement kind.")
+	fmt.Fprintln(w, "# TYPE db_explorer_query_duration_seconds histogram")
+	for _, operation := range sortedKeys(m.queryDurations) {
+		writeHistogram(w, "db_explorer_query_duration_seconds", formatLabel("operation", operation), m.queryDurations[operation])
+	}
+
+	fmt.Fprintln(w, "# HELP db_explorer_validation_failures_total Requests rejected by input validation.")
+	fmt.Fprintln(w, "# TYPE db_explorer_validation_failures_total counter")
+	for _, table := range sortedKeys(m.validationFailures) {
+		fmt.Fprintf(w, "db_explorer_validation_failures_total{%s} %d\n", formatLabel("table", table), m.validationFailures[table])
+	}
+
+	writeDBStats(w, dbs)
+}
+
+// writeDBStats пишет gauge пулов соединений
+func writeDBStats(w io.Writer, dbs map[string]*sql.DB) {
+	names := sortedKeys(dbs)
+	stats := make(map[string]sql.DBStats, len(dbs))
+	for _, name := range names {
+		stats[name] = dbs[name].Stats()
+	}
+
+	gauges := []struct {
+		name, kind, help string
+		value            func(s sql.DBStats) float64
+	}{
+		{"db_explorer_db_max_open_connections", "gauge", "Maximum number of open connections.",
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"db_explorer_db_open_connections", "gauge", "Number of established connections.",
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"db_explorer_db_in_use_connections", "gauge", "Number of connections in use.",
+			func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"db_explorer_db_idle_connections", "gauge", "Number of idle connections.",
+			func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"db_explorer_db_wait_count_total", "counter", "Total number of connections waited for.",
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"db_explorer_db_wait_duration_seconds_total", "counter", "Total time blocked waiting for a new connection.",
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+	}
+	for _, gauge := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n", gauge.name, gauge.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", gauge.name, gauge.kind)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{%s} %g\n", gauge.name, formatLabel("db", name), gauge.value(stats[name]))
+		}
+	}
+}
+
+// writeHistogram пишет бакеты (накопительно), сумму и количество гистограммы
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	buckets := histogramBuckets()
+	var cumulative uint64
+	for i, le := range buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, le, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// format возвращает метки в формате table="items",method="GET",status="200"
+func (labels requestLabels) format() string {
+	return formatLabel("table", labels.table) + "," + formatLabel("method", labels.method) + "," + formatLabel("status", labels.status)
+}
+
+// formatLabel экранирует значение метки по правилам текстового формата Prometheus
+func formatLabel(name, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return fmt.Sprintf(`%s="%s"`, name, value)
+}
+
+// sortedRequestLabels возвращает метки запросов в стабильном порядке
+func sortedRequestLabels(requests map[requestLabels]uint64) []requestLabels {
+	labels := make([]requestLabels, 0, len(requests))
+	for l := range requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].format() < labels[j].format()
+	})
+	return labels
+}
+
+// sortedKeys возвращает ключи map в отсортированном порядке
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// statusRecorder запоминает код ответа для метрик
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Flush передает сброс буфера дальше, иначе потоковые ответы (/_dump) доходили бы целиком в конце
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap открывает исходный ResponseWriter для http.ResponseController
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// metricsMiddleware собирает метрики запросов вокруг DbExplorer.ServeHTTP и отдает GET /metrics
+type metricsMiddleware struct {
+	explorer *DbExplorer
+}
+
+// Close останавливает фоновую проверку реплик. Подключения к базе остаются открытыми,
+// их закрывает тот, кто их открыл
+func (middleware *metricsMiddleware) Close() error {
+	middleware.explorer.router.close()
+	return nil
+}
+
+func (middleware *metricsMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	explorer := middleware.explorer
+	// Служебные пути не учитываются в метриках и не ограничиваются лимитами
+	if handleHealth(w, r, explorer.ready) || handleUI(w, r) {
+		return
+	}
+	if r.Method == http.MethodGet && strings.Trim(r.URL.Path, "/") == "metrics" {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		explorer.metrics.writeTo(w, explorer.connectionPools())
+		explorer.cache.writeMetrics(w)
+		return
+	}
+
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	explorer.ServeHTTP(rec, r)
+
+	// Метка таблицы только для известных таблиц, чтобы произвольные пути не раздували число рядов
+	table := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 2)[0]
+	explorer.schemaMu.RLock()
+	if !explorer.tableExists(table) {
+		table = ""
+	}
+	explorer.schemaMu.RUnlock()
+	explorer.metrics.observeRequest(requestLabels{
+		table:  table,
+		method: methodLabel(r.Method),
+		status: fmt.Sprint(rec.status),
+	}, time.Since(start))
+}
+
+// methodLabel возвращает метод для метки. Произвольные методы сводятся к other,
+// иначе каждый новый метод добавлял бы ряды метрик
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodHead, http.MethodOptions:
+		return method
+	}
+	return "other"
+}
+
+// connectionPools возвращает пулы соединений для метрик: primary и реплики по порядку
+func (explorer *DbExplorer) connectionPools() map[string]*sql.DB {
+	pools := map[string]*sql.DB{"primary": explorer.db}
+	for i, replica := range explorer.replicas {
+		pools[fmt.Sprintf("replica%d", i)] = replica
+	}
+	return pools
+}
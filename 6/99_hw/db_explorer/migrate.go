@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// migrationsTable таблица с примененными версиями
+	migrationsTable = "schema_migrations"
+	// migrationLock имя блокировки GET_LOCK, чтобы два запуска не применяли миграции одновременно
+	migrationLock = "db_explorer_migrate"
+)
+
+// migrationFileName имя файла миграции: 0001_create_items.up.sql, 0001_create_items.down.sql
+var migrationFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// migrationName имя миграции в имени файла
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// migration миграция из каталога. down пустой, если файла отката нет
+type migration struct {
+	version int64
+	name    string
+	up      string
+	down    string
+}
+
+// migrator применяет миграции на одном соединении: GET_LOCK действует в пределах соединения
+type migrator struct {
+	conn        *sql.Conn
+	migrations  []migration
+	lockTimeout time.Duration
+	out         io.Writer
+}
+
+// runMigrate выполняет подкоманду migrate и возвращает код выхода:
+//
+//	db_explorer migrate [-dir migrations] [-config file] [-dsn dsn] [-lock-timeout 10s] up [N] | down [N] | status | create NAME
+//
+// DSN берется так же, как для сервера: из файла конфига, DB_EXPLORER_DSN или флага
+func runMigrate(args []string, getenv func(string) string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: db_explorer migrate [flags] up [N] | down [N] | status | create NAME")
+		fs.PrintDefaults()
+	}
+	dir := fs.String("dir", "migrations", "directory with NNNN_name.up.sql and NNNN_name.down.sql files")
+	path := fs.String("config", getenv(envPrefix+"CONFIG"), "path to YAML config file")
+	dsn := fs.String("dsn", "", "MySQL DSN")
+	lockTimeout := fs.Duration("lock-timeout", 10*time.Second, "how long to wait for a concurrent migration run")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	command, commandArgs := fs.Arg(0), fs.Args()[1:]
+
+	if command == "create" {
+		if len(commandArgs) != 1 {
+			fs.Usage()
+			return 2
+		}
+		if err := createMigration(*dir, commandArgs[0], stdout); err != nil {
+			fmt.Fprintln(stderr, "migrate:", err)
+			return 1
+		}
+		return 0
+	}
+
+	steps := 0
+	switch command {
+	case "up", "down":
+		if len(commandArgs) > 1 {
+			fs.Usage()
+			return 2
+		}
+		if len(commandArgs) == 1 {
+			n, err := strconv.Atoi(commandArgs[0])
+			if err != nil || n <= 0 {
+				fmt.Fprintf(stderr, "migrate: %s: N must be a positive integer\n", command)
+				return 2
+			}
+			steps = n
+		} else if command == "down" {
+			steps = 1 // откатывать все миграции разом опасно, по умолчанию одна
+		}
+	case "status":
+		if len(commandArgs) != 0 {
+			fs.Usage()
+			return 2
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	config, err := commandConfig(*path, *dsn, getenv)
+	if err != nil {
+		fmt.Fprintln(stderr, "migrate:", err)
+		return 2
+	}
+
+	migrations, err := loadMigrations(*dir)
+	if err != nil {
+		fmt.Fprintln(stderr, "migrate:", err)
+		return 1
+	}
+
+	if err := migrate(context.Background(), config.DSN, migrations, command, steps, *lockTimeout, stdout); err != nil {
+		fmt.Fprintln(stderr, "migrate:", err)
+		return 1
+	}
+	return 0
+}
+
+// migrate подключается к базе, берет блокировку и выполняет команду up, down или status
+func migrate(ctx context.Context, dsn string, migrations []migration, command string, steps int, lockTimeout time.Duration, out io.Writer) error {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	m := &migrator{conn: conn, migrations: migrations, lockTimeout: lockTimeout, out: out}
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		return m.up(ctx, steps)
+	case "down":
+		return m.down(ctx, steps)
+	}
+	return m.status(ctx)
+}
+
+// loadMigrations читает каталог миграций и сортирует их по версии
+func loadMigrations(dir string) ([]migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid version", entry.Name())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		}
+		if m.name != match[2] {
+			return nil, fmt.Errorf("version %d used by %s and %s", version, m.name, match[2])
+		}
+		file := filepath.Join(dir, entry.Name())
+		if match[3] == "up" {
+			m.up = file
+		} else {
+			m.down = file
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// createMigration создает пустые файлы up и down со следующим номером версии
+func createMigration(dir, name string, out io.Writer) error {
+	name = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", "_"))
+	if !migrationName.MatchString(name) {
+		return fmt.Errorf("invalid migration name %q: use letters, digits and _", name)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	migrations, err := loadMigrations(dir)
+	if err != nil {
+		return err
+	}
+	version := int64(1)
+	if len(migrations) > 0 {
+		version = migrations[len(migrations)-1].version + 1
+	}
+
+	for _, direction := range []string{"up", "down"} {
+		file := filepath.Join(dir, fmt.Sprintf("%04d_%s.%s.sql", version, name, direction))
+		content := fmt.Sprintf("-- %04d_%s: %s\n", version, name, direction)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "created", file)
+	}
+	return nil
+}
+
+// lock берет именованную блокировку MySQL. Если ее держит другой запуск дольше lockTimeout - ошибка
+func (m *migrator) lock(ctx context.Context) error {
+	var acquired sql.NullInt64
+	err := m.conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLock, int(m.lockTimeout.Seconds())).Scan(&acquired)
+	if err != nil {
+		return err
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		return errors.New("another migration is running")
+	}
+	return nil
+}
+
+// unlock снимает блокировку. Она снимется и при закрытии соединения, но ждать этого незачем
+func (m *migrator) unlock() {
+	m.conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", migrationLock)
+}
+
+// ensureTable создает таблицу версий, если ее нет
+func (m *migrator) ensureTable(ctx context.Context) error {
+	_, err := m.conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS `"+migrationsTable+"` ("+
+		"`version` bigint NOT NULL, "+
+		"`name` varchar(255) NOT NULL, "+
+		"`applied_at` datetime NOT NULL, "+
+		"PRIMARY KEY (`version`))")
+	return err
+}
+
+// applied возвращает примененные версии и время применения
+func (m *migrator) applied(ctx context.Context) (map[int64]string, error) {
+	rows, err := m.conn.QueryContext(ctx, "SELECT `version`, `applied_at` FROM `"+migrationsTable+"`")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]string)
+	for rows.Next() {
+		var version int64
+		var at string
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// up применяет непримененные миграции по возрастанию версии. steps 0 - все
+func (m *migrator) up(ctx context.Context, steps int) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	done := 0
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.version]; ok {
+			continue
+		}
+		if steps > 0 && done == steps {
+			break
+		}
+		record := "INSERT INTO `" + migrationsTable + "` (`version`, `name`, `applied_at`) VALUES (?, ?, NOW())"
+		if err := m.run(ctx, migration, migration.up, record, migration.version, migration.name); err != nil {
+			return err
+		}
+		fmt.Fprintf(m.out, "applied %04d_%s\n", migration.version, migration.name)
+		done++
+	}
+	if done == 0 {
+		fmt.Fprintln(m.out, "no pending migrations")
+	}
+	return nil
+}
+
+// down откатывает steps последних примененных миграций
+func (m *migrator) down(ctx context.Context, steps int) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	done := 0
+	for i := len(m.migrations) - 1; i >= 0 && done < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.version]; !ok {
+			continue
+		}
+		if migration.down == "" {
+			return fmt.Errorf("migration %04d_%s has no down file", migration.version, migration.name)
+		}
+		record := "DELETE FROM `" + migrationsTable + "` WHERE `version` = ?"
+		if err := m.run(ctx, migration, migration.down, record, migration.version); err != nil {
+			return err
+		}
+		fmt.Fprintf(m.out, "rolled back %04d_%s\n", migration.version, migration.name)
+		done++
+	}
+	if done == 0 {
+		fmt.Fprintln(m.out, "no applied migrations")
+	}
+	return nil
+}
+
+// run выполняет файл миграции и отметку в таблице версий.
+// Если в файле нет DDL, все выполняется в одной транзакции. DDL в MySQL неявно завершает транзакцию,
+// поэтому такие файлы выполняются по одному запросу и при ошибке могут остаться примененными частично
+func (m *migrator) run(ctx context.Context, migration migration, file, record string, args ...interface{}) error {
+	script, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	statements := splitStatements(string(script))
+	name := fmt.Sprintf("%04d_%s", migration.version, migration.name)
+
+	transactional := true
+	for _, statement := range statements {
+		if isDDL(statement) {
+			transactional = false
+			break
+		}
+	}
+
+	if !transactional {
+		for i, statement := range statements {
+			if _, err := m.conn.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("%s: statement %d: %w (DDL is not transactional, previous statements stay applied)", name, i+1, err)
+			}
+		}
+		_, err := m.conn.ExecContext(ctx, record, args...)
+		return err
+	}
+
+	tx, err := m.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for i, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: statement %d: %w", name, i+1, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// status выводит все миграции с отметкой о применении
+func (m *migrator) status(ctx context.Context) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(m.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	known := make(map[int64]bool, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.version] = true
+		status, at := "pending", ""
+		if appliedAt, ok := applied[migration.version]; ok {
+			status, at = "applied", appliedAt
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", migration.version, migration.name, status, at)
+	}
+	// Версии из базы, файлов которых нет в каталоге
+	var missing []int64
+	for version := range applied {
+		if !known[version] {
+			missing = append(missing, version)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+	for _, version := range missing {
+		fmt.Fprintf(w, "%04d\t\tmissing\t%s\n", version, applied[version])
+	}
+	return w.Flush()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// databaseMux раздает запросы нескольким DbExplorer одного процесса.
+// /db/$name/... уходит в explorer базы name без префикса, остальные пути - в explorer базы по умолчанию.
+// У каждой базы свой кеш схемы, свои разрешенные таблицы, токен администратора, лимиты и метрики
+type databaseMux struct {
+	defaultHandler http.Handler
+	databases      map[string]http.Handler // имя базы -> explorer без префикса /db/$name
+	names          []string
+	codecs         *codecRegistry
+	closers        []http.Handler // исходные обработчики для Close
+}
+
+// NewMultiDbExplorer объединяет explorer базы по умолчанию и именованные explorer в один обработчик.
+// Чтобы GET / перечислял именованные базы, explorer по умолчанию создается с WithDatabases.
+// GET /db отдается в форматах explorer по умолчанию, включая добавленные через WithCodec
+func NewMultiDbExplorer(defaultHandler http.Handler, databases map[string]http.Handler) http.Handler {
+	mux := &databaseMux{
+		defaultHandler: defaultHandler,
+		databases:      make(map[string]http.Handler, len(databases)),
+		names:          make([]string, 0, len(databases)),
+		codecs:         newCodecRegistry(),
+		closers:        []http.Handler{defaultHandler},
+	}
+	if metrics, ok := defaultHandler.(*metricsMiddleware); ok {
+		mux.codecs = metrics.explorer.codecs
+		// Без WithDatabases таблица db осталась в схеме, но /db/... уходит в именованные базы
+		if metrics.explorer.tableExists("db") {
+			log.Printf("table db of the default database is unreachable: /db is the named databases route, create the explorer with WithDatabases")
+		}
+	}
+	for name, handler := range databases {
+		mux.databases[name] = http.StripPrefix("/db/"+name, handler)
+		mux.names = append(mux.names, name)
+		mux.closers = append(mux.closers, handler)
+	}
+	sort.Strings(mux.names)
+	return mux
+}
+
+// Close останавливает фоновую работу всех explorer
+func (mux *databaseMux) Close() error {
+	var problems []error
+	for _, handler := range mux.closers {
+		problems = append(problems, closeHandler(handler))
+	}
+	return errors.Join(problems...)
+}
+
+// closeHandler вызывает Close у обработчика, если он его поддерживает
+func closeHandler(handler http.Handler) error {
+	if closer, ok := handler.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (mux *databaseMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 3)
+	if parts[0] != "db" {
+		mux.defaultHandler.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			writeError(w, errUnknownRoute())
+			return
+		}
+		writeNegotiated(w, r, mux.codecs, Response{
+			Response: map[string]interface{}{
+				"databases": mux.names,
+			},
+		})
+		return
+	}
+
+	handler, ok := mux.databases[parts[1]]
+	if !ok {
+		writeError(w, errUnknownDatabase())
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Option настраивает DbExplorer при создании: NewDbExplorer(db, WithSoftDelete("users", "deleted_at"))
+// Опции применяются до загрузки схемы, проверяются после нее
+type Option func(explorer *DbExplorer)
+
+// WithSoftDelete включает мягкое удаление для таблицы:
+// DELETE проставляет column = NOW() вместо удаления строки.
+// Колонка должна существовать и допускать NULL
+func WithSoftDelete(table, column string) Option {
+	return func(explorer *DbExplorer) {
+		explorer.softDelete[table] = column
+	}
+}
+
+// WithReplicas добавляет реплики для чтения. db, переданная в NewDbExplorer, становится primary:
+// GET-запросы идут в здоровые реплики по кругу, запись - в primary
+func WithReplicas(replicas ...*sql.DB) Option {
+	return func(explorer *DbExplorer) {
+		explorer.replicas = append(explorer.replicas, replicas...)
+	}
+}
+
+// WithStickyWindow задает, сколько времени после записи чтение того же клиента идет в primary.
+// 0 отключает привязку
+func WithStickyWindow(window time.Duration) Option {
+	return func(explorer *DbExplorer) {
+		explorer.stickyWindow = window
+	}
+}
+
+// WithReplicaHealthCheck задает период проверки реплик
+func WithReplicaHealthCheck(interval time.Duration) Option {
+	return func(explorer *DbExplorer) {
+		explorer.healthInterval = interval
+	}
+}
+
+// WithQueryTimeout задает таймаут одного запроса к базе. 0 отключает таймаут.
+// Запрос, не уложившийся в таймаут, отдается клиенту как 504
+func WithQueryTimeout(timeout time.Duration) Option {
+	return func(explorer *DbExplorer) {
+		explorer.queryTimeout = timeout
+	}
+}
+
+// WithMaxLimit задает максимальный limit выборки, больший limit уменьшается до него.
+// 0 снимает ограничение
+func WithMaxLimit(limit int) Option {
+	return func(explorer *DbExplorer) {
+		explorer.maxLimit = limit
+	}
+}
+
+// WithRateLimit задает лимиты по умолчанию для каждой таблицы: отдельно на чтение (GET) и на запись.
+// Клиент определяется по X-API-Key, а без него - по IP. Бюджет Burst <= 0 означает отсутствие лимита
+func WithRateLimit(read, write RateLimit) Option {
+	return func(explorer *DbExplorer) {
+		explorer.rateLimiter.defaults = &tableRateLimits{read: read, write: write}
+	}
+}
+
+// WithTableRateLimit задает лимиты для конкретной таблицы вместо лимитов по умолчанию
+func WithTableRateLimit(table string, read, write RateLimit) Option {
+	return func(explorer *DbExplorer) {
+		explorer.rateLimiter.tables[table] = tableRateLimits{read: read, write: write}
+	}
+}
+
+// WithTables ограничивает набор таблиц, доступных через API: REST, GraphQL и список таблиц.
+// Пустой allow - доступны все таблицы, кроме deny. Таблицы из allow должны существовать
+func WithTables(allow, deny []string) Option {
+	return func(explorer *DbExplorer) {
+		explorer.allowTables = allow
+		explorer.denyTables = deny
+	}
+}
+
+// WithProcedures ограничивает набор хранимых процедур, доступных через /_rpc.
+// Пустой allow - доступны все процедуры, кроме deny. Процедуры из allow должны существовать
+func WithProcedures(allow, deny []string) Option {
+	return func(explorer *DbExplorer) {
+		explorer.allowProcedures = allow
+		explorer.denyProcedures = deny
+	}
+}
+
+// WithQueries добавляет именованные SQL-запросы, доступные через GET /_queries/$name.
+// Запросы проверяются в NewDbExplorer: некорректный запрос - ошибка конструктора
+func WithQueries(queries map[string]NamedQuery) Option {
+	return func(explorer *DbExplorer) {
+		explorer.namedQueries = queries
+	}
+}
+
+// WithDefaultLimit задает limit выборки, если клиент его не передал
+func WithDefaultLimit(limit int) Option {
+	return func(explorer *DbExplorer) {
+		explorer.defaultLimit = limit
+	}
+}
+
+// WithBlobs ограничивает размер значения двоичной колонки, загружаемого через PUT /$table/$id/$column
+// и JSON, и задает колонки с типом содержимого: "table.column" -> колонка той же таблицы.
+// maxSize <= 0 оставляет ограничение по умолчанию 16 МБ
+func WithBlobs(maxSize int, contentTypes map[string]string) Option {
+	return func(explorer *DbExplorer) {
+		if maxSize > 0 {
+			explorer.maxBlobSize = maxSize
+		}
+		explorer.blobContentTypes = contentTypes
+	}
+}
+
+// WithCodec добавляет формат ответов и тел запросов или заменяет встроенный с тем же Content-Type
+func WithCodec(codec Codec) Option {
+	return func(explorer *DbExplorer) {
+		explorer.codecs.register(codec)
+	}
+}
+
+// WithCache включает кеш ответов на чтение записи и списков: не больше maxEntries записей
+// суммарным размером до maxBytes, каждая живет ttl. Записи таблицы сбрасываются при изменении
+// таблицы через explorer. ttl <= 0 выключает кеш
+func WithCache(ttl time.Duration, maxEntries, maxBytes int) Option {
+	return func(explorer *DbExplorer) {
+		explorer.cache = nil
+		if ttl > 0 {
+			explorer.cache = newResponseCache(ttl, maxEntries, maxBytes)
+		}
+	}
+}
+
+// WithDatabases перечисляет именованные базы того же процесса (см. NewMultiDbExplorer),
+// они отдаются в GET / вместе со списком таблиц
+func WithDatabases(names ...string) Option {
+	return func(explorer *DbExplorer) {
+		explorer.databases = names
+	}
+}
+
+// WithAdminToken включает DDL-эндпоинты /_admin/...: запросы к ним должны передавать
+// заголовок Authorization: Bearer <token>. Без токена эндпоинты отключены
+func WithAdminToken(token string) Option {
+	return func(explorer *DbExplorer) {
+		explorer.adminToken = token
+	}
+}
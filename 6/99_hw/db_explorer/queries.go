@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NamedQuery именованный SQL-запрос из конфига, доступный через GET /_queries/$name.
+// Параметры подставляются в SQL как :name и передаются плейсхолдерами
+type NamedQuery struct {
+	SQL    string                `yaml:"sql" json:"-"`
+	Params map[string]QueryParam `yaml:"params" json:"params"`
+}
+
+// QueryParam параметр именованного запроса. Type - integer, float, string, boolean, date или datetime.
+// Необязательный параметр без Default передается как NULL
+type QueryParam struct {
+	Type     string  `yaml:"type" json:"type"`
+	Required bool    `yaml:"required" json:"required"`
+	Default  *string `yaml:"default" json:"default,omitempty"`
+}
+
+// compiledQuery именованный запрос с плейсхолдерами вместо :name
+type compiledQuery struct {
+	NamedQuery
+	query string   // SQL с ?
+	args  []string // имена параметров по порядку плейсхолдеров, имя может повторяться
+}
+
+// compileQuery проверяет запрос и заменяет :name на плейсхолдеры.
+// Запрос должен быть одним SELECT, все параметры в SQL объявлены, все объявленные используются
+func compileQuery(query NamedQuery) (*compiledQuery, error) {
+	statements := splitStatements(query.SQL)
+	if len(statements) != 1 {
+		return nil, fmt.Errorf("sql: must be a single statement")
+	}
+	if fields := statementFields(statements[0]); len(fields) == 0 || (fields[0] != "select" && fields[0] != "with") {
+		return nil, fmt.Errorf("sql: must be a SELECT")
+	}
+
+	for name, param := range query.Params {
+		if !isIdentifier(name) {
+			return nil, fmt.Errorf("params: invalid name %q", name)
+		}
+		switch name {
+		case "limit", "offset", "count":
+			return nil, fmt.Errorf("params: %s is reserved for paging", name)
+		}
+		if !validQueryParamType(param.Type) {
+			return nil, fmt.Errorf("params.%s.type: unknown type %q", name, param.Type)
+		}
+		if param.Default != nil {
+			if _, err := parseQueryParam(*param.Default, param.Type); err != nil {
+				return nil, fmt.Errorf("params.%s.default: %v", name, err)
+			}
+		}
+	}
+
+	compiled := &compiledQuery{NamedQuery: query}
+	compiled.query, compiled.args = bindNamedParams(statements[0])
+	used := make(map[string]bool, len(compiled.args))
+	for _, name := range compiled.args {
+		if _, ok := query.Params[name]; !ok {
+			return nil, fmt.Errorf("sql: parameter :%s is not declared in params", name)
+		}
+		used[name] = true
+	}
+	for _, name := range sortedKeys(query.Params) {
+		if !used[name] {
+			return nil, fmt.Errorf("params.%s: not used in sql", name)
+		}
+	}
+	return compiled, nil
+}
+
+// bindNamedParams заменяет :name вне строк, идентификаторов в обратных кавычках и комментариев на ?
+// и возвращает имена по порядку. Обычные комментарии splitStatements уже убрал
+func bindNamedParams(query string) (string, []string) {
+	var out strings.Builder
+	var names []string
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+			for end < len(query) && query[end] != c {
+				if query[end] == '\\' && c != '`' {
+					end++
+				}
+				end++
+			}
+			if end >= len(query) {
+				end = len(query) - 1
+			}
+			out.WriteString(query[i : end+1])
+			i = end
+
+		case c == ':' && i+1 < len(query) && isIdentStart(query[i+1]) && (i == 0 || query[i-1] != ':'):
+			end := i + 1
+			for end < len(query) && (isIdentStart(query[end]) || (query[end] >= '0' && query[end] <= '9')) {
+				end++
+			}
+			names = append(names, query[i+1:end])
+			out.WriteByte('?')
+			i = end - 1
+
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), names
+}
+
+// isIdentStart проверяет, может ли символ начинать имя параметра
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// validQueryParamType проверяет тип параметра именованного запроса
+func validQueryParamType(typ string) bool {
+	switch typ {
+	case "integer", "float", "string", "boolean", "date", "datetime":
+		return true
+	}
+	return false
+}
+
+// parseQueryParam приводит значение из query-строки к типу параметра
+func parseQueryParam(value, typ string) (interface{}, error) {
+	switch typ {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", value)
+		}
+		return n, nil
+	case "float":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", value)
+		}
+		return f, nil
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", value)
+		}
+		return b, nil
+	case "date":
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return nil, fmt.Errorf("%q is not a date YYYY-MM-DD", value)
+		}
+	case "datetime":
+		if _, err := time.Parse("2006-01-02 15:04:05", value); err != nil {
+			return nil, fmt.Errorf("%q is not a datetime YYYY-MM-DD HH:MM:SS", value)
+		}
+	}
+	return value, nil
+}
+
+// compileQueries проверяет все именованные запросы, ошибка называет запрос
+func compileQueries(queries map[string]NamedQuery) (map[string]*compiledQuery, error) {
+	compiled := make(map[string]*compiledQuery, len(queries))
+	for _, name := range sortedKeys(queries) {
+		if !isIdentifier(name) {
+			return nil, fmt.Errorf("queries: invalid name %q", name)
+		}
+		query, err := compileQuery(queries[name])
+		if err != nil {
+			return nil, fmt.Errorf("queries.%s.%v", name, err)
+		}
+		compiled[name] = query
+	}
+	return compiled, nil
+}
+
+// handleQueries обрабатывает запрос GET /_queries - отдает именованные запросы с параметрами, без SQL
+func (explorer *DbExplorer) handleQueries(w http.ResponseWriter, r *http.Request) {
+	queries := make(map[string]NamedQuery, len(explorer.queries))
+	for name, query := range explorer.queries {
+		queries[name] = query.NamedQuery
+	}
+	explorer.writeResponse(w, r, Response{
+		Response: map[string]interface{}{
+			"queries": queries,
+		},
+	})
+}
+
+// handleNamedQuery обрабатывает запрос GET /_queries/$name?param=...&limit=&offset=&count=exact.
+// Параметры проверяются по типам из конфига, запрос выполняется в транзакции READ ONLY
+// на реплике, если она есть. limit, offset и count работают так же, как у списка записей таблицы
+func (explorer *DbExplorer) handleNamedQuery(w http.ResponseWriter, r *http.Request, name string) {
+	query, ok := explorer.queries[name]
+	if !ok {
+		writeError(w, errUnknownQuery())
+		return
+	}
+
+	args, err := query.bind(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	limit := explorer.defaultLimit
+	offset := 0
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil {
+		limit = l
+	}
+	if o, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil {
+		offset = o
+	}
+	limit = explorer.capLimit(limit)
+	countMode := r.URL.Query().Get("count")
+	if countMode != "" && countMode != "exact" {
+		writeError(w, errBadRequest("invalid count"))
+		return
+	}
+
+	ctx, cancel := explorer.queryContext(r.Context())
+	defer cancel()
+	tx, err := explorer.router.reader(r.Context()).BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		writeError(w, statementError(ctx, err))
+		return
+	}
+	defer tx.Rollback()
+
+	result := make(map[string]interface{})
+	if countMode != "" {
+		var total int64
+		countQuery := fmt.Sprintf("SELECT COUNT(*) FROM (%s) AS q", query.query)
+		if err := explorer.queryTx(ctx, tx, countQuery, args, func(rows *sql.Rows) error {
+			return rows.Scan(&total)
+		}); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+		result["total"] = total
+	}
+
+	records := make([]map[string]interface{}, 0)
+	pageQuery := fmt.Sprintf("SELECT * FROM (%s) AS q LIMIT ? OFFSET ?", query.query)
+	if err := explorer.queryTx(ctx, tx, pageQuery, append(args, limit, offset), func(rows *sql.Rows) error {
+		record, err := explorer.rowToMap(rows)
+		records = append(records, record)
+		return err
+	}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result["records"] = records
+	explorer.writeResponse(w, r, Response{
+		Response: result,
+	})
+}
+
+// bind собирает аргументы запроса по порядку плейсхолдеров.
+// Ошибка - validation_failed со всеми невалидными параметрами
+func (query *compiledQuery) bind(values url.Values) ([]interface{}, error) {
+	parsed := make(map[string]interface{}, len(query.Params))
+	details := make([]FieldError, 0)
+	for name, param := range query.Params {
+		value, exists := values[name]
+		switch {
+		case exists:
+			v, err := parseQueryParam(value[0], param.Type)
+			if err != nil {
+				details = append(details, FieldError{Field: name, Code: fieldInvalidType,
+					Message: fmt.Sprintf("field %s: %v", name, err)})
+				continue
+			}
+			parsed[name] = v
+		case param.Required:
+			details = append(details, FieldError{Field: name, Code: fieldRequired,
+				Message: fmt.Sprintf("field %s is required", name)})
+		case param.Default != nil:
+			parsed[name], _ = parseQueryParam(*param.Default, param.Type)
+		default:
+			parsed[name] = nil
+		}
+	}
+	if len(details) > 0 {
+		return nil, errValidation(details)
+	}
+
+	args := make([]interface{}, len(query.args))
+	for i, name := range query.args {
+		args[i] = parsed[name]
+	}
+	return args, nil
+}
+
+// queryTx выполняет запрос в транзакции и вызывает scan для каждой строки
+func (explorer *DbExplorer) queryTx(ctx context.Context, tx *sql.Tx, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	defer explorer.metrics.observeQuery(query, time.Now())
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return statementError(ctx, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return statementError(ctx, err)
+		}
+	}
+	return statementError(ctx, rows.Err())
+}
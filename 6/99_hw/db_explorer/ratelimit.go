@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit бюджет token bucket: Rate токенов в секунду, накапливается не больше Burst.
+// Каждый запрос тратит один токен
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// tableRateLimits отдельные бюджеты на чтение и запись
+type tableRateLimits struct {
+	read  RateLimit
+	write RateLimit
+}
+
+// tokenBucket состояние бюджета одного клиента
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter ограничивает частоту запросов клиента.
+// Бюджет считается отдельно для каждой пары клиент-таблица и отдельно для чтения и записи.
+// Лимиты таблицы берутся из tables, если их там нет - из defaults
+type rateLimiter struct {
+	defaults *tableRateLimits
+	tables   map[string]tableRateLimits
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// newRateLimiter создает ограничитель без лимитов
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		tables:  make(map[string]tableRateLimits),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// enabled проверяет, задан ли хотя бы один лимит
+func (limiter *rateLimiter) enabled() bool {
+	return limiter.defaults != nil || len(limiter.tables) > 0
+}
+
+// limitFor возвращает лимит для таблицы и вида запроса
+func (limiter *rateLimiter) limitFor(table string, write bool) (RateLimit, bool) {
+	limits, ok := limiter.tables[table]
+	if !ok {
+		if limiter.defaults == nil {
+			return RateLimit{}, false
+		}
+		limits = *limiter.defaults
+	}
+	if write {
+		return limits.write, true
+	}
+	return limits.read, true
+}
+
+// allow списывает токен из бюджета клиента.
+// Возвращает лимит, остаток токенов и, если токенов нет, через сколько появится следующий
+func (limiter *rateLimiter) allow(client, table string, write bool, now time.Time) (limit RateLimit, remaining int, retryAfter time.Duration, ok bool) {
+	limit, ok = limiter.limitFor(table, write)
+	if !ok || limit.Burst <= 0 {
+		return limit, 0, 0, true
+	}
+
+	kind := "read"
+	if write {
+		kind = "write"
+	}
+	key := client + "|" + table + "|" + kind
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	bucket, exists := limiter.buckets[key]
+	if !exists {
+		limiter.cleanup(now)
+		bucket = &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		limiter.buckets[key] = bucket
+	}
+
+	// Пополняем бюджет за прошедшее время
+	bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		if limit.Rate <= 0 {
+			return limit, 0, time.Hour, false
+		}
+		retryAfter = time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+		return limit, 0, retryAfter, false
+	}
+	bucket.tokens--
+	return limit, int(bucket.tokens), 0, true
+}
+
+// cleanup удаляет бюджеты, которые успели полностью восстановиться: они ничем не отличаются от новых.
+// Вызывается под мьютексом при добавлении бюджета, когда их становится много
+func (limiter *rateLimiter) cleanup(now time.Time) {
+	if len(limiter.buckets) < 4096 {
+		return
+	}
+	for key, bucket := range limiter.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.limit.Rate >= float64(bucket.limit.Burst) {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+// checkRateLimit проверяет лимит и выставляет заголовки X-RateLimit-*.
+// При превышении отвечает 429 с Retry-After и возвращает false
+func (explorer *DbExplorer) checkRateLimit(w http.ResponseWriter, r *http.Request, table string) bool {
+	if !explorer.rateLimiter.enabled() {
+		return true
+	}
+
+	write := r.Method != http.MethodGet && r.Method != http.MethodHead
+	limit, remaining, retryAfter, ok := explorer.rateLimiter.allow(clientKey(r), table, write, time.Now())
+	if limit.Burst <= 0 {
+		return true
+	}
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if ok {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeError(w, newAPIError(http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded"))
+	return false
+}
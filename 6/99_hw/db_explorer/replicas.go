@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// clientKeyCtx ключ контекста запроса, под которым лежит идентификатор клиента
+type clientKeyCtx struct{}
+
+// primaryReadCtx ключ контекста запроса, который отправляет чтение в primary
+type primaryReadCtx struct{}
+
+// replica - подключение к реплике и результат последней проверки здоровья
+type replica struct {
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// dbRouter распределяет запросы между основной базой и репликами:
+//   - запись всегда идет в primary
+//   - чтение идет по кругу в здоровые реплики, а если их нет - в primary
+//   - чтение клиента в течение stickyWindow после его записи идет в primary,
+//     чтобы клиент видел свои изменения несмотря на отставание реплик
+type dbRouter struct {
+	primary      *sql.DB
+	replicas     []*replica
+	next         atomic.Uint64
+	stickyWindow time.Duration
+
+	mu         sync.Mutex
+	lastWrites map[string]time.Time // клиент -> время последней записи
+
+	stop     chan struct{} // закрывается в close и останавливает watch
+	stopOnce sync.Once
+}
+
+// newDbRouter создает роутер. Все реплики считаются здоровыми до первой проверки
+func newDbRouter(primary *sql.DB, replicas []*sql.DB, stickyWindow time.Duration) *dbRouter {
+	router := &dbRouter{
+		primary:      primary,
+		stickyWindow: stickyWindow,
+		lastWrites:   make(map[string]time.Time),
+		stop:         make(chan struct{}),
+	}
+	for _, db := range replicas {
+		r := &replica{db: db}
+		r.healthy.Store(true)
+		router.replicas = append(router.replicas, r)
+	}
+	return router
+}
+
+// reader возвращает подключение для чтения
+func (router *dbRouter) reader(ctx context.Context) *sql.DB {
+	if primary, _ := ctx.Value(primaryReadCtx{}).(bool); primary {
+		return router.primary
+	}
+	if len(router.replicas) == 0 || router.recentlyWrote(ctx) {
+		return router.primary
+	}
+
+	// Перебираем реплики начиная со следующей по кругу, пропуская нездоровые
+	start := router.next.Add(1) - 1
+	for i := 0; i < len(router.replicas); i++ {
+		r := router.replicas[(start+uint64(i))%uint64(len(router.replicas))]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+	return router.primary
+}
+
+// writer возвращает primary и запоминает время записи клиента
+func (router *dbRouter) writer(ctx context.Context) *sql.DB {
+	if len(router.replicas) == 0 || router.stickyWindow <= 0 {
+		return router.primary
+	}
+
+	client, _ := ctx.Value(clientKeyCtx{}).(string)
+	now := time.Now()
+
+	router.mu.Lock()
+	router.lastWrites[client] = now
+	// Старые отметки больше не влияют на выбор, чистим их, чтобы map не росла бесконечно
+	if len(router.lastWrites) > 1024 {
+		for key, at := range router.lastWrites {
+			if now.Sub(at) > router.stickyWindow {
+				delete(router.lastWrites, key)
+			}
+		}
+	}
+	router.mu.Unlock()
+
+	return router.primary
+}
+
+// recentlyWrote проверяет, писал ли клиент в течение stickyWindow
+func (router *dbRouter) recentlyWrote(ctx context.Context) bool {
+	client, _ := ctx.Value(clientKeyCtx{}).(string)
+
+	router.mu.Lock()
+	at, ok := router.lastWrites[client]
+	router.mu.Unlock()
+
+	return ok && time.Since(at) < router.stickyWindow
+}
+
+// watch периодически пингует реплики и помечает недоступные. Работает до вызова close
+func (router *dbRouter) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			router.checkReplicas(interval)
+		case <-router.stop:
+			return
+		}
+	}
+}
+
+// close останавливает проверку реплик. Повторный вызов ничего не делает
+func (router *dbRouter) close() {
+	router.stopOnce.Do(func() { close(router.stop) })
+}
+
+// checkReplicas пингует каждую реплику с таймаутом
+func (router *dbRouter) checkReplicas(timeout time.Duration) {
+	for _, r := range router.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		r.healthy.Store(r.db.PingContext(ctx) == nil)
+		cancel()
+	}
+}
+
+// clientKey идентифицирует клиента по API-ключу из X-API-Key, а без него - по IP.
+// По нему считаются лимиты частоты и привязка чтения к primary после записи
+func clientKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return "key:" + key
+	}
+	return "ip:" + clientIP(r)
+}
+
+// clientIP возвращает IP-адрес клиента без порта
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
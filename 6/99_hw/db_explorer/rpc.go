@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Procedure хранимая процедура, доступная через POST /_rpc/$name
+type Procedure struct {
+	Name   string           `json:"name"`
+	Params []ProcedureParam `json:"params"`
+}
+
+// ProcedureParam параметр процедуры. Mode - IN, OUT или INOUT, Type - тип как в DTD_IDENTIFIER
+type ProcedureParam struct {
+	Name string `json:"name"`
+	Mode string `json:"mode"`
+	Type string `json:"type"`
+}
+
+// loadProcedures читает хранимые процедуры текущей базы и их параметры из information_schema.
+// Процедуры фильтруются WithProcedures так же, как таблицы WithTables
+func (explorer *DbExplorer) loadProcedures() error {
+	rows, err := explorer.db.Query(`SELECT ROUTINE_NAME FROM information_schema.ROUTINES
+		WHERE ROUTINE_SCHEMA = DATABASE() AND ROUTINE_TYPE = 'PROCEDURE'
+		ORDER BY ROUTINE_NAME`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	names, err = filterNames("procedure", names, explorer.allowProcedures, explorer.denyProcedures)
+	if err != nil {
+		return err
+	}
+	explorer.procedures = make(map[string]Procedure, len(names))
+	for _, name := range names {
+		explorer.procedures[name] = Procedure{Name: name, Params: []ProcedureParam{}}
+	}
+
+	// ORDINAL_POSITION 0 - возвращаемое значение функции, у процедур его нет
+	rows, err = explorer.db.Query(`SELECT SPECIFIC_NAME, PARAMETER_MODE, PARAMETER_NAME, DTD_IDENTIFIER
+		FROM information_schema.PARAMETERS
+		WHERE SPECIFIC_SCHEMA = DATABASE() AND ROUTINE_TYPE = 'PROCEDURE' AND ORDINAL_POSITION > 0
+		ORDER BY SPECIFIC_NAME, ORDINAL_POSITION`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var param ProcedureParam
+		if err := rows.Scan(&name, &param.Mode, &param.Name, &param.Type); err != nil {
+			return err
+		}
+		procedure, ok := explorer.procedures[name]
+		if !ok {
+			continue
+		}
+		procedure.Params = append(procedure.Params, param)
+		explorer.procedures[name] = procedure
+	}
+	return rows.Err()
+}
+
+// handleProcedures обрабатывает запрос GET /_rpc - отдает доступные процедуры с параметрами
+func (explorer *DbExplorer) handleProcedures(w http.ResponseWriter, r *http.Request) {
+	procedures := make([]Procedure, 0, len(explorer.procedures))
+	for _, name := range sortedKeys(explorer.procedures) {
+		procedures = append(procedures, explorer.procedures[name])
+	}
+	explorer.writeResponse(w, r, Response{
+		Response: map[string]interface{}{
+			"procedures": procedures,
+		},
+	})
+}
+
+// handleRPC обрабатывает запрос POST /_rpc/$procedure - вызывает хранимую процедуру.
+// Тело - JSON-объект с IN и INOUT параметрами по именам, ответ - все наборы строк процедуры
+// и значения OUT и INOUT параметров:
+//
+//	{"response": {"result_sets": [[{...}, ...], ...], "out": {"total": 3}}}
+//
+// Процедура может изменить любые таблицы, поэтому после вызова кеш ответов сбрасывается целиком
+func (explorer *DbExplorer) handleRPC(w http.ResponseWriter, r *http.Request, name string) {
+	procedure, ok := explorer.procedures[name]
+	if !ok {
+		writeError(w, errUnknownProcedure())
+		return
+	}
+
+	params := make(map[string]ColumnInfo, len(procedure.Params))
+	for _, param := range procedure.Params {
+		params[param.Name] = ColumnInfo{Type: param.Type, Nullable: true}
+	}
+	args, err := explorer.decodeBody(r, params, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	call, values, err := explorer.buildCall(procedure, args)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ctx, cancel := explorer.queryContext(r.Context())
+	defer cancel()
+	resultSets, out, err := explorer.callProcedure(ctx, explorer.router.writer(r.Context()), procedure, call, values)
+	explorer.cache.clear()
+	if err != nil {
+		writeError(w, rpcError(err))
+		return
+	}
+
+	explorer.writeResponse(w, r, Response{
+		Response: map[string]interface{}{
+			"result_sets": resultSets,
+			"out":         out,
+		},
+	})
+}
+
+// buildCall проверяет аргументы и формирует CALL. IN параметры передаются плейсхолдерами,
+// OUT и INOUT - через переменные сессии @_rpc_N, номер - позиция параметра.
+// Ошибка - validation_failed со всеми невалидными аргументами
+func (explorer *DbExplorer) buildCall(procedure Procedure, args map[string]interface{}) (string, []interface{}, error) {
+	details := make([]FieldError, 0)
+	known := make(map[string]bool, len(procedure.Params))
+	placeholders := make([]string, len(procedure.Params))
+	values := make([]interface{}, 0, len(procedure.Params))
+
+	for i, param := range procedure.Params {
+		known[param.Name] = true
+		value, exists := args[param.Name]
+
+		if param.Mode == "OUT" {
+			placeholders[i] = fmt.Sprintf("@_rpc_%d", i+1)
+			if exists {
+				details = append(details, FieldError{Field: param.Name, Code: fieldReadOnly,
+					Message: fmt.Sprintf("field %s is an OUT parameter", param.Name)})
+			}
+			continue
+		}
+		if !exists {
+			details = append(details, FieldError{Field: param.Name, Code: fieldRequired,
+				Message: fmt.Sprintf("field %s is required", param.Name)})
+			continue
+		}
+		if err := explorer.validateValue(value, ColumnInfo{Type: param.Type, Nullable: true}, param.Name); err != nil {
+			details = append(details, FieldError{Field: param.Name, Code: fieldInvalidType, Message: err.Error()})
+			continue
+		}
+
+		values = append(values, value)
+		placeholders[i] = "?"
+		if param.Mode == "INOUT" {
+			placeholders[i] = fmt.Sprintf("@_rpc_%d", i+1)
+		}
+	}
+	for arg := range args {
+		if !known[arg] {
+			details = append(details, FieldError{Field: arg, Code: fieldInvalidValue,
+				Message: fmt.Sprintf("field %s is not a parameter of %s", arg, procedure.Name)})
+		}
+	}
+
+	if len(details) > 0 {
+		return "", nil, errValidation(details)
+	}
+	return fmt.Sprintf("CALL `%s`(%s)", procedure.Name, strings.Join(placeholders, ", ")), values, nil
+}
+
+// callProcedure выполняет CALL на одном соединении: переменные OUT и INOUT параметров живут в сессии.
+// values - значения IN и INOUT параметров в порядке следования, INOUT записываются в переменные до вызова
+func (explorer *DbExplorer) callProcedure(ctx context.Context, db *sql.DB, procedure Procedure, call string, values []interface{}) ([][]map[string]interface{}, map[string]interface{}, error) {
+	defer explorer.metrics.observeQuery(call, time.Now())
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, statementError(ctx, err)
+	}
+	defer conn.Close()
+
+	// Переменные сессии переживают запрос, поэтому OUT сбрасываются: соединение могло остаться от прошлого вызова
+	callValues := make([]interface{}, 0, len(values))
+	outputs := make([]string, 0)
+	next := 0
+	for i, param := range procedure.Params {
+		variable := fmt.Sprintf("@_rpc_%d", i+1)
+		output := fmt.Sprintf("%s AS `%s`", variable, strings.ReplaceAll(param.Name, "`", "``"))
+		switch param.Mode {
+		case "OUT":
+			if _, err := conn.ExecContext(ctx, "SET "+variable+" = NULL"); err != nil {
+				return nil, nil, statementError(ctx, err)
+			}
+			outputs = append(outputs, output)
+		case "INOUT":
+			if _, err := conn.ExecContext(ctx, "SET "+variable+" = ?", values[next]); err != nil {
+				return nil, nil, statementError(ctx, err)
+			}
+			next++
+			outputs = append(outputs, output)
+		default:
+			callValues = append(callValues, values[next])
+			next++
+		}
+	}
+
+	rows, err := conn.QueryContext(ctx, call, callValues...)
+	if err != nil {
+		return nil, nil, statementError(ctx, err)
+	}
+	defer rows.Close()
+
+	resultSets := make([][]map[string]interface{}, 0)
+	for {
+		// Последний "набор" CALL - статус выполнения без колонок
+		if columns, _ := rows.Columns(); len(columns) > 0 {
+			records := make([]map[string]interface{}, 0)
+			for rows.Next() {
+				record, err := explorer.rowToMap(rows)
+				if err != nil {
+					return nil, nil, statementError(ctx, err)
+				}
+				records = append(records, record)
+			}
+			resultSets = append(resultSets, records)
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, statementError(ctx, err)
+	}
+	rows.Close()
+
+	out := make(map[string]interface{})
+	if len(outputs) == 0 {
+		return resultSets, out, nil
+	}
+	outRows, err := conn.QueryContext(ctx, "SELECT "+strings.Join(outputs, ", "))
+	if err != nil {
+		return nil, nil, statementError(ctx, err)
+	}
+	defer outRows.Close()
+	if outRows.Next() {
+		if out, err = explorer.rowToMap(outRows); err != nil {
+			return nil, nil, statementError(ctx, err)
+		}
+	}
+	return resultSets, out, statementError(ctx, outRows.Err())
+}
+
+// rpcError отдает клиенту текст SIGNAL из процедуры как 400: это ошибка, которую автор процедуры
+// написал для вызывающего. Остальные ошибки обрабатываются как обычно
+func rpcError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlSignalException {
+		return errBadRequest(mysqlErr.Message)
+	}
+	return err
+}
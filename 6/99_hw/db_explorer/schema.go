@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Index индекс таблицы. Columns в порядке следования в индексе
+type Index struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+}
+
+// schemaColumn описание колонки в ответе /$table/_schema
+type schemaColumn struct {
+	Name          string  `json:"name"`
+	SQLType       string  `json:"sql_type"`
+	Type          string  `json:"type"`
+	Nullable      bool    `json:"nullable"`
+	Default       *string `json:"default"`
+	Key           string  `json:"key"`
+	AutoIncrement bool    `json:"auto_increment"`
+}
+
+// loadIndexes читает индексы текущей базы из information_schema
+func (explorer *DbExplorer) loadIndexes() error {
+	rows, err := explorer.db.Query(`SELECT TABLE_NAME, INDEX_NAME, NON_UNIQUE, COLUMN_NAME
+		FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE()
+		ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, name, column string
+		var nonUnique int
+		if err := rows.Scan(&table, &name, &nonUnique, &column); err != nil {
+			return err
+		}
+		if !explorer.tableExists(table) {
+			continue
+		}
+		// Строки одного индекса идут подряд, колонки добавляются к последнему индексу
+		indexes := explorer.indexes[table]
+		if n := len(indexes); n > 0 && indexes[n-1].Name == name {
+			indexes[n-1].Columns = append(indexes[n-1].Columns, column)
+			continue
+		}
+		explorer.indexes[table] = append(indexes, Index{Name: name, Columns: []string{column}, Unique: nonUnique == 0})
+	}
+	return rows.Err()
+}
+
+// handleSchema обрабатывает запрос GET /$table/_schema - отдает колонки и индексы таблицы из кеша
+func (explorer *DbExplorer) handleSchema(w http.ResponseWriter, r *http.Request, table string) {
+	if !explorer.tableExists(table) {
+		writeError(w, errUnknownTable())
+		return
+	}
+
+	columns := make([]schemaColumn, 0, len(explorer.columns[table]))
+	for name, info := range explorer.columns[table] {
+		columns = append(columns, schemaColumn{
+			Name:          name,
+			SQLType:       info.Type,
+			Type:          normalizeType(info.Type),
+			Nullable:      info.Nullable,
+			Default:       info.Default,
+			Key:           keyKind(info.Key),
+			AutoIncrement: info.AutoIncrement,
+		})
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		return explorer.columns[table][columns[i].Name].Position < explorer.columns[table][columns[j].Name].Position
+	})
+
+	indexes := explorer.indexes[table]
+	if indexes == nil {
+		indexes = []Index{}
+	}
+
+	result := map[string]interface{}{
+		"table":       table,
+		"primary_key": explorer.primaryKey[table],
+		"columns":     columns,
+		"indexes":     indexes,
+	}
+	if explorer.views[table] {
+		result["view"] = true
+	}
+	explorer.writeResponse(w, r, Response{
+		Response: result,
+	})
+}
+
+// normalizeType сводит SQL-тип к небольшому набору типов, не зависящему от диалекта:
+// integer, float, decimal, string, date, datetime, time, json, binary или other
+func normalizeType(sqlType string) string {
+	base := strings.ToLower(sqlType)
+	if i := strings.IndexAny(base, "( "); i >= 0 {
+		base = base[:i]
+	}
+
+	switch base {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "year", "bit":
+		return "integer"
+	case "float", "double", "real":
+		return "float"
+	case "decimal", "numeric":
+		return "decimal"
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext", "enum", "set":
+		return "string"
+	case "date":
+		return "date"
+	case "datetime", "timestamp":
+		return "datetime"
+	case "time":
+		return "time"
+	case "json":
+		return "json"
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return "binary"
+	}
+	return "other"
+}
+
+// keyKind переводит колонку Key из SHOW COLUMNS в понятное клиенту значение
+func keyKind(key string) string {
+	switch key {
+	case "PRI":
+		return "primary"
+	case "UNI":
+		return "unique"
+	case "MUL":
+		return "index"
+	}
+	return ""
+}
+
+// columnDefault значение по умолчанию из SHOW COLUMNS.
+// Некоторые серверы отдают строковые значения в кавычках, а DEFAULT NULL - строкой NULL,
+// приводим к виду MySQL
+func columnDefault(def sql.NullString) *string {
+	if !def.Valid || def.String == "NULL" {
+		return nil
+	}
+	value := def.String
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	}
+	return &value
+}
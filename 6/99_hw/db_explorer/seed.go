@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultSeedCount строк генерируется, если count не передан
+	defaultSeedCount = 100
+	// maxSeedCount ограничивает размер одного запроса на генерацию
+	maxSeedCount = 10000
+	// seedBatchSize строк в одном INSERT
+	seedBatchSize = 100
+	// seedParentSample сколько строк родительской таблицы берется для внешних ключей
+	seedParentSample = 1000
+	// seedWords словарь для строк и текстов
+	seedWords = "alpha bravo delta echo golf hotel india kilo lima mike oscar papa quebec romeo sierra tango " +
+		"victor whiskey yankee zulu amber cedar maple river stone cloud ember frost harbor meadow orbit " +
+		"pixel quartz raven signal timber vector willow anchor beacon canyon"
+	// seedFirstNames имена для колонок с именами и логинами
+	seedFirstNames = "anna boris vera gleb daria egor zoya ivan kira lev maria nikita olga pavel rita semen " +
+		"tanya fedor yana artem"
+)
+
+// seedColumn колонка, для которой генерируются значения
+type seedColumn struct {
+	name       string
+	info       ColumnInfo
+	references bool          // колонка - внешний ключ
+	parents    []interface{} // значения родительской таблицы для внешнего ключа
+	next       int64         // следующее значение для уникальной целой колонки без auto_increment
+}
+
+// seedGenerator генерирует правдоподобные значения колонок по типу, длине и имени.
+// Все случайные значения берутся из rng, поэтому при одном seed и одинаковых данных в базе результат повторяется
+type seedGenerator struct {
+	rng        *rand.Rand
+	words      []string
+	firstNames []string
+}
+
+// handleSeed обрабатывает запрос POST /$table/_seed?count=1000&seed=42 - заполняет таблицу сгенерированными строками.
+// Значения подбираются по типу, длине и имени колонки, внешние ключи ссылаются на существующие строки родительских таблиц.
+// С одним seed генерируются одни и те же данные, seed без параметра случайный и возвращается в ответе.
+// Строки вставляются в одной транзакции: при ошибке таблица не меняется
+func (explorer *DbExplorer) handleSeed(w http.ResponseWriter, r *http.Request, table string) {
+	if !explorer.tableExists(table) {
+		writeError(w, errUnknownTable())
+		return
+	}
+
+	count := defaultSeedCount
+	if param := r.URL.Query().Get("count"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n <= 0 || n > maxSeedCount {
+			writeError(w, errBadRequest(fmt.Sprintf("count must be between 1 and %d", maxSeedCount)))
+			return
+		}
+		count = n
+	}
+	seed := time.Now().UnixNano()
+	if param := r.URL.Query().Get("seed"); param != "" {
+		n, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			writeError(w, errBadRequest("invalid seed"))
+			return
+		}
+		seed = n
+	}
+
+	db := explorer.router.writer(r.Context())
+	columns, err := explorer.seedColumns(r.Context(), db, table)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	generator := &seedGenerator{
+		rng:        rand.New(rand.NewSource(seed)),
+		words:      strings.Fields(seedWords),
+		firstNames: strings.Fields(seedFirstNames),
+	}
+	err = explorer.insertSeed(r.Context(), db, table, columns, generator, count)
+	explorer.invalidateCache(table)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	explorer.writeResponse(w, r, Response{
+		Response: map[string]interface{}{
+			"inserted": count,
+			"seed":     seed,
+		},
+	})
+}
+
+// seedColumns возвращает колонки таблицы в порядке следования, для которых нужно генерировать значения.
+// auto_increment пропускаются, для внешних ключей загружаются значения родителей,
+// для уникальных целых колонок - следующее свободное значение
+func (explorer *DbExplorer) seedColumns(ctx context.Context, db *sql.DB, table string) ([]*seedColumn, error) {
+	references := make(map[string]ForeignKey)
+	for _, fk := range explorer.foreignKeys[table] {
+		references[fk.Column] = fk
+	}
+
+	columns := make([]*seedColumn, 0, len(explorer.columns[table]))
+	for name, info := range explorer.columns[table] {
+		if info.AutoIncrement {
+			continue
+		}
+		column := &seedColumn{name: name, info: info}
+
+		if fk, ok := references[name]; ok {
+			parents, err := explorer.seedParents(ctx, db, fk)
+			if err != nil {
+				return nil, err
+			}
+			if len(parents) == 0 && !info.Nullable {
+				return nil, newAPIError(http.StatusConflict, codeConflict,
+					fmt.Sprintf("column %s references empty table %s", name, fk.RefTable))
+			}
+			column.references, column.parents = true, parents
+		} else if (info.Key == "PRI" || info.Key == "UNI") && normalizeType(info.Type) == "integer" {
+			next, err := explorer.seedNextValue(ctx, db, table, name)
+			if err != nil {
+				return nil, err
+			}
+			column.next = next
+		} else if normalizeType(info.Type) == "other" && info.Default == nil && !info.Nullable {
+			return nil, errBadRequest(fmt.Sprintf("cannot generate values for column %s of type %s", name, info.Type))
+		}
+		columns = append(columns, column)
+	}
+
+	// Порядок обхода map случаен, а от порядка колонок зависит последовательность значений rng
+	sort.Slice(columns, func(i, j int) bool {
+		return columns[i].info.Position < columns[j].info.Position
+	})
+	return columns, nil
+}
+
+// seedParents возвращает значения, на которые может ссылаться внешний ключ, в стабильном порядке
+func (explorer *DbExplorer) seedParents(ctx context.Context, db *sql.DB, fk ForeignKey) ([]interface{}, error) {
+	ctx, cancel := explorer.queryContext(ctx)
+	defer cancel()
+	query := fmt.Sprintf("SELECT DISTINCT `%s` FROM `%s` WHERE `%s` IS NOT NULL ORDER BY `%s` LIMIT %d",
+		fk.RefColumn, fk.RefTable, fk.RefColumn, fk.RefColumn, seedParentSample)
+	defer explorer.metrics.observeQuery(query, time.Now())
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, statementError(ctx, err)
+	}
+	defer rows.Close()
+
+	parents := make([]interface{}, 0)
+	for rows.Next() {
+		var value interface{}
+		if err := rows.Scan(&value); err != nil {
+			return nil, statementError(ctx, err)
+		}
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		parents = append(parents, value)
+	}
+	return parents, statementError(ctx, rows.Err())
+}
+
+// seedNextValue возвращает значение, следующее за максимальным в колонке
+func (explorer *DbExplorer) seedNextValue(ctx context.Context, db *sql.DB, table, column string) (int64, error) {
+	ctx, cancel := explorer.queryContext(ctx)
+	defer cancel()
+	query := fmt.Sprintf("SELECT COALESCE(MAX(`%s`), 0) + 1 FROM `%s`", column, table)
+	defer explorer.metrics.observeQuery(query, time.Now())
+
+	var next int64
+	if err := db.QueryRowContext(ctx, query).Scan(&next); err != nil {
+		return 0, statementError(ctx, err)
+	}
+	return next, nil
+}
+
+// insertSeed генерирует count строк и вставляет их пачками по seedBatchSize в одной транзакции
+func (explorer *DbExplorer) insertSeed(ctx context.Context, db *sql.DB, table string, columns []*seedColumn, generator *seedGenerator, count int) error {
+	names := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = fmt.Sprintf("`%s`", column.name)
+		placeholders[i] = "?"
+	}
+	row := "(" + strings.Join(placeholders, ", ") + ")"
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < count; start += seedBatchSize {
+		size := seedBatchSize
+		if count-start < size {
+			size = count - start
+		}
+
+		rows := make([]string, size)
+		values := make([]interface{}, 0, size*len(columns))
+		for i := range rows {
+			rows[i] = row
+			for _, column := range columns {
+				value, err := generator.value(column, explorer.softDelete[table] == column.name)
+				if err != nil {
+					return err
+				}
+				values = append(values, value)
+			}
+		}
+
+		query := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES %s", table, strings.Join(names, ", "), strings.Join(rows, ", "))
+		if err := explorer.execSeedBatch(ctx, tx, query, values); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// execSeedBatch выполняет один INSERT генерации с таймаутом запроса
+func (explorer *DbExplorer) execSeedBatch(ctx context.Context, tx *sql.Tx, query string, values []interface{}) error {
+	ctx, cancel := explorer.queryContext(ctx)
+	defer cancel()
+	defer explorer.metrics.observeQuery(query, time.Now())
+
+	if _, err := tx.ExecContext(ctx, query, values...); err != nil {
+		return statementError(ctx, err)
+	}
+	return nil
+}
+
+// value генерирует значение колонки. Колонка мягкого удаления всегда NULL, чтобы строки были видны,
+// необязательные колонки примерно в каждой десятой строке NULL
+func (g *seedGenerator) value(column *seedColumn, softDelete bool) (interface{}, error) {
+	info := column.info
+	if softDelete {
+		return nil, nil
+	}
+	if column.references {
+		// Пустой список родителей допустим только для необязательной колонки, проверено в seedColumns
+		if len(column.parents) == 0 || (info.Nullable && g.rng.Intn(10) == 0) {
+			return nil, nil
+		}
+		return column.parents[g.rng.Intn(len(column.parents))], nil
+	}
+	if column.next > 0 {
+		column.next++
+		return column.next - 1, nil
+	}
+
+	unique := info.Key == "PRI" || info.Key == "UNI"
+	if info.Nullable && !unique && g.rng.Intn(10) == 0 {
+		return nil, nil
+	}
+
+	base, params := parseSQLType(info.Type)
+	switch normalizeType(info.Type) {
+	case "integer":
+		return g.integer(base, params, column.name), nil
+	case "float":
+		return float64(g.rng.Intn(100000)) / 100, nil
+	case "decimal":
+		return g.decimal(params), nil
+	case "string":
+		if base == "enum" || base == "set" {
+			if len(params) == 0 {
+				return "", nil
+			}
+			return params[g.rng.Intn(len(params))], nil
+		}
+		length := 0
+		if len(params) > 0 {
+			length, _ = strconv.Atoi(params[0])
+		} else if base == "tinytext" {
+			length = 255
+		}
+		return g.text(column.name, base, length, unique), nil
+	case "date":
+		return g.time().Format("2006-01-02"), nil
+	case "datetime":
+		return g.time().Format("2006-01-02 15:04:05"), nil
+	case "time":
+		return g.time().Format("15:04:05"), nil
+	case "json":
+		data, _ := json.Marshal(map[string]interface{}{
+			g.word(): g.word(),
+			"n":      g.rng.Intn(1000),
+		})
+		return string(data), nil
+	case "binary":
+		length := 16
+		if len(params) > 0 {
+			if n, err := strconv.Atoi(params[0]); err == nil && (base == "binary" || n < length) {
+				length = n
+			}
+		}
+		data := make([]byte, length)
+		g.rng.Read(data)
+		return data, nil
+	}
+
+	// Для неизвестных типов полагаемся на значение по умолчанию, проверено в seedColumns
+	if info.Default != nil {
+		return *info.Default, nil
+	}
+	return nil, nil
+}
+
+// integer генерирует целое в диапазоне, подходящем типу: tinyint(1) и bit - флаг, year - год
+func (g *seedGenerator) integer(base string, params []string, name string) int64 {
+	switch {
+	case base == "bit" || (base == "tinyint" && len(params) > 0 && params[0] == "1"):
+		return int64(g.rng.Intn(2))
+	case base == "year":
+		return int64(1990 + g.rng.Intn(36))
+	case strings.Contains(strings.ToLower(name), "age"):
+		return int64(18 + g.rng.Intn(60))
+	case base == "tinyint":
+		return int64(g.rng.Intn(100))
+	case base == "smallint":
+		return int64(g.rng.Intn(10000))
+	}
+	return int64(g.rng.Intn(100000))
+}
+
+// decimal генерирует число, помещающееся в decimal(M,D)
+func (g *seedGenerator) decimal(params []string) string {
+	precision, scale := 10, 0
+	if len(params) > 0 {
+		precision, _ = strconv.Atoi(params[0])
+	}
+	if len(params) > 1 {
+		scale, _ = strconv.Atoi(params[1])
+	}
+	digits := precision - scale
+	if digits > 6 {
+		digits = 6
+	}
+
+	integer := "0"
+	if digits > 0 {
+		integer = strconv.Itoa(g.rng.Intn(pow10(digits)))
+	}
+	if scale == 0 {
+		return integer
+	}
+	fraction := make([]byte, scale)
+	for i := range fraction {
+		fraction[i] = byte('0' + g.rng.Intn(10))
+	}
+	return integer + "." + string(fraction)
+}
+
+// text генерирует строку по имени колонки: email, имя, логин, ссылку, телефон, пароль, заголовок или текст.
+// Уникальные колонки получают случайный суффикс, результат обрезается до length символов
+func (g *seedGenerator) text(name, base string, length int, unique bool) string {
+	name = strings.ToLower(name)
+	var value string
+	switch {
+	case strings.Contains(name, "email"):
+		value = fmt.Sprintf("%s.%s%d@example.com", g.firstName(), g.word(), g.rng.Intn(1000))
+	case strings.Contains(name, "login") || strings.Contains(name, "user") || strings.Contains(name, "nick"):
+		value = fmt.Sprintf("%s%d", g.firstName(), g.rng.Intn(1000))
+	case strings.Contains(name, "name"):
+		value = capitalize(g.firstName())
+	case strings.Contains(name, "url") || strings.Contains(name, "link"):
+		value = fmt.Sprintf("https://example.com/%s/%s", g.word(), g.word())
+	case strings.Contains(name, "phone"):
+		value = fmt.Sprintf("+7 9%02d %03d-%02d-%02d", g.rng.Intn(100), g.rng.Intn(1000), g.rng.Intn(100), g.rng.Intn(100))
+	case strings.Contains(name, "password"):
+		value = g.token(12)
+	case strings.Contains(base, "text"):
+		value = g.sentence(8 + g.rng.Intn(24))
+	case strings.Contains(name, "title"):
+		value = capitalize(g.sentence(2 + g.rng.Intn(4)))
+	default:
+		value = g.sentence(1 + g.rng.Intn(3))
+	}
+
+	if unique {
+		suffix := "-" + g.token(8)
+		if length > 0 && len([]rune(value))+len(suffix) > length {
+			value = truncateRunes(value, length-len(suffix))
+		}
+		value += suffix
+	}
+	if length > 0 {
+		value = truncateRunes(value, length)
+	}
+	return value
+}
+
+// time генерирует момент времени в 2020-2024 годах, не зависящий от текущей даты
+func (g *seedGenerator) time() time.Time {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	return start.Add(time.Duration(g.rng.Int63n(5*365*24*3600)) * time.Second)
+}
+
+// sentence склеивает n слов из словаря
+func (g *seedGenerator) sentence(n int) string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = g.word()
+	}
+	return strings.Join(words, " ")
+}
+
+// word случайное слово из словаря
+func (g *seedGenerator) word() string {
+	return g.words[g.rng.Intn(len(g.words))]
+}
+
+// firstName случайное имя
+func (g *seedGenerator) firstName() string {
+	return g.firstNames[g.rng.Intn(len(g.firstNames))]
+}
+
+// token случайная строка из n строчных букв и цифр
+func (g *seedGenerator) token(n int) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = alphabet[g.rng.Intn(len(alphabet))]
+	}
+	return string(b)
+}
+
+// parseSQLType разбирает тип из SHOW COLUMNS на имя и параметры в скобках:
+// varchar(255) -> varchar, [255]; decimal(10,2) -> decimal, [10 2]; enum('a','b') -> enum, [a b]
+func parseSQLType(sqlType string) (string, []string) {
+	sqlType = strings.TrimSpace(sqlType)
+	open := strings.IndexByte(sqlType, '(')
+	if open < 0 {
+		base := strings.ToLower(sqlType)
+		if i := strings.IndexByte(base, ' '); i >= 0 {
+			base = base[:i]
+		}
+		return base, nil
+	}
+	base := strings.ToLower(strings.TrimSpace(sqlType[:open]))
+	end := strings.LastIndexByte(sqlType, ')')
+	if end < open {
+		end = len(sqlType)
+	}
+
+	// Значения enum могут содержать запятые и удвоенные кавычки
+	var params []string
+	var current strings.Builder
+	quoted := false
+	inner := sqlType[open+1 : end]
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case c == '\'' && quoted && i+1 < len(inner) && inner[i+1] == '\'':
+			current.WriteByte(c)
+			i++
+		case c == '\'':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			params = append(params, strings.TrimSpace(current.String()))
+			current.Reset()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	params = append(params, strings.TrimSpace(current.String()))
+	return base, params
+}
+
+// truncateRunes обрезает строку до n символов, не разрывая многобайтовые символы
+func truncateRunes(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
+
+// capitalize делает заглавной первую букву слова из словаря
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// pow10 возвращает 10 в степени n
+func pow10(n int) int {
+	result := 1
+	for i := 0; i < n; i++ {
+		result *= 10
+	}
+	return result
+}
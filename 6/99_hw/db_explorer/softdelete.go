@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// validateSoftDelete проверяет настройки мягкого удаления по закешированной схеме
+func (explorer *DbExplorer) validateSoftDelete() error {
+	for table, column := range explorer.softDelete {
+		if !explorer.tableExists(table) {
+			return fmt.Errorf("soft delete: unknown table %s", table)
+		}
+		info, ok := explorer.columns[table][column]
+		if !ok {
+			return fmt.Errorf("soft delete: unknown column %s.%s", table, column)
+		}
+		if !info.Nullable {
+			return fmt.Errorf("soft delete: column %s.%s must be nullable", table, column)
+		}
+		if explorer.primaryKey[table] == "" {
+			return fmt.Errorf("soft delete: table %s has no primary key", table)
+		}
+	}
+	return nil
+}
+
+// handleRestore обрабатывает запрос POST /$table/$id/_restore - снимает отметку об удалении
+func (explorer *DbExplorer) handleRestore(w http.ResponseWriter, r *http.Request, table, id string) {
+	if !explorer.tableExists(table) {
+		writeError(w, errUnknownTable())
+		return
+	}
+
+	column, ok := explorer.softDelete[table]
+	if !ok {
+		writeError(w, errBadRequest("soft delete is not enabled"))
+		return
+	}
+
+	query := fmt.Sprintf("UPDATE `%s` SET `%s` = NULL WHERE `%s` = ? AND `%s` IS NOT NULL",
+		table, column, explorer.primaryKey[table], column)
+	result, err := explorer.exec(r.Context(), explorer.router.writer(r.Context()), query, id)
+	explorer.invalidateCache(table)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	affected, _ := result.RowsAffected()
+	explorer.writeResponse(w, r, Response{
+		Response: map[string]interface{}{
+			"restored": affected,
+		},
+	})
+}
+
+// softDeleteCondition возвращает условие, скрывающее мягко удаленные строки,
+// или пустую строку, если для таблицы мягкое удаление не включено либо удаленные строки запрошены явно
+func (explorer *DbExplorer) softDeleteCondition(table string, includeDeleted bool) string {
+	column, ok := explorer.softDelete[table]
+	if !ok || includeDeleted {
+		return ""
+	}
+	return fmt.Sprintf("`%s` IS NULL", column)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+)
+
+// splitStatements разбивает SQL-скрипт на отдельные запросы по ";".
+// Точка с запятой внутри строк, идентификаторов в обратных кавычках и комментариев не разделяет запросы.
+// Комментарии (--, #, /* */) отбрасываются, пустые запросы пропускаются. Исполняемые комментарии /*! */
+// остаются: MySQL выполняет их содержимое, в дампах так пишут SET и опции таблиц.
+// Нужно потому, что драйвер без multiStatements выполняет только один запрос за вызов
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+
+	flush := func() {
+		if statement := strings.TrimSpace(current.String()); statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// Строка или идентификатор целиком, с учетом экранирования \x и удвоения кавычки
+			end := i + 1
+			for end < len(script) {
+				if script[end] == '\\' && c != '`' {
+					end += 2
+					continue
+				}
+				if script[end] == c {
+					if end+1 < len(script) && script[end+1] == c {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			if end >= len(script) {
+				end = len(script) - 1
+			}
+			current.WriteString(script[i : end+1])
+			i = end
+
+		case c == '#' || isLineComment(script[i:]):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+				continue
+			}
+			i += end
+			current.WriteByte('\n')
+
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+				continue
+			}
+			if strings.HasPrefix(script[i:], "/*!") {
+				current.WriteString(script[i : i+end+4])
+			} else {
+				current.WriteByte(' ')
+			}
+			i += end + 3
+
+		case c == ';':
+			flush()
+
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return statements
+}
+
+// isLineComment проверяет, начинается ли текст с комментария "--".
+// В MySQL после "--" обязателен пробельный символ, иначе это два минуса
+func isLineComment(text string) bool {
+	if !strings.HasPrefix(text, "--") {
+		return false
+	}
+	return len(text) == 2 || text[2] == ' ' || text[2] == '\t' || text[2] == '\n' || text[2] == '\r'
+}
+
+// statementFields разбивает запрос на слова в нижнем регистре, чтобы определить его вид.
+// Исполняемые комментарии /*!40101 ... */ раскрываются: их содержимое MySQL выполняет как обычный текст.
+// Строки заменяются пустыми: слова внутри них не влияют на вид запроса
+func statementFields(statement string) []string {
+	var out strings.Builder
+	inExecutable := false
+	for i := 0; i < len(statement); i++ {
+		c := statement[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+			for end < len(statement) && statement[end] != c {
+				if statement[end] == '\\' && c != '`' {
+					end++
+				}
+				end++
+			}
+			if end >= len(statement) {
+				end = len(statement) - 1
+			}
+			if c == '`' {
+				out.WriteString(statement[i : end+1])
+			} else {
+				out.WriteString(string([]byte{c, c}))
+			}
+			i = end
+
+		case strings.HasPrefix(statement[i:], "/*!"):
+			// Номер версии после /*! не часть запроса
+			i += 3
+			for i < len(statement) && statement[i] >= '0' && statement[i] <= '9' {
+				i++
+			}
+			i--
+			inExecutable = true
+			out.WriteByte(' ')
+
+		case inExecutable && strings.HasPrefix(statement[i:], "*/"):
+			i++
+			inExecutable = false
+			out.WriteByte(' ')
+
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return strings.Fields(strings.ToLower(out.String()))
+}
+
+// isDDL проверяет, начинается ли запрос с DDL. В MySQL DDL неявно завершает транзакцию,
+// поэтому скрипты с ним нельзя откатить целиком
+func isDDL(statement string) bool {
+	fields := statementFields(statement)
+	if len(fields) == 0 {
+		return false
+	}
+	switch fields[0] {
+	case "create", "alter", "drop", "rename", "truncate":
+		return true
+	}
+	return false
+}
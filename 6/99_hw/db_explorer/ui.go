@@ -0,0 +1,44 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// uiFiles статика веб-интерфейса: страница работает только через JSON API explorer
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// handleUI отдает веб-интерфейс на GET /_ui/. Возвращает false для остальных путей.
+// Под /db/$name путь приходит уже без префикса, поэтому ссылки и редирект только относительные
+func handleUI(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path != "_ui" && !strings.HasPrefix(path, "_ui/") {
+		return false
+	}
+
+	// Без завершающего слэша относительные ссылки страницы указывали бы мимо /_ui/
+	if path == "_ui" {
+		w.Header().Set("Location", "_ui/")
+		w.WriteHeader(http.StatusFound)
+		return true
+	}
+
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		writeError(w, err)
+		return true
+	}
+	// databaseMux уже выставил application/json, тип файла определяет FileServer
+	w.Header().Del("Content-Type")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.StripPrefix("/_ui", http.FileServer(http.FS(files))).ServeHTTP(w, r)
+	return true
+}
@@ -0,0 +1,402 @@
+// Админка db_explorer: работает только через JSON API того же explorer.
+// Маршруты в hash: #/table?offset=0&limit=25, #/table/id, #/table/_new
+"use strict";
+
+// API explorer лежит уровнем выше /_ui, в том числе под /db/$name
+const base = location.pathname.replace(/\/_ui(\/.*)?$/, "");
+const pageSizes = [10, 25, 50, 100];
+const schemas = {};
+
+// el создает элемент: атрибуты и обработчики on* из attrs, дети - строки или элементы
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [name, value] of Object.entries(attrs || {})) {
+    if (name.startsWith("on")) {
+      node.addEventListener(name.slice(2), value);
+    } else if (value === true) {
+      node.setAttribute(name, "");
+    } else if (value !== false && value !== null && value !== undefined) {
+      node.setAttribute(name, value);
+    }
+  }
+  for (const child of children.flat()) {
+    if (child !== null && child !== undefined) {
+      node.append(child);
+    }
+  }
+  return node;
+}
+
+// api выполняет запрос к explorer и возвращает {data, headers}. Ошибка API бросается с полями ответа
+async function api(method, path, body, contentType) {
+  const options = {method, headers: {Accept: "application/json"}};
+  if (body !== undefined) {
+    options.body = contentType ? body : JSON.stringify(body);
+    options.headers["Content-Type"] = contentType || "application/json";
+  }
+  const resp = await fetch(base + path, options);
+  const data = await resp.json().catch(() => ({}));
+  if (!resp.ok) {
+    const err = new Error(data.error || resp.statusText);
+    err.code = data.code;
+    err.details = data.details || [];
+    throw err;
+  }
+  return {data: data.response, headers: resp.headers};
+}
+
+function showError(err) {
+  const box = document.getElementById("error");
+  box.replaceChildren();
+  if (!err) {
+    box.hidden = true;
+    return;
+  }
+  box.append(err.message);
+  if (err.details && err.details.length) {
+    box.append(el("ul", {}, err.details.map((d) => el("li", {}, `${d.field}: ${d.message}`))));
+  }
+  box.hidden = false;
+}
+
+function render(...nodes) {
+  document.getElementById("content").replaceChildren(...nodes);
+}
+
+async function schema(table) {
+  if (!schemas[table]) {
+    schemas[table] = (await api("GET", `/${enc(table)}/_schema`)).data;
+  }
+  return schemas[table];
+}
+
+function enc(value) {
+  return encodeURIComponent(value);
+}
+
+async function loadSidebar() {
+  const {data} = await api("GET", "/");
+  const views = new Set(data.views || []);
+  const link = (name) => el("li", {}, el("a", {href: `#/${enc(name)}`, "data-table": name}, name));
+  document.getElementById("tables").replaceChildren(...data.tables.filter((t) => !views.has(t)).map(link));
+  document.getElementById("views").replaceChildren(...[...views].map(link));
+  document.getElementById("views-title").hidden = views.size === 0;
+}
+
+function markActive(table) {
+  for (const a of document.querySelectorAll("#sidebar a[data-table]")) {
+    a.classList.toggle("active", a.dataset.table === table);
+  }
+}
+
+// showTable - список записей с постраничным выводом и фильтром по колонке
+async function showTable(table, params) {
+  const info = await schema(table);
+  const limit = Number(params.get("limit")) || pageSizes[1];
+  const offset = Math.max(Number(params.get("offset")) || 0, 0);
+
+  const query = new URLSearchParams(params);
+  query.set("limit", limit);
+  query.set("offset", offset);
+  query.set("count", "exact");
+  const {data} = await api("GET", `/${enc(table)}?${query}`);
+  const total = data.total;
+
+  const go = (changes) => {
+    const next = new URLSearchParams(params);
+    for (const [name, value] of Object.entries(changes)) {
+      if (value === "" || value === null) {
+        next.delete(name);
+      } else {
+        next.set(name, value);
+      }
+    }
+    location.hash = `#/${enc(table)}?${next}`;
+  };
+
+  const filterColumn = el("select", {}, info.columns.filter((c) => c.type !== "binary").map((c) => el("option", {value: c.name}, c.name)));
+  const filterValue = el("input", {placeholder: "значение"});
+  const filters = [...params.entries()].filter(([name]) => info.columns.some((c) => c.name === name));
+  const navigable = !info.view && info.primary_key;
+
+  const rows = data.records.map((record) => el("tr", {
+    class: navigable ? "link" : null,
+    onclick: navigable ? () => { location.hash = `#/${enc(table)}/${enc(record[info.primary_key])}`; } : null,
+  }, info.columns.map((c) => cell(record, c))));
+
+  render(
+    el("h2", {}, table, info.view ? el("span", {class: "muted"}, " (представление, только чтение)") : null),
+    el("div", {class: "toolbar"},
+      info.view ? null : el("a", {href: `#/${enc(table)}/_new`}, el("button", {type: "button"}, "Новая запись")),
+      filterColumn, filterValue,
+      el("button", {type: "button", onclick: () => go({[filterColumn.value]: filterValue.value, offset: 0})}, "Фильтр"),
+      filters.map(([name, value]) => el("button", {type: "button", title: "Убрать фильтр", onclick: () => go({[name]: "", offset: 0})}, `${name} = ${value} ✕`)),
+    ),
+    el("table", {class: "records"},
+      el("thead", {}, el("tr", {}, info.columns.map((c) => el("th", {title: c.sql_type}, c.name)))),
+      el("tbody", {}, rows),
+    ),
+    el("div", {class: "toolbar"},
+      el("button", {type: "button", disabled: offset === 0, onclick: () => go({offset: Math.max(offset - limit, 0)})}, "← Назад"),
+      el("span", {}, total === 0 ? "нет записей" : `${offset + 1}–${offset + data.records.length} из ${total}`),
+      el("button", {type: "button", disabled: offset + limit >= total, onclick: () => go({offset: offset + limit})}, "Вперед →"),
+      el("select", {onchange: (e) => go({limit: e.target.value, offset: 0})},
+        pageSizes.map((size) => el("option", {value: size, selected: size === limit}, `${size} на странице`))),
+    ),
+  );
+}
+
+function cell(record, column) {
+  if (!(column.name in record)) {
+    return el("td", {class: "null"}, column.type === "binary" ? "двоичные данные" : "");
+  }
+  const value = record[column.name];
+  if (value === null) {
+    return el("td", {class: "null"}, "NULL");
+  }
+  return el("td", {title: String(value)}, String(value));
+}
+
+// showRecord - форма записи. Для новой записи id не задан
+async function showRecord(table, id) {
+  const info = await schema(table);
+  if (info.view) {
+    // Представления только для чтения, форма редактирования для них не строится
+    location.hash = `#/${enc(table)}`;
+    return;
+  }
+  const record = id === undefined ? {} : (await api("GET", `/${enc(table)}/${enc(id)}`)).data.record;
+  const fields = info.columns.map((column) => field(table, info, column, record, id === undefined));
+  const listLink = `#/${enc(table)}`;
+
+  const form = el("form", {class: "record", onsubmit: (e) => { e.preventDefault(); save(); }},
+    fields.map((f) => [f.label, f.input, f.extra]),
+    el("div", {class: "actions"},
+      el("button", {type: "submit"}, id === undefined ? "Создать" : "Сохранить"),
+      id === undefined ? null : el("button", {type: "button", class: "danger", onclick: remove}, "Удалить"),
+      el("a", {href: listLink}, el("button", {type: "button"}, "К списку")),
+    ),
+  );
+
+  async function save() {
+    const body = {};
+    for (const f of fields) {
+      f.input.classList.remove("invalid");
+      if (f.changed()) {
+        body[f.column.name] = f.value();
+      }
+    }
+    try {
+      showError(null);
+      if (id === undefined) {
+        const {data} = await api("PUT", `/${enc(table)}/`, body);
+        location.hash = `#/${enc(table)}/${enc(data[info.primary_key])}`;
+        return;
+      }
+      if (Object.keys(body).length) {
+        await api("POST", `/${enc(table)}/${enc(id)}`, body);
+      }
+      for (const f of fields) {
+        if (f.file && f.file.files.length) {
+          const file = f.file.files[0];
+          await api("PUT", `/${enc(table)}/${enc(id)}/${enc(f.column.name)}`, file, file.type || "application/octet-stream");
+        }
+      }
+      showRecord(table, id);
+    } catch (err) {
+      showError(err);
+      for (const detail of err.details || []) {
+        const f = fields.find((f) => f.column.name === detail.field);
+        if (f) {
+          f.input.classList.add("invalid");
+        }
+      }
+    }
+  }
+
+  async function remove() {
+    if (!confirm(`Удалить запись ${id} из ${table}?`)) {
+      return;
+    }
+    try {
+      await api("DELETE", `/${enc(table)}/${enc(id)}`);
+      location.hash = listLink;
+    } catch (err) {
+      showError(err);
+    }
+  }
+
+  render(el("h2", {}, id === undefined ? `${table}: новая запись` : `${table} #${id}`), form);
+}
+
+// field строит поле формы по типу колонки: input, признак изменения и значение для API
+function field(table, info, column, record, creating) {
+  const original = column.name in record ? record[column.name] : null;
+  const readOnly = column.auto_increment || (!creating && column.key === "primary");
+  const label = el("label", {for: `f-${column.name}`}, column.name, el("small", {}, column.sql_type));
+  const result = {column, label, extra: el("span")};
+
+  if (column.type === "binary") {
+    return binaryField(result, `${base}/${enc(table)}/${enc(record[info.primary_key])}/${enc(column.name)}`, record, creating);
+  }
+
+  const input = inputFor(column);
+  input.id = `f-${column.name}`;
+  input.disabled = readOnly;
+  setInput(input, column, original);
+  result.input = input;
+
+  let isNull = null;
+  if (column.nullable && !readOnly) {
+    isNull = el("input", {type: "checkbox", checked: creating ? false : original === null,
+      onchange: () => { input.disabled = isNull.checked; }});
+    input.disabled = isNull.checked;
+    result.extra = el("label", {class: "muted"}, isNull, " NULL");
+  }
+
+  result.value = () => (isNull && isNull.checked ? null : readInput(input, column));
+  result.changed = () => {
+    if (readOnly) {
+      return false;
+    }
+    if (creating) {
+      return (isNull && isNull.checked) || input.value !== "" || input.type === "checkbox";
+    }
+    return JSON.stringify(result.value()) !== JSON.stringify(normalize(original, column));
+  };
+  return result;
+}
+
+// binaryField - поле загрузки файла и ссылка на скачивание текущего значения
+function binaryField(result, download, record, creating) {
+  const column = result.column;
+  const file = el("input", {type: "file", id: `f-${column.name}`});
+  result.input = file;
+  result.file = creating ? null : file;
+  if (!creating && record[column.name] !== null) {
+    // BLOB-колонки в запись не попадают, ссылка ведет на /$table/$id/$column
+    result.extra = el("a", {href: download, target: "_blank"}, "скачать");
+  }
+
+  // Новая запись отправляет файл в JSON строкой base64, существующая - отдельным PUT после сохранения
+  let encoded = null;
+  file.addEventListener("change", () => {
+    encoded = null;
+    if (!creating || !file.files.length) {
+      return;
+    }
+    const reader = new FileReader();
+    reader.onload = () => { encoded = reader.result.split(",", 2)[1] || ""; };
+    reader.readAsDataURL(file.files[0]);
+  });
+  result.changed = () => creating && encoded !== null;
+  result.value = () => encoded;
+  return result;
+}
+
+function inputFor(column) {
+  const type = column.sql_type.toLowerCase();
+  switch (column.type) {
+    case "integer":
+      if (type.startsWith("tinyint(1)")) {
+        return el("input", {type: "checkbox"});
+      }
+      return el("input", {type: "number", step: "1"});
+    case "float":
+    case "decimal":
+      return el("input", {type: "number", step: "any"});
+    case "date":
+      return el("input", {type: "date"});
+    case "datetime":
+      return el("input", {type: "datetime-local", step: "1"});
+    case "time":
+      return el("input", {type: "time", step: "1"});
+    case "json":
+      return el("textarea");
+    case "string": {
+      if (type.startsWith("enum(")) {
+        return el("select", {}, enumValues(column.sql_type).map((v) => el("option", {value: v}, v)));
+      }
+      if (type.includes("text")) {
+        return el("textarea");
+      }
+      const length = /\((\d+)\)/.exec(type);
+      return el("input", {type: "text", maxlength: length ? length[1] : null});
+    }
+  }
+  return el("input", {type: "text"});
+}
+
+// enumValues разбирает значения из enum('a','b''c')
+function enumValues(sqlType) {
+  const values = [];
+  const re = /'((?:[^']|'')*)'/g;
+  let match;
+  while ((match = re.exec(sqlType)) !== null) {
+    values.push(match[1].replace(/''/g, "'"));
+  }
+  return values;
+}
+
+function setInput(input, column, value) {
+  if (input.type === "checkbox") {
+    input.checked = Number(value) === 1;
+  } else if (value === null) {
+    input.value = "";
+  } else if (column.type === "datetime") {
+    input.value = String(value).replace(" ", "T");
+  } else {
+    input.value = String(value);
+  }
+}
+
+function readInput(input, column) {
+  if (input.type === "checkbox") {
+    return input.checked ? 1 : 0;
+  }
+  switch (column.type) {
+    case "integer":
+      return input.value === "" ? null : parseInt(input.value, 10);
+    case "float":
+      return input.value === "" ? null : parseFloat(input.value);
+    case "datetime":
+      return input.value.replace("T", " ");
+  }
+  return input.value;
+}
+
+// normalize приводит значение из API к виду readInput, чтобы сравнить с формой
+function normalize(value, column) {
+  if (value === null) {
+    return null;
+  }
+  switch (column.type) {
+    case "integer":
+    case "float":
+      return Number(value);
+  }
+  return String(value);
+}
+
+async function route() {
+  showError(null);
+  const [path, search] = location.hash.replace(/^#\/?/, "").split("?", 2);
+  const [table, id] = path.split("/").map(decodeURIComponent);
+  markActive(table);
+  try {
+    if (!table) {
+      render(el("p", {class: "muted"}, "Выберите таблицу"));
+    } else if (id === "_new") {
+      await showRecord(table, undefined);
+    } else if (id !== undefined) {
+      await showRecord(table, id);
+    } else {
+      await showTable(table, new URLSearchParams(search));
+    }
+  } catch (err) {
+    showError(err);
+  }
+}
+
+window.addEventListener("hashchange", route);
+loadSidebar().then(route).catch(showError);